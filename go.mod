@@ -1,3 +1,12 @@
 module github.com/mccutchen/go-httpbin/v2
 
 go 1.22.0
+
+require (
+	github.com/andybalholm/brotli v1.2.0
+	github.com/klauspost/compress v1.18.0
+	github.com/mssola/useragent v1.0.0
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.2
+)
+
+require golang.org/x/text v0.14.0 // indirect
@@ -27,6 +27,10 @@ const (
 	defaultLogFormat  = "text"
 	defaultEnvPrefix  = "HTTPBIN_ENV_"
 
+	// Matches the server's effective idle timeout when none is configured,
+	// which falls back to the read timeout
+	defaultSrvIdleTimeout = 5 * time.Second
+
 	// Reasonable defaults for our http server
 	srvReadTimeout       = 5 * time.Second
 	srvReadHeaderTimeout = 1 * time.Second
@@ -81,6 +85,11 @@ func mainImpl(args []string, getEnvVal func(string) string, getEnviron func() []
 		httpbin.WithMaxDuration(cfg.MaxDuration),
 		httpbin.WithObserver(httpbin.StdLogObserver(logger)),
 		httpbin.WithExcludeHeaders(cfg.ExcludeHeaders),
+		httpbin.WithServerTimeouts(httpbin.ServerTimeouts{
+			ReadTimeout:       srvReadTimeout,
+			ReadHeaderTimeout: srvReadHeaderTimeout,
+			IdleTimeout:       cfg.SrvIdleTimeout,
+		}),
 	}
 	if cfg.Prefix != "" {
 		opts = append(opts, httpbin.WithPrefix(cfg.Prefix))
@@ -96,9 +105,11 @@ func mainImpl(args []string, getEnvVal func(string) string, getEnviron func() []
 	srv := &http.Server{
 		Addr:              net.JoinHostPort(cfg.ListenHost, strconv.Itoa(cfg.ListenPort)),
 		Handler:           app.Handler(),
+		ConnContext:       app.ConnContext,
 		MaxHeaderBytes:    srvMaxHeaderBytes,
 		ReadHeaderTimeout: srvReadHeaderTimeout,
 		ReadTimeout:       srvReadTimeout,
+		IdleTimeout:       cfg.SrvIdleTimeout,
 	}
 
 	if err := listenAndServeGracefully(srv, cfg, logger); err != nil {
@@ -121,6 +132,7 @@ type config struct {
 	MaxDuration            time.Duration
 	Prefix                 string
 	RealHostname           string
+	SrvIdleTimeout         time.Duration
 	TLSCertFile            string
 	TLSKeyFile             string
 	LogFormat              string
@@ -153,6 +165,7 @@ func loadConfig(args []string, getEnvVal func(string) string, getEnviron func()
 	fs := flag.NewFlagSet("go-httpbin", flag.ContinueOnError)
 	fs.BoolVar(&cfg.rawUseRealHostname, "use-real-hostname", false, "Expose value of os.Hostname() in the /hostname endpoint instead of dummy value")
 	fs.DurationVar(&cfg.MaxDuration, "max-duration", httpbin.DefaultMaxDuration, "Maximum duration a response may take")
+	fs.DurationVar(&cfg.SrvIdleTimeout, "srv-idle-timeout", defaultSrvIdleTimeout, "Maximum time to wait for the next request on an idle keep-alive connection")
 	fs.Int64Var(&cfg.MaxBodySize, "max-body-size", httpbin.DefaultMaxBodySize, "Maximum size of request or response, in bytes")
 	fs.IntVar(&cfg.ListenPort, "port", defaultListenPort, "Port to listen on")
 	fs.StringVar(&cfg.rawAllowedRedirectDomains, "allowed-redirect-domains", "", "Comma-separated list of domains the /redirect-to endpoint will allow")
@@ -208,6 +221,12 @@ func loadConfig(args []string, getEnvVal func(string) string, getEnviron func()
 			return nil, configErr("invalid value %#v for env var MAX_DURATION: parse error", getEnvVal("MAX_DURATION"))
 		}
 	}
+	if cfg.SrvIdleTimeout == defaultSrvIdleTimeout && getEnvVal("SRV_IDLE_TIMEOUT") != "" {
+		cfg.SrvIdleTimeout, err = time.ParseDuration(getEnvVal("SRV_IDLE_TIMEOUT"))
+		if err != nil {
+			return nil, configErr("invalid value %#v for env var SRV_IDLE_TIMEOUT: parse error", getEnvVal("SRV_IDLE_TIMEOUT"))
+		}
+	}
 	if cfg.ListenHost == defaultListenHost && getEnvVal("HOST") != "" {
 		cfg.ListenHost = getEnvVal("HOST")
 	}
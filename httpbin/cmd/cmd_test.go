@@ -40,6 +40,8 @@ const usage = `Usage of go-httpbin:
     	Port to listen on (default 8080)
   -prefix string
     	Path prefix (empty or start with slash and does not end with slash)
+  -srv-idle-timeout duration
+    	Maximum time to wait for the next request on an idle keep-alive connection (default 5s)
   -use-real-hostname
     	Expose value of os.Hostname() in the /hostname endpoint instead of dummy value
 `
@@ -62,11 +64,12 @@ func TestLoadConfig(t *testing.T) {
 	}{
 		"defaults": {
 			wantCfg: &config{
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"-h": {
@@ -82,12 +85,13 @@ func TestLoadConfig(t *testing.T) {
 		"ok env with empty variables": {
 			env: map[string]string{},
 			wantCfg: &config{
-				Env:         nil,
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				Env:            nil,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok env with recognized variables": {
@@ -102,22 +106,24 @@ func TestLoadConfig(t *testing.T) {
 					fmt.Sprintf("%s%sBAR", defaultEnvPrefix, defaultEnvPrefix): "bar",
 					fmt.Sprintf("%s123", defaultEnvPrefix):                     "123",
 				},
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok env with unrecognized variables": {
 			env: map[string]string{"HTTPBIN_FOO": "foo", "BAR": "bar"},
 			wantCfg: &config{
-				Env:         nil,
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				Env:            nil,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 
@@ -133,32 +139,35 @@ func TestLoadConfig(t *testing.T) {
 		"ok -max-body-size": {
 			args: []string{"-max-body-size", "99"},
 			wantCfg: &config{
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: 99,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    99,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok MAX_BODY_SIZE": {
 			env: map[string]string{"MAX_BODY_SIZE": "9999"},
 			wantCfg: &config{
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: 9999,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    9999,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok max body size CLI takes precedence over env": {
 			args: []string{"-max-body-size", "1234"},
 			env:  map[string]string{"MAX_BODY_SIZE": "5678"},
 			wantCfg: &config{
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: 1234,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    1234,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 
@@ -174,32 +183,79 @@ func TestLoadConfig(t *testing.T) {
 		"ok -max-duration": {
 			args: []string{"-max-duration", "99s"},
 			wantCfg: &config{
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: 99 * time.Second,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    99 * time.Second,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok MAX_DURATION": {
 			env: map[string]string{"MAX_DURATION": "9999s"},
 			wantCfg: &config{
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: 9999 * time.Second,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    9999 * time.Second,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok max duration size CLI takes precedence over env": {
 			args: []string{"-max-duration", "1234s"},
 			env:  map[string]string{"MAX_DURATION": "5678s"},
 			wantCfg: &config{
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: 1234 * time.Second,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    1234 * time.Second,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
+			},
+		},
+
+		// server idle timeout
+		"invalid -srv-idle-timeout": {
+			args:    []string{"-srv-idle-timeout", "foo"},
+			wantErr: errors.New("invalid value \"foo\" for flag -srv-idle-timeout: parse error"),
+		},
+		"invalid SRV_IDLE_TIMEOUT": {
+			env:     map[string]string{"SRV_IDLE_TIMEOUT": "foo"},
+			wantErr: errors.New("invalid value \"foo\" for env var SRV_IDLE_TIMEOUT: parse error"),
+		},
+		"ok -srv-idle-timeout": {
+			args: []string{"-srv-idle-timeout", "99s"},
+			wantCfg: &config{
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: 99 * time.Second,
+				LogFormat:      defaultLogFormat,
+			},
+		},
+		"ok SRV_IDLE_TIMEOUT": {
+			env: map[string]string{"SRV_IDLE_TIMEOUT": "9999s"},
+			wantCfg: &config{
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: 9999 * time.Second,
+				LogFormat:      defaultLogFormat,
+			},
+		},
+		"ok srv idle timeout CLI takes precedence over env": {
+			args: []string{"-srv-idle-timeout", "1234s"},
+			env:  map[string]string{"SRV_IDLE_TIMEOUT": "5678s"},
+			wantCfg: &config{
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: 1234 * time.Second,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 
@@ -207,32 +263,35 @@ func TestLoadConfig(t *testing.T) {
 		"ok -host": {
 			args: []string{"-host", "192.0.0.1"},
 			wantCfg: &config{
-				ListenHost:  "192.0.0.1",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "192.0.0.1",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok HOST": {
 			env: map[string]string{"HOST": "192.0.0.2"},
 			wantCfg: &config{
-				ListenHost:  "192.0.0.2",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "192.0.0.2",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok host cli takes precedence over end": {
 			args: []string{"-host", "99.99.99.99"},
 			env:  map[string]string{"HOST": "11.11.11.11"},
 			wantCfg: &config{
-				ListenHost:  "99.99.99.99",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "99.99.99.99",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 
@@ -248,32 +307,35 @@ func TestLoadConfig(t *testing.T) {
 		"ok -port": {
 			args: []string{"-port", "99"},
 			wantCfg: &config{
-				ListenHost:  defaultListenHost,
-				ListenPort:  99,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     defaultListenHost,
+				ListenPort:     99,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok PORT": {
 			env: map[string]string{"PORT": "9999"},
 			wantCfg: &config{
-				ListenHost:  defaultListenHost,
-				ListenPort:  9999,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     defaultListenHost,
+				ListenPort:     9999,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok port CLI takes precedence over env": {
 			args: []string{"-port", "1234"},
 			env:  map[string]string{"PORT": "5678"},
 			wantCfg: &config{
-				ListenHost:  defaultListenHost,
-				ListenPort:  1234,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     defaultListenHost,
+				ListenPort:     1234,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 
@@ -290,23 +352,25 @@ func TestLoadConfig(t *testing.T) {
 			args: []string{"-prefix", "/prefix1"},
 			env:  map[string]string{"PREFIX": "/prefix2"},
 			wantCfg: &config{
-				ListenHost:  defaultListenHost,
-				ListenPort:  defaultListenPort,
-				Prefix:      "/prefix1",
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     defaultListenHost,
+				ListenPort:     defaultListenPort,
+				Prefix:         "/prefix1",
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok PREFIX": {
 			env: map[string]string{"PREFIX": "/prefix2"},
 			wantCfg: &config{
-				ListenHost:  defaultListenHost,
-				ListenPort:  defaultListenPort,
-				Prefix:      "/prefix2",
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     defaultListenHost,
+				ListenPort:     defaultListenPort,
+				Prefix:         "/prefix2",
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 
@@ -325,13 +389,14 @@ func TestLoadConfig(t *testing.T) {
 				"-https-key-file", "/tmp/test.key",
 			},
 			wantCfg: &config{
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				TLSCertFile: "/tmp/test.crt",
-				TLSKeyFile:  "/tmp/test.key",
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				TLSCertFile:    "/tmp/test.crt",
+				TLSKeyFile:     "/tmp/test.key",
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok https env": {
@@ -340,13 +405,14 @@ func TestLoadConfig(t *testing.T) {
 				"HTTPS_KEY_FILE":  "/tmp/test.key",
 			},
 			wantCfg: &config{
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				TLSCertFile: "/tmp/test.crt",
-				TLSKeyFile:  "/tmp/test.key",
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				TLSCertFile:    "/tmp/test.crt",
+				TLSKeyFile:     "/tmp/test.key",
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok https CLI takes precedence over env": {
@@ -359,13 +425,14 @@ func TestLoadConfig(t *testing.T) {
 				"HTTPS_KEY_FILE":  "/tmp/env.key",
 			},
 			wantCfg: &config{
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				TLSCertFile: "/tmp/cli.crt",
-				TLSKeyFile:  "/tmp/cli.key",
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				TLSCertFile:    "/tmp/cli.crt",
+				TLSKeyFile:     "/tmp/cli.key",
+				LogFormat:      defaultLogFormat,
 			},
 		},
 
@@ -373,89 +440,97 @@ func TestLoadConfig(t *testing.T) {
 		"ok -use-real-hostname": {
 			args: []string{"-use-real-hostname"},
 			wantCfg: &config{
-				ListenHost:   "0.0.0.0",
-				ListenPort:   8080,
-				MaxBodySize:  httpbin.DefaultMaxBodySize,
-				MaxDuration:  httpbin.DefaultMaxDuration,
-				RealHostname: testDefaultRealHostname,
-				LogFormat:    defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				RealHostname:   testDefaultRealHostname,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok -use-real-hostname=1": {
 			args: []string{"-use-real-hostname", "1"},
 			wantCfg: &config{
-				ListenHost:   "0.0.0.0",
-				ListenPort:   8080,
-				MaxBodySize:  httpbin.DefaultMaxBodySize,
-				MaxDuration:  httpbin.DefaultMaxDuration,
-				RealHostname: testDefaultRealHostname,
-				LogFormat:    defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				RealHostname:   testDefaultRealHostname,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok -use-real-hostname=true": {
 			args: []string{"-use-real-hostname", "true"},
 			wantCfg: &config{
-				ListenHost:   "0.0.0.0",
-				ListenPort:   8080,
-				MaxBodySize:  httpbin.DefaultMaxBodySize,
-				MaxDuration:  httpbin.DefaultMaxDuration,
-				RealHostname: testDefaultRealHostname,
-				LogFormat:    defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				RealHostname:   testDefaultRealHostname,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		// any value for the argument is interpreted as true
 		"ok -use-real-hostname=0": {
 			args: []string{"-use-real-hostname", "0"},
 			wantCfg: &config{
-				ListenHost:   "0.0.0.0",
-				ListenPort:   8080,
-				MaxBodySize:  httpbin.DefaultMaxBodySize,
-				MaxDuration:  httpbin.DefaultMaxDuration,
-				RealHostname: testDefaultRealHostname,
-				LogFormat:    defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				RealHostname:   testDefaultRealHostname,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok USE_REAL_HOSTNAME=1": {
 			env: map[string]string{"USE_REAL_HOSTNAME": "1"},
 			wantCfg: &config{
-				ListenHost:   "0.0.0.0",
-				ListenPort:   8080,
-				MaxBodySize:  httpbin.DefaultMaxBodySize,
-				MaxDuration:  httpbin.DefaultMaxDuration,
-				RealHostname: testDefaultRealHostname,
-				LogFormat:    defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				RealHostname:   testDefaultRealHostname,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok USE_REAL_HOSTNAME=true": {
 			env: map[string]string{"USE_REAL_HOSTNAME": "true"},
 			wantCfg: &config{
-				ListenHost:   "0.0.0.0",
-				ListenPort:   8080,
-				MaxBodySize:  httpbin.DefaultMaxBodySize,
-				MaxDuration:  httpbin.DefaultMaxDuration,
-				RealHostname: testDefaultRealHostname,
-				LogFormat:    defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				RealHostname:   testDefaultRealHostname,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		// case sensitive
 		"ok USE_REAL_HOSTNAME=TRUE": {
 			env: map[string]string{"USE_REAL_HOSTNAME": "TRUE"},
 			wantCfg: &config{
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok USE_REAL_HOSTNAME=false": {
 			env: map[string]string{"USE_REAL_HOSTNAME": "false"},
 			wantCfg: &config{
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"err real hostname error": {
@@ -472,6 +547,7 @@ func TestLoadConfig(t *testing.T) {
 				ListenPort:             8080,
 				MaxBodySize:            httpbin.DefaultMaxBodySize,
 				MaxDuration:            httpbin.DefaultMaxDuration,
+				SrvIdleTimeout:         defaultSrvIdleTimeout,
 				AllowedRedirectDomains: []string{"foo", "bar"},
 				LogFormat:              defaultLogFormat,
 			},
@@ -483,6 +559,7 @@ func TestLoadConfig(t *testing.T) {
 				ListenPort:             8080,
 				MaxBodySize:            httpbin.DefaultMaxBodySize,
 				MaxDuration:            httpbin.DefaultMaxDuration,
+				SrvIdleTimeout:         defaultSrvIdleTimeout,
 				AllowedRedirectDomains: []string{"foo", "bar"},
 				LogFormat:              defaultLogFormat,
 			},
@@ -495,6 +572,7 @@ func TestLoadConfig(t *testing.T) {
 				ListenPort:             8080,
 				MaxBodySize:            httpbin.DefaultMaxBodySize,
 				MaxDuration:            httpbin.DefaultMaxDuration,
+				SrvIdleTimeout:         defaultSrvIdleTimeout,
 				AllowedRedirectDomains: []string{"foo.cli", "bar.cli"},
 				LogFormat:              defaultLogFormat,
 			},
@@ -506,6 +584,7 @@ func TestLoadConfig(t *testing.T) {
 				ListenPort:             8080,
 				MaxBodySize:            httpbin.DefaultMaxBodySize,
 				MaxDuration:            httpbin.DefaultMaxDuration,
+				SrvIdleTimeout:         defaultSrvIdleTimeout,
 				AllowedRedirectDomains: []string{"foo", "bar", "baz"},
 				LogFormat:              defaultLogFormat,
 			},
@@ -513,40 +592,44 @@ func TestLoadConfig(t *testing.T) {
 		"ok use json log format": {
 			args: []string{"-log-format", "json"},
 			wantCfg: &config{
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   "json",
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      "json",
 			},
 		},
 		"ok use text log format": {
 			args: []string{"-log-format", "text"},
 			wantCfg: &config{
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   "text",
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      "text",
 			},
 		},
 		"ok use default log format": {
 			wantCfg: &config{
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   defaultLogFormat,
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      defaultLogFormat,
 			},
 		},
 		"ok use json log format using LOG_FORMAT env": {
 			env: map[string]string{"LOG_FORMAT": "json"},
 			wantCfg: &config{
-				ListenHost:  "0.0.0.0",
-				ListenPort:  8080,
-				MaxBodySize: httpbin.DefaultMaxBodySize,
-				MaxDuration: httpbin.DefaultMaxDuration,
-				LogFormat:   "json",
+				ListenHost:     "0.0.0.0",
+				ListenPort:     8080,
+				MaxBodySize:    httpbin.DefaultMaxBodySize,
+				MaxDuration:    httpbin.DefaultMaxDuration,
+				SrvIdleTimeout: defaultSrvIdleTimeout,
+				LogFormat:      "json",
 			},
 		},
 	}
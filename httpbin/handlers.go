@@ -4,16 +4,33 @@ import (
 	"bytes"
 	"compress/gzip"
 	"compress/zlib"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"mime"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"path"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/mssola/useragent"
+	"github.com/santhosh-tekuri/jsonschema/v6"
 
 	"github.com/mccutchen/go-httpbin/v2/httpbin/digest"
 	"github.com/mccutchen/go-httpbin/v2/httpbin/websocket"
@@ -21,14 +38,10 @@ import (
 
 var nilValues = url.Values{}
 
-func notImplementedHandler(w http.ResponseWriter, _ *http.Request) {
-	writeError(w, http.StatusNotImplemented, nil)
-}
-
 // Index renders an HTML index page
 func (h *HTTPBin) Index(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
-		writeError(w, http.StatusNotFound, nil)
+		h.writeError(w, http.StatusNotFound, nil)
 		return
 	}
 	w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'self' 'unsafe-inline'; img-src 'self' camo.githubusercontent.com")
@@ -42,6 +55,26 @@ func (h *HTTPBin) Env(w http.ResponseWriter, _ *http.Request) {
 	})
 }
 
+// Config reports the timeouts in effect, including those of the server if
+// given via WithServerTimeouts.
+func (h *HTTPBin) Config(w http.ResponseWriter, _ *http.Request) {
+	formatTimeout := func(d time.Duration) string {
+		if d <= 0 {
+			return ""
+		}
+		return d.String()
+	}
+	writeJSON(http.StatusOK, w, &configResponse{
+		Timeouts: timeoutsResponse{
+			MaxDuration:          h.limits.Load().maxDuration.String(),
+			BodyReadTimeout:      formatTimeout(h.bodyReadTimeout),
+			SrvReadTimeout:       formatTimeout(h.serverTimeouts.ReadTimeout),
+			SrvReadHeaderTimeout: formatTimeout(h.serverTimeouts.ReadHeaderTimeout),
+			SrvIdleTimeout:       formatTimeout(h.serverTimeouts.IdleTimeout),
+		},
+	})
+}
+
 // FormsPost renders an HTML form that submits a request to the /post endpoint
 func (h *HTTPBin) FormsPost(w http.ResponseWriter, _ *http.Request) {
 	writeHTML(w, h.formsPostHTML, http.StatusOK)
@@ -54,17 +87,62 @@ func (h *HTTPBin) UTF8(w http.ResponseWriter, _ *http.Request) {
 
 // Get handles HTTP GET requests
 func (h *HTTPBin) Get(w http.ResponseWriter, r *http.Request) {
-	writeJSON(http.StatusOK, w, &noBodyResponse{
+	start := time.Now()
+
+	if err := checkStrictQuery(r); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	etag, err := parseBoolParam(r.URL.Query(), "etag")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var ifNoneMatch string
+	if etag {
+		ifNoneMatch = takeIfNoneMatch(r)
+	}
+	resp := &noBodyResponse{
 		Args:    r.URL.Query(),
 		Headers: getRequestHeaders(r, h.excludeHeadersProcessor),
 		Method:  r.Method,
 		Origin:  getClientIP(r),
 		URL:     getURL(r).String(),
-	})
+		Route:   h.getRoute(r),
+		Path:    r.URL.Path,
+		RawPath: getRawPath(r),
+
+		TLSServerName: getTLSServerName(r),
+		ReceivedAt:    formatReceivedAt(start),
+	}
+	queryParamCount, headerCount := len(resp.Args), len(resp.Headers)
+	resp.QueryParamCount = &queryParamCount
+	resp.HeaderCount = &headerCount
+	if h.includeConnectionInfo {
+		resp.Connection = getConnectionInfo(r)
+	}
+	if etag {
+		// omit the timestamp and client port so that identical requests get
+		// identical ETags, even across connections
+		resp.ReceivedAt = ""
+		resp.Origin = stripPort(resp.Origin)
+		writeJSONWithETag(http.StatusOK, w, ifNoneMatch, resp)
+		return
+	}
+	writeJSON(http.StatusOK, w, resp)
 }
 
 // Anything returns anything that is passed to request.
 func (h *HTTPBin) Anything(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	if err := checkStrictQuery(r); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if mirrorHeaders := r.URL.Query().Get("mirror_headers"); mirrorHeaders != "" {
+		h.mirrorHeaders(w, r, mirrorHeaders)
+	}
 	// Short-circuit for HEAD requests, which should be handled like regular
 	// GET requests (where the autohead middleware will take care of discarding
 	// the body)
@@ -72,14 +150,222 @@ func (h *HTTPBin) Anything(w http.ResponseWriter, r *http.Request) {
 		h.Get(w, r)
 		return
 	}
+
+	q := r.URL.Query()
+	decodeJWT, err := parseBoolParam(q, "decode_jwt")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	timing, err := parseBoolParam(q, "timing")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	negotiateEncoding, err := parseBoolParam(q, "negotiate_encoding")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	headersHash := q.Get("headers_hash")
+	if headersHash != "" && headersHash != "sha256" {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid headers_hash: %q must be sha256", headersHash))
+		return
+	}
+	encoding := q.Get("encoding")
+	if encoding != "" && encoding != "hex" {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid encoding: %q must be hex", encoding))
+		return
+	}
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "har" {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid format: %q must be one of json, har", format))
+		return
+	}
+	status, err := parseConditionalStatus(r)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	truncate, err := parseBoolParam(q, "truncate")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	includeDump, err := parseBoolParam(q, "include_dump")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if includeDump && truncate {
+		h.writeError(w, http.StatusBadRequest, errors.New("include_dump cannot be combined with truncate"))
+		return
+	}
+	entropy, err := parseBoolParam(q, "entropy")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var dump []byte
+	if includeDump {
+		// DumpRequest restores the request body after reading it, so that it
+		// may still be parsed below
+		dump, err = httputil.DumpRequest(r, true)
+		if err != nil {
+			h.writeError(w, bodyErrorStatus(err), fmt.Errorf("failed to dump request: %w", err))
+			return
+		}
+	}
+	if truncate && r.Body != nil {
+		r.Body = &truncatedBodyReader{r: r.Body, n: h.requestMaxBodySize(r)}
+	}
+	compressResponse := q.Get("compress_response")
+	if compressResponse != "" && compressResponse != "gzip" && compressResponse != "deflate" {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid compress_response: %q must be one of gzip, deflate", compressResponse))
+		return
+	}
+	etag, err := parseBoolParam(q, "etag")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if etag && compressResponse != "" {
+		h.writeError(w, http.StatusBadRequest, errors.New("etag cannot be combined with compress_response"))
+		return
+	}
+	var ifNoneMatch string
+	if etag {
+		ifNoneMatch = takeIfNoneMatch(r)
+	}
+
 	// All other requests will be handled the same.  For compatibility with
 	// httpbin, the /anything endpoint even allows GET requests to have bodies.
-	h.RequestWithBody(w, r)
+	resp, err := h.newBodyResponse(r)
+	if err != nil {
+		h.writeError(w, bodyErrorStatus(err), err)
+		return
+	}
+	queryParamCount, headerCount := len(resp.Args), len(resp.Headers)
+	resp.QueryParamCount = &queryParamCount
+	resp.HeaderCount = &headerCount
+	resp.ClientCertChain = getClientCertChain(r)
+
+	if encoding == "hex" {
+		body := resp.rawBody
+		if maxResponseBodySize := h.limits.Load().maxResponseBodySize; int64(len(body)) > maxResponseBodySize {
+			body = body[:maxResponseBodySize]
+		}
+		resp.Data = hex.EncodeToString(body)
+	}
+
+	if entropy {
+		e := shannonEntropy(resp.rawBody)
+		resp.Entropy = &e
+	}
+
+	resp.RequestLine = getRequestLine(r)
+	resp.ProtoMajor, resp.ProtoMinor = &r.ProtoMajor, &r.ProtoMinor
+	resp.ExpectContinue = strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+	resp.AuthScheme = newAuthSchemeResponse(r.Header.Get("Authorization"))
+	if includeDump {
+		resp.Dump = truncateUTF8(string(dump), int(h.maxBodySize()))
+	}
+	if etag {
+		// omit the timestamp and connection details so that identical
+		// requests get identical ETags, even across connections
+		resp.Origin = stripPort(resp.Origin)
+	} else {
+		resp.ReceivedAt = formatReceivedAt(start)
+		resp.ConnectionReused = getConnectionReused(r)
+	}
+	_, resp.SchemeSource = getScheme(r)
+	if h.includeConnectionInfo {
+		resp.Connection = getConnectionInfo(r)
+	}
+
+	if decodeJWT {
+		resp.JWT, err = decodeBearerJWT(r.Header.Get("Authorization"))
+		if err != nil {
+			resp.JWTError = err.Error()
+		}
+	}
+
+	if negotiateEncoding {
+		accepted := parseAcceptEncoding(r.Header.Get("Accept-Encoding"))
+		resp.EncodingNegotiation = &encodingNegotiationResponse{
+			Accepted: accepted,
+			Chosen:   negotiateContentEncoding(accepted),
+		}
+	}
+
+	if headersHash != "" {
+		resp.HeadersHash = hashHeaders(resp.Headers)
+	}
+
+	if timing {
+		resp.Timing = newTimingResponse(start, resp.bodyReadStart, resp.bodyReadEnd, time.Now())
+	}
+
+	var body interface{} = resp
+	if format == "har" {
+		body = newHARResponse(r, resp, start)
+	}
+	if compressResponse != "" {
+		h.writeCompressedJSON(w, status, compressResponse, h.compressionLevel, body)
+		return
+	}
+	if etag {
+		writeJSONWithETag(status, w, ifNoneMatch, body)
+		return
+	}
+	writeJSON(status, w, body)
+}
+
+// unmirroredHeaders are request headers that are never copied into the
+// response by the ?mirror_headers param, because they describe the framing of
+// the request rather than the response
+var unmirroredHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Encoding":  true,
+	"Content-Length":    true,
+	"Keep-Alive":        true,
+	"Te":                true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// mirrorHeaders copies the request headers matching the given comma-separated
+// list of wildcard patterns into the response headers.
+func (h *HTTPBin) mirrorHeaders(w http.ResponseWriter, r *http.Request, patterns string) {
+	regex := createFullExcludeRegex(patterns)
+	if regex == nil {
+		return
+	}
+	for k, values := range getRequestHeaders(r, h.excludeHeadersProcessor) {
+		if unmirroredHeaders[k] || !regex.MatchString(k) {
+			continue
+		}
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
 }
 
 // RequestWithBody handles POST, PUT, and PATCH requests by responding with a
 // JSON representation of the incoming request.
 func (h *HTTPBin) RequestWithBody(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.newBodyResponse(r)
+	if err != nil {
+		h.writeError(w, bodyErrorStatus(err), err)
+		return
+	}
+	writeJSON(http.StatusOK, w, resp)
+}
+
+// newBodyResponse returns a JSON-serializable representation of the incoming
+// request, including its parsed body.
+func (h *HTTPBin) newBodyResponse(r *http.Request) (*bodyResponse, error) {
 	resp := &bodyResponse{
 		Args:    r.URL.Query(),
 		Files:   nilValues,
@@ -88,22 +374,79 @@ func (h *HTTPBin) RequestWithBody(w http.ResponseWriter, r *http.Request) {
 		Method:  r.Method,
 		Origin:  getClientIP(r),
 		URL:     getURL(r).String(),
+		Route:   h.getRoute(r),
+		Path:    r.URL.Path,
+		RawPath: getRawPath(r),
+
+		TLSServerName: getTLSServerName(r),
+
+		TransferEncoding: r.TransferEncoding,
+
+		IdempotencyKey: r.Header.Get("Idempotency-Key"),
+	}
+	if resp.TransferEncoding == nil {
+		resp.TransferEncoding = []string{}
 	}
 
-	if err := parseBody(r, resp); err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("error parsing request body: %w", err))
-		return
+	if err := checkRequiredContentType(r); err != nil {
+		return nil, err
 	}
 
-	writeJSON(http.StatusOK, w, resp)
+	// Go no longer treats ; as a separator in query strings and form bodies,
+	// but the legacy behavior may be requested explicitly
+	allowSemicolons, err := parseBoolParam(r.URL.Query(), "semicolon")
+	if err != nil {
+		return nil, err
+	}
+	if allowSemicolons {
+		resp.Args, err = parseSemicolonValues(r.URL.RawQuery)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing query: %w", err)
+		}
+	}
+
+	// A body truncated via the /anything endpoint's ?truncate param may not
+	// parse according to its content type, in which case only its raw data is
+	// reported
+	truncatedBody, _ := r.Body.(*truncatedBodyReader)
+	if err := parseBody(r, resp, allowSemicolons, h.maxBodySize()); err != nil && (truncatedBody == nil || !truncatedBody.truncated) {
+		return nil, fmt.Errorf("error parsing request body: %w", err)
+	}
+	if truncatedBody != nil && truncatedBody.truncated {
+		resp.JSON = nil
+		resp.Truncated = true
+	}
+
+	// Truncate echoed body data that would bloat the response. The limit
+	// applies to the raw body, before any base64 encoding, and the parsed
+	// JSON cannot be partially represented so it is omitted and flagged
+	if maxResponseBodySize := h.limits.Load().maxResponseBodySize; int64(len(resp.rawBody)) > maxResponseBodySize {
+		contentType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+		resp.Data = truncateBodyData(resp.rawBody, contentType, int(maxResponseBodySize))
+		if resp.JSON != nil {
+			resp.JSON = nil
+			resp.JSONTruncated = true
+		}
+		resp.Truncated = true
+	}
+	return resp, nil
 }
 
-// Gzip returns a gzipped response
+// Gzip returns a gzipped response, compressed at the level given by the
+// optional ?level query param.
 func (h *HTTPBin) Gzip(w http.ResponseWriter, r *http.Request) {
-	var (
-		buf bytes.Buffer
-		gzw = gzip.NewWriter(&buf)
-	)
+	level, err := h.getCompressionLevel(r)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	gzw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
 	mustMarshalJSON(gzw, &noBodyResponse{
 		Args:    r.URL.Query(),
 		Headers: getRequestHeaders(r, h.excludeHeadersProcessor),
@@ -121,12 +464,21 @@ func (h *HTTPBin) Gzip(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
-// Deflate returns a gzipped response
+// Deflate returns a deflated response, compressed at the level given by the
+// optional ?level query param.
 func (h *HTTPBin) Deflate(w http.ResponseWriter, r *http.Request) {
-	var (
-		buf bytes.Buffer
-		zw  = zlib.NewWriter(&buf)
-	)
+	level, err := h.getCompressionLevel(r)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, level)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
 	mustMarshalJSON(zw, &noBodyResponse{
 		Args:     r.URL.Query(),
 		Headers:  getRequestHeaders(r, h.excludeHeadersProcessor),
@@ -144,6 +496,74 @@ func (h *HTTPBin) Deflate(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
+// getRoute returns the registered route pattern that matches the given
+// request (e.g. "/status/{code}"), relative to any configured prefix.
+func (h *HTTPBin) getRoute(r *http.Request) string {
+	_, pattern := h.mux.Handler(r)
+	return pattern
+}
+
+// getCompressionLevel returns the compression level requested via the ?level
+// query param, falling back to the server's default level.
+func (h *HTTPBin) getCompressionLevel(r *http.Request) (int, error) {
+	userLevel := r.URL.Query().Get("level")
+	if userLevel == "" {
+		return h.compressionLevel, nil
+	}
+	return parseCompressionLevel(userLevel)
+}
+
+// Brotli returns a brotli-encoded response
+func (h *HTTPBin) Brotli(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	bw := brotli.NewWriter(&buf)
+	mustMarshalJSON(bw, &noBodyResponse{
+		Args:    r.URL.Query(),
+		Headers: getRequestHeaders(r, h.excludeHeadersProcessor),
+		Method:  r.Method,
+		Origin:  getClientIP(r),
+		Brotli:  true,
+	})
+	bw.Close()
+
+	body := buf.Bytes()
+	w.Header().Set("Content-Encoding", "br")
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// zstdEncoder is shared by every /zstd response, since encoders are costly to
+// create and EncodeAll may be called concurrently. NewWriter only fails given
+// invalid options.
+var zstdEncoder = func() *zstd.Encoder {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		panic(err)
+	}
+	return enc
+}()
+
+// Zstd returns a zstd-encoded response
+func (h *HTTPBin) Zstd(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	mustMarshalJSON(&buf, &noBodyResponse{
+		Args:    r.URL.Query(),
+		Headers: getRequestHeaders(r, h.excludeHeadersProcessor),
+		Method:  r.Method,
+		Origin:  getClientIP(r),
+		Zstd:    true,
+	})
+
+	body := zstdEncoder.EncodeAll(buf.Bytes(), nil)
+	w.Header().Set("Content-Encoding", "zstd")
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
 // IP echoes the IP address of the incoming request
 func (h *HTTPBin) IP(w http.ResponseWriter, r *http.Request) {
 	writeJSON(http.StatusOK, w, &ipResponse{
@@ -158,11 +578,51 @@ func (h *HTTPBin) UserAgent(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// UserAgentParse returns the incoming request's User-Agent header parsed into
+// its client family, version, and operating system
+func (h *HTTPBin) UserAgentParse(w http.ResponseWriter, r *http.Request) {
+	raw := r.Header.Get("User-Agent")
+	ua := useragent.New(raw)
+	family, version := ua.Browser()
+	osInfo := ua.OSInfo()
+	resp := &userAgentParseResponse{
+		Raw:       raw,
+		Family:    family,
+		Version:   version,
+		OS:        osInfo.Name,
+		OSVersion: osInfo.Version,
+	}
+	// like ua-parser, report an unrecognized family or OS as "Other"
+	if resp.Family == "" {
+		resp.Family = "Other"
+	}
+	if resp.OS == "" {
+		resp.OS = "Other"
+	}
+	writeJSON(http.StatusOK, w, resp)
+}
+
 // Headers echoes the incoming request headers
 func (h *HTTPBin) Headers(w http.ResponseWriter, r *http.Request) {
-	writeJSON(http.StatusOK, w, &headersResponse{
-		Headers: getRequestHeaders(r, h.excludeHeadersProcessor),
-	})
+	headers := getRequestHeaders(r, h.excludeHeadersProcessor)
+	if prefix := r.URL.Query().Get("prefix"); prefix != "" {
+		headers = createIncludeHeadersPrefixProcessor(prefix)(headers)
+	}
+	withCounts, err := parseBoolParam(r.URL.Query(), "with_counts")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	resp := &headersResponse{
+		Headers: headers,
+	}
+	if withCounts {
+		resp.Counts = make(map[string]int, len(headers))
+		for k, values := range headers {
+			resp.Counts[k] = len(values)
+		}
+	}
+	writeJSON(http.StatusOK, w, resp)
 }
 
 type statusCase struct {
@@ -261,25 +721,96 @@ func createSpecialCases(prefix string) map[int]*statusCase {
 func (h *HTTPBin) Status(w http.ResponseWriter, r *http.Request) {
 	rawStatus := r.PathValue("code")
 
-	// simple case, specific status code is requested
+	var linger time.Duration
+	if userLinger := r.URL.Query().Get("linger"); userLinger != "" {
+		var err error
+		linger, err = parseBoundedDuration(userLinger, 0, h.maxDuration(r))
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid linger: %w", err))
+			return
+		}
+	}
+
+	var code int
 	if !strings.Contains(rawStatus, ",") {
-		code, err := parseStatusCode(rawStatus)
+		// simple case, specific status code is requested
+		var err error
+		code, err = parseStatusCode(rawStatus)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, err)
+			h.writeError(w, http.StatusBadRequest, err)
 			return
 		}
+	} else {
+		// complex case, make a weighted choice from multiple status codes
+		choices, err := parseWeightedChoices(rawStatus, strconv.Atoi)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		code = weightedRandomChoice(choices)
+	}
+
+	if linger == 0 {
 		h.doStatus(w, code)
 		return
 	}
 
-	// complex case, make a weighted choice from multiple status codes
-	choices, err := parseWeightedChoices(rawStatus, strconv.Atoi)
+	// Complete the response up front, then hold the connection open without
+	// writing anything more before closing it. An explicit Content-Length
+	// lets the client see the response is complete despite the early flush.
+	w.Header().Set("Connection", "close")
+	if bodyAllowedForStatus(code) {
+		var body []byte
+		if specialCase, ok := h.statusSpecialCases[code]; ok {
+			body = specialCase.body
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	h.doStatus(w, code)
+	w.(http.Flusher).Flush()
+	select {
+	case <-r.Context().Done():
+	case <-time.After(linger):
+	}
+}
+
+// StatusSequence responds with each status code from a comma-separated
+// sequence in turn, across successive requests sharing the same key. Once the
+// sequence is exhausted, the last code is repeated unless the ?cycle param
+// requests that the sequence start over.
+func (h *HTTPBin) StatusSequence(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	key := q.Get("key")
+	if key == "" {
+		h.writeError(w, http.StatusBadRequest, errors.New("missing required key param"))
+		return
+	}
+
+	rawCodes := strings.Split(r.PathValue("codes"), ",")
+	codes := make([]int, 0, len(rawCodes))
+	for _, rawCode := range rawCodes {
+		code, err := parseStatusCode(strings.TrimSpace(rawCode))
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		codes = append(codes, code)
+	}
+
+	cycle, err := parseBoolParam(q, "cycle")
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err)
+		h.writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	choice := weightedRandomChoice(choices)
-	h.doStatus(w, choice)
+
+	idx := h.statusSequenceCounter.Increment(key, time.Now()) - 1
+	if cycle {
+		idx %= len(codes)
+	} else if idx >= len(codes) {
+		idx = len(codes) - 1
+	}
+	h.doStatus(w, codes[idx])
 }
 
 func (h *HTTPBin) doStatus(w http.ResponseWriter, code int) {
@@ -306,7 +837,7 @@ func (h *HTTPBin) Unstable(w http.ResponseWriter, r *http.Request) {
 	// rng/seed
 	rng, err := parseSeed(r.URL.Query().Get("seed"))
 	if err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid seed: %w", err))
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid seed: %w", err))
 		return
 	}
 
@@ -315,23 +846,139 @@ func (h *HTTPBin) Unstable(w http.ResponseWriter, r *http.Request) {
 	if rawFailureRate := r.URL.Query().Get("failure_rate"); rawFailureRate != "" {
 		failureRate, err = strconv.ParseFloat(rawFailureRate, 64)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid failure rate: %w", err))
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid failure rate: %w", err))
 			return
 		} else if failureRate < 0 || failureRate > 1 {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid failure rate: %d not in range [0, 1]", err))
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid failure rate: %d not in range [0, 1]", err))
+			return
+		}
+	}
+
+	// body
+	withBody, err := parseBoolParam(r.URL.Query(), "body")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// max_delay
+	var maxDelay time.Duration
+	if rawMaxDelay := r.URL.Query().Get("max_delay"); rawMaxDelay != "" {
+		maxDelay, err = parseBoundedDuration(rawMaxDelay, 0, h.maxDuration(r))
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max_delay: %w", err))
 			return
 		}
 	}
 
 	status := http.StatusOK
-	if rng.Float64() < failureRate {
+	roll := rng.Float64()
+	if roll < failureRate {
 		status = http.StatusInternalServerError
 	}
 
+	// The delay is drawn after the outcome, so that adding max_delay does not
+	// change the outcome for a given seed
+	if maxDelay > 0 {
+		delay := time.Duration(rng.Float64() * float64(maxDelay))
+		select {
+		case <-r.Context().Done():
+			h.writeContextDone(w, r)
+			return
+		case <-time.After(delay):
+		}
+		w.Header().Set("Server-Timing", encodeServerTimings([]serverTiming{
+			{"initial_delay", delay, "initial delay"},
+		}))
+	}
+
+	if withBody {
+		writeJSON(status, w, unstableResponse{
+			Status:      status,
+			Failed:      status != http.StatusOK,
+			FailureRate: failureRate,
+			Roll:        roll,
+		})
+		return
+	}
+
 	w.Header().Set("Content-Type", textContentType)
 	w.WriteHeader(status)
 }
 
+// Flaky returns 500 for the first success_after requests made with a given
+// key, and 200 thereafter, for deterministically testing retry loops.
+func (h *HTTPBin) Flaky(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	key := q.Get("key")
+	if key == "" {
+		h.writeError(w, http.StatusBadRequest, errors.New("missing required key param"))
+		return
+	}
+
+	successAfter := 1
+	if rawSuccessAfter := q.Get("success_after"); rawSuccessAfter != "" {
+		var err error
+		successAfter, err = strconv.Atoi(rawSuccessAfter)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid success_after: %w", err))
+			return
+		} else if successAfter < 0 {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid success_after: %d must be non-negative", successAfter))
+			return
+		}
+	}
+
+	attempt := h.flakyCounter.Increment(key, time.Now())
+	status := http.StatusOK
+	if attempt <= successAfter {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(status, w, flakyResponse{
+		Key:          key,
+		Status:       status,
+		Attempt:      attempt,
+		SuccessAfter: successAfter,
+	})
+}
+
+// ValidateJSON validates a JSON request body against the JSON Schema given in
+// the schema query param, reporting any validation errors.
+func (h *HTTPBin) ValidateJSON(w http.ResponseWriter, r *http.Request) {
+	rawSchema := r.URL.Query().Get("schema")
+	if rawSchema == "" {
+		h.writeError(w, http.StatusBadRequest, errors.New("missing required schema param"))
+		return
+	}
+	if int64(len(rawSchema)) > h.maxBodySize() {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid schema: size %d exceeds maximum of %d bytes", len(rawSchema), h.maxBodySize()))
+		return
+	}
+	schema, err := compileJSONSchema([]byte(rawSchema))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid schema: %w", err))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeError(w, bodyErrorStatus(err), fmt.Errorf("error reading request body: %w", err))
+		return
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(body))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid JSON body: %w", err))
+		return
+	}
+
+	errs := jsonValidationErrors(schema.Validate(doc))
+	writeJSON(http.StatusOK, w, &validateJSONResponse{
+		Valid:  len(errs) == 0,
+		Errors: errs,
+	})
+}
+
 // ResponseHeaders responds with a map of header values
 func (h *HTTPBin) ResponseHeaders(w http.ResponseWriter, r *http.Request) {
 	args := r.URL.Query()
@@ -349,6 +996,7 @@ func (h *HTTPBin) ResponseHeaders(w http.ResponseWriter, r *http.Request) {
 func (h *HTTPBin) redirectLocation(r *http.Request, relative bool, n int) string {
 	var location string
 	var path string
+	var query string
 
 	if n < 1 {
 		path = "/get"
@@ -358,12 +1006,20 @@ func (h *HTTPBin) redirectLocation(r *http.Request, relative bool, n int) string
 		path = fmt.Sprintf("/absolute-redirect/%d", n)
 	}
 
+	// carry any per-hop delay through to the remaining intermediate hops
+	if delay := r.URL.Query().Get("delay_per_hop"); delay != "" && n > 0 {
+		query = url.Values{"delay_per_hop": {delay}}.Encode()
+	}
+
 	if relative {
 		location = path
+		if query != "" {
+			location += "?" + query
+		}
 	} else {
 		u := getURL(r)
 		u.Path = path
-		u.RawQuery = ""
+		u.RawQuery = query
 		location = u.String()
 	}
 
@@ -373,12 +1029,31 @@ func (h *HTTPBin) redirectLocation(r *http.Request, relative bool, n int) string
 func (h *HTTPBin) handleRedirect(w http.ResponseWriter, r *http.Request, relative bool) {
 	n, err := strconv.Atoi(r.PathValue("numRedirects"))
 	if err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid redirect count: %w", err))
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid redirect count: %w", err))
 		return
 	} else if n < 1 {
-		writeError(w, http.StatusBadRequest, errors.New("redirect count must be > 0"))
+		h.writeError(w, http.StatusBadRequest, errors.New("redirect count must be > 0"))
 		return
 	}
+
+	if rawDelay := r.URL.Query().Get("delay_per_hop"); rawDelay != "" {
+		delay, err := parseBoundedDuration(rawDelay, 0, h.maxDuration(r))
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid delay_per_hop: %w", err))
+			return
+		}
+		if total := time.Duration(n) * delay; total > h.maxDuration(r) {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid delay_per_hop: total delay %s for %d redirects exceeds max duration %s", total, n, h.maxDuration(r)))
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			h.writeContextDone(w, r)
+			return
+		case <-time.After(delay):
+		}
+	}
+
 	h.doRedirect(w, h.redirectLocation(r, relative, n-1), http.StatusFound)
 }
 
@@ -408,13 +1083,13 @@ func (h *HTTPBin) RedirectTo(w http.ResponseWriter, r *http.Request) {
 
 	inputURL := q.Get("url")
 	if inputURL == "" {
-		writeError(w, http.StatusBadRequest, errors.New("missing required query parameter: url"))
+		h.writeError(w, http.StatusBadRequest, errors.New("missing required query parameter: url"))
 		return
 	}
 
 	u, err := url.Parse(inputURL)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid url: %w", err))
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid url: %w", err))
 		return
 	}
 
@@ -425,11 +1100,12 @@ func (h *HTTPBin) RedirectTo(w http.ResponseWriter, r *http.Request) {
 	// IsAbs() method, because IsAbs() will return false for URLs that omit
 	// the scheme but include a domain name, like "//evil.com" and it's
 	// important that we validate the domain in these cases as well.
-	if u.Hostname() != "" && len(h.AllowedRedirectDomains) > 0 {
-		if _, ok := h.AllowedRedirectDomains[u.Hostname()]; !ok {
+	limits := h.limits.Load()
+	if u.Hostname() != "" && len(limits.allowedRedirectDomains) > 0 {
+		if _, ok := limits.allowedRedirectDomains[u.Hostname()]; !ok {
 			// for this error message we do not use our standard JSON response
 			// because we want it to be more obviously human readable.
-			writeResponse(w, http.StatusForbidden, "text/plain", []byte(h.forbiddenRedirectError))
+			writeResponse(w, http.StatusForbidden, "text/plain", []byte(limits.forbiddenRedirectError))
 			return
 		}
 	}
@@ -438,11 +1114,21 @@ func (h *HTTPBin) RedirectTo(w http.ResponseWriter, r *http.Request) {
 	if userStatusCode := q.Get("status_code"); userStatusCode != "" {
 		statusCode, err = parseBoundedStatusCode(userStatusCode, 300, 399)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, err)
+			h.writeError(w, http.StatusBadRequest, err)
 			return
 		}
 	}
 
+	withHeaders, err := parseBoolParam(q, "with_headers")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if withHeaders {
+		w.Header().Set("X-Original-Method", r.Method)
+		w.Header().Set("X-Original-URL", getURL(r).String())
+	}
+
 	h.doRedirect(w, u.String(), statusCode)
 }
 
@@ -455,6 +1141,12 @@ func (h *HTTPBin) Cookies(w http.ResponseWriter, r *http.Request) {
 	writeJSON(http.StatusOK, w, resp)
 }
 
+// RawCookies returns the cookies parsed directly from the raw Cookie header,
+// along with any $-prefixed attributes sent by legacy RFC 2965 clients.
+func (h *HTTPBin) RawCookies(w http.ResponseWriter, r *http.Request) {
+	writeJSON(http.StatusOK, w, parseRawCookies(r.Header.Values("Cookie")))
+}
+
 // SetCookies sets cookies as specified in query params and redirects to
 // Cookies endpoint
 func (h *HTTPBin) SetCookies(w http.ResponseWriter, r *http.Request) {
@@ -485,18 +1177,72 @@ func (h *HTTPBin) DeleteCookies(w http.ResponseWriter, r *http.Request) {
 	h.doRedirect(w, "/cookies", http.StatusFound)
 }
 
+// CORSPreflightDebug describes the CORS headers that would be applied in
+// response to a hypothetical preflight request, without actually performing
+// the preflight. The preflight's origin, method, and headers may be given via
+// the ?origin, ?request_method, and ?request_headers query params, falling
+// back to the corresponding headers of the incoming request. If a ?path query
+// param is given, the allowed methods are those of the matching endpoint.
+func (h *HTTPBin) CORSPreflightDebug(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	resp := &corsPreflightDebugResponse{
+		Origin:         q.Get("origin"),
+		RequestMethod:  strings.ToUpper(q.Get("request_method")),
+		RequestHeaders: q.Get("request_headers"),
+		Path:           q.Get("path"),
+	}
+	if resp.Origin == "" {
+		resp.Origin = r.Header.Get("Origin")
+	}
+	if resp.RequestMethod == "" {
+		resp.RequestMethod = strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	}
+	if resp.RequestHeaders == "" {
+		resp.RequestHeaders = r.Header.Get("Access-Control-Request-Headers")
+	}
+
+	allowedMethods := corsAllowedMethods
+	if resp.Path != "" {
+		u, err := url.Parse(resp.Path)
+		if err != nil || !strings.HasPrefix(u.Path, "/") {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid path: %q", resp.Path))
+			return
+		}
+		allowedMethods = h.allowedMethods(&http.Request{Host: r.Host, URL: u})
+	}
+
+	resp.Headers = corsPreflightHeaders(resp.Origin, resp.RequestHeaders, allowedMethods)
+	for _, method := range strings.Split(allowedMethods, ",") {
+		if strings.TrimSpace(method) == resp.RequestMethod {
+			resp.Allowed = true
+			break
+		}
+	}
+
+	writeJSON(http.StatusOK, w, resp)
+}
+
 // BasicAuth requires basic authentication
 func (h *HTTPBin) BasicAuth(w http.ResponseWriter, r *http.Request) {
 	expectedUser := r.PathValue("user")
 	expectedPass := r.PathValue("password")
 
+	realm := defaultBasicAuthRealm
+	if rawRealm := r.URL.Query().Get("realm"); rawRealm != "" {
+		if strings.ContainsFunc(rawRealm, unicode.IsControl) {
+			h.writeError(w, http.StatusBadRequest, errors.New("invalid realm: must not contain control characters"))
+			return
+		}
+		realm = rawRealm
+	}
+
 	givenUser, givenPass, _ := r.BasicAuth()
 
 	status := http.StatusOK
 	authorized := givenUser == expectedUser && givenPass == expectedPass
 	if !authorized {
 		status = http.StatusUnauthorized
-		w.Header().Set("WWW-Authenticate", `Basic realm="Fake Realm"`)
+		w.Header().Set("WWW-Authenticate", basicAuthChallenge(realm))
 	}
 
 	writeJSON(status, w, authResponse{
@@ -515,7 +1261,7 @@ func (h *HTTPBin) HiddenBasicAuth(w http.ResponseWriter, r *http.Request) {
 
 	authorized := givenUser == expectedUser && givenPass == expectedPass
 	if !authorized {
-		writeError(w, http.StatusNotFound, nil)
+		h.writeError(w, http.StatusNotFound, nil)
 		return
 	}
 
@@ -529,7 +1275,7 @@ func (h *HTTPBin) HiddenBasicAuth(w http.ResponseWriter, r *http.Request) {
 func (h *HTTPBin) Stream(w http.ResponseWriter, r *http.Request) {
 	n, err := strconv.Atoi(r.PathValue("numLines"))
 	if err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid count: %w", err))
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid count: %w", err))
 		return
 	}
 
@@ -539,6 +1285,26 @@ func (h *HTTPBin) Stream(w http.ResponseWriter, r *http.Request) {
 		n = 1
 	}
 
+	dropRate, err := parseDropRate(r.URL.Query())
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	rng, err := parseSeed(r.URL.Query().Get("seed"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid seed: %w", err))
+		return
+	}
+
+	// By default, we stream newline-delimited JSON objects, but a single JSON
+	// array may be streamed instead
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "ndjson" && format != "array" {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid format: %q must be one of ndjson, array", format))
+		return
+	}
+	asArray := format == "array"
+
 	resp := &streamResponse{
 		Args:    r.URL.Query(),
 		Headers: getRequestHeaders(r, h.excludeHeadersProcessor),
@@ -547,12 +1313,28 @@ func (h *HTTPBin) Stream(w http.ResponseWriter, r *http.Request) {
 	}
 
 	f := w.(http.Flusher)
+	if asArray {
+		w.Header().Set("Content-Type", jsonContentType)
+		w.Write([]byte("["))
+		f.Flush()
+	}
+	written := 0
 	for i := 0; i < n; i++ {
+		if dropRate > 0 && rng.Float64() < dropRate {
+			continue
+		}
 		resp.ID = i
 		// Call json.Marshal directly to avoid pretty printing
 		line, _ := json.Marshal(resp)
+		if asArray && written > 0 {
+			line = append([]byte(","), line...)
+		}
 		w.Write(append(line, '\n'))
 		f.Flush()
+		written++
+	}
+	if asArray {
+		w.Write([]byte("]\n"))
 	}
 }
 
@@ -583,7 +1365,7 @@ func (h *HTTPBin) Trailers(w http.ResponseWriter, r *http.Request) {
 	// ensure all requested trailers are allowed
 	for k := range q {
 		if _, found := forbiddenTrailers[http.CanonicalHeaderKey(k)]; found {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("forbidden trailer: %s", k))
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("forbidden trailer: %s", k))
 			return
 		}
 	}
@@ -599,25 +1381,101 @@ func (h *HTTPBin) Trailers(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Delay waits for a given amount of time before responding, where the time may
-// be specified as a golang-style duration or seconds in floating point.
-func (h *HTTPBin) Delay(w http.ResponseWriter, r *http.Request) {
-	delay, err := parseBoundedDuration(r.PathValue("duration"), 0, h.MaxDuration)
+// Delay waits for a given amount of time before responding, where the time may
+// be specified as a golang-style duration or seconds in floating point.
+func (h *HTTPBin) Delay(w http.ResponseWriter, r *http.Request) {
+	delay, err := parseBoundedDuration(r.PathValue("duration"), 0, h.maxDuration(r))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration: %w", err))
+		return
+	}
+
+	q := r.URL.Query()
+	heartbeat, err := parseBoolParam(q, "heartbeat")
 	if err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration: %w", err))
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	heartbeatInterval := defaultHeartbeatInterval
+	if userInterval := q.Get("heartbeat_interval"); userInterval != "" {
+		heartbeatInterval, err = parseBoundedDuration(userInterval, time.Millisecond, h.maxDuration(r))
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid heartbeat_interval: %w", err))
+			return
+		}
+	}
+	if heartbeat {
+		h.delayWithHeartbeat(w, r, delay, heartbeatInterval)
 		return
 	}
 
+	start := time.Now()
 	select {
 	case <-r.Context().Done():
-		w.WriteHeader(499) // "Client Closed Request" https://httpstatuses.com/499
+		h.writeContextDone(w, r)
 		return
 	case <-time.After(delay):
 	}
+	actualDelay := time.Since(start)
+
 	w.Header().Set("Server-Timing", encodeServerTimings([]serverTiming{
 		{"initial_delay", delay, "initial delay"},
 	}))
-	h.RequestWithBody(w, r)
+	resp, err := h.newBodyResponse(r)
+	if err != nil {
+		h.writeError(w, bodyErrorStatus(err), err)
+		return
+	}
+	resp.ActualDelayMS = float64(actualDelay) / float64(time.Millisecond)
+	writeJSON(http.StatusOK, w, resp)
+}
+
+// defaultHeartbeatInterval is how often /delay?heartbeat=true writes to the
+// connection while waiting
+const defaultHeartbeatInterval = time.Second
+
+// delayWithHeartbeat is like Delay, except that the response is started
+// immediately and a newline, which is insignificant whitespace before the
+// final JSON body, is written every interval during the delay to keep the
+// connection active for clients behind proxies with idle timeouts.
+func (h *HTTPBin) delayWithHeartbeat(w http.ResponseWriter, r *http.Request, delay, interval time.Duration) {
+	// the request body must be read before the response is started
+	resp, err := h.newBodyResponse(r)
+	if err != nil {
+		h.writeError(w, bodyErrorStatus(err), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("Server-Timing", encodeServerTimings([]serverTiming{
+		{"initial_delay", delay, "initial delay"},
+	}))
+	w.WriteHeader(http.StatusOK)
+	flusher := w.(http.Flusher)
+	flusher.Flush()
+
+	start := time.Now()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for waiting := true; waiting; {
+		select {
+		case <-r.Context().Done():
+			// the response has already been started, so the cancelation
+			// cannot be reported with a status code
+			return
+		case <-ticker.C:
+			w.Write([]byte("\n"))
+			flusher.Flush()
+		case <-timer.C:
+			waiting = false
+		}
+	}
+
+	resp.ActualDelayMS = float64(time.Since(start)) / float64(time.Millisecond)
+	mustMarshalJSON(w, resp)
 }
 
 // Drip simulates a slow HTTP server by writing data over a given duration
@@ -638,21 +1496,22 @@ func (h *HTTPBin) Drip(w http.ResponseWriter, r *http.Request) {
 		numBytes = h.DefaultParams.DripNumBytes
 		code     = http.StatusOK
 
-		err error
+		maxDuration = h.maxDuration(r)
+		err         error
 	)
 
 	if userDuration := q.Get("duration"); userDuration != "" {
-		duration, err = parseBoundedDuration(userDuration, 0, h.MaxDuration)
+		duration, err = parseBoundedDuration(userDuration, 0, maxDuration)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration: %w", err))
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration: %w", err))
 			return
 		}
 	}
 
 	if userDelay := q.Get("delay"); userDelay != "" {
-		delay, err = parseBoundedDuration(userDelay, 0, h.MaxDuration)
+		delay, err = parseBoundedDuration(userDelay, 0, maxDuration)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid delay: %w", err))
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid delay: %w", err))
 			return
 		}
 	}
@@ -660,10 +1519,10 @@ func (h *HTTPBin) Drip(w http.ResponseWriter, r *http.Request) {
 	if userNumBytes := q.Get("numbytes"); userNumBytes != "" {
 		numBytes, err = strconv.ParseInt(userNumBytes, 10, 64)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid numbytes: %w", err))
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid numbytes: %w", err))
 			return
-		} else if numBytes < 1 || numBytes > h.MaxBodySize {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid numbytes: %d not in range [1, %d]", numBytes, h.MaxBodySize))
+		} else if numBytes < 1 || numBytes > h.maxBodySize() {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid numbytes: %d not in range [1, %d]", numBytes, h.maxBodySize()))
 			return
 		}
 	}
@@ -671,13 +1530,21 @@ func (h *HTTPBin) Drip(w http.ResponseWriter, r *http.Request) {
 	if userCode := q.Get("code"); userCode != "" {
 		code, err = parseStatusCode(userCode)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, err)
+			h.writeError(w, http.StatusBadRequest, err)
 			return
 		}
 	}
 
-	if duration+delay > h.MaxDuration {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("too much time: %v+%v > %v", duration, delay, h.MaxDuration))
+	// Explicitly asking for body bytes along with a status code that cannot
+	// carry a body is contradictory. The default numbytes is exempt, so that
+	// e.g. /drip?code=100 continues to send an informational response.
+	if q.Get("numbytes") != "" && !bodyAllowedForStatus(code) {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid numbytes: a %d response cannot have a body", code))
+		return
+	}
+
+	if duration+delay > maxDuration {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("too much time: %v+%v > %v", duration, delay, maxDuration))
 		return
 	}
 
@@ -694,7 +1561,7 @@ func (h *HTTPBin) Drip(w http.ResponseWriter, r *http.Request) {
 		case <-time.After(delay):
 			// ok
 		case <-r.Context().Done():
-			w.WriteHeader(499) // "Client Closed Request" https://httpstatuses.com/499
+			h.writeContextDone(w, r)
 			return
 		}
 	}
@@ -748,15 +1615,25 @@ func (h *HTTPBin) Drip(w http.ResponseWriter, r *http.Request) {
 func (h *HTTPBin) Range(w http.ResponseWriter, r *http.Request) {
 	numBytes, err := strconv.ParseInt(r.PathValue("numBytes"), 10, 64)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid count: %w", err))
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid count: %w", err))
 		return
 	}
 
 	w.Header().Add("ETag", fmt.Sprintf("range%d", numBytes))
 	w.Header().Add("Accept-Ranges", "bytes")
 
-	if numBytes <= 0 || numBytes > h.MaxBodySize {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid count: %d not in range [1, %d]", numBytes, h.MaxBodySize))
+	if numBytes <= 0 || numBytes > h.maxBodySize() {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid count: %d not in range [1, %d]", numBytes, h.maxBodySize()))
+		return
+	}
+
+	echoRange, err := parseBoolParam(r.URL.Query(), "echo_range")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if echoRange {
+		writeJSON(http.StatusOK, w, newRangeResponse(r.Header.Get("Range"), numBytes))
 		return
 	}
 
@@ -780,9 +1657,18 @@ Disallow: /deny
 	writeResponse(w, http.StatusOK, textContentType, robotsTxt)
 }
 
-// Deny renders a basic page that robots should never access
-func (h *HTTPBin) Deny(w http.ResponseWriter, _ *http.Request) {
-	writeResponse(w, http.StatusOK, textContentType, []byte(`YOU SHOULDN'T BE HERE`))
+// Deny renders a basic page that robots should never access, served with the
+// configured content type unless overridden by the content_type query param.
+func (h *HTTPBin) Deny(w http.ResponseWriter, r *http.Request) {
+	contentType := h.denyContentType
+	if r.URL.Query().Has("content_type") {
+		contentType = r.URL.Query().Get("content_type")
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid content_type: %w", err))
+			return
+		}
+	}
+	writeResponse(w, http.StatusOK, contentType, []byte(`YOU SHOULDN'T BE HERE`))
 }
 
 // Cache returns a 304 if an If-Modified-Since or an If-None-Match header is
@@ -802,18 +1688,32 @@ func (h *HTTPBin) Cache(w http.ResponseWriter, r *http.Request) {
 func (h *HTTPBin) CacheControl(w http.ResponseWriter, r *http.Request) {
 	seconds, err := strconv.ParseInt(r.PathValue("numSeconds"), 10, 64)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid seconds: %w", err))
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid seconds: %w", err))
 		return
 	}
 	w.Header().Add("Cache-Control", fmt.Sprintf("public, max-age=%d", seconds))
 	h.Get(w, r)
 }
 
+// CacheNoStore returns the same response as Get, along with the canonical set
+// of headers instructing clients and intermediaries to never cache it.
+func (h *HTTPBin) CacheNoStore(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	h.Get(w, r)
+}
+
 // ETag assumes the resource has the given etag and responds to If-None-Match
 // and If-Match headers appropriately.
 func (h *HTTPBin) ETag(w http.ResponseWriter, r *http.Request) {
 	etag := r.PathValue("etag")
+
+	// Set the validator and caching headers up front, so that they're
+	// included on 304 Not Modified responses as well, per RFC 9110 section
+	// 15.4.5.
 	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, etag))
+	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Content-Type", textContentType)
 
 	var buf bytes.Buffer
@@ -830,79 +1730,121 @@ func (h *HTTPBin) ETag(w http.ResponseWriter, r *http.Request) {
 	http.ServeContent(w, r, "response.json", time.Now(), bytes.NewReader(buf.Bytes()))
 }
 
+// Burst records the arrival of a request for the given ?key and responds with
+// statistics about the gaps between recent requests sharing that key.
+func (h *HTTPBin) Burst(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		h.writeError(w, http.StatusBadRequest, errors.New("missing required key param"))
+		return
+	}
+	timestamps := h.burstTracker.Record(key, time.Now())
+	writeJSON(http.StatusOK, w, newBurstResponse(key, timestamps))
+}
+
+// LoadInfo reports the server's current load: in-flight and total requests
+// served, along with Go runtime stats.
+func (h *HTTPBin) LoadInfo(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(http.StatusOK, w, &loadInfoResponse{
+		InFlightRequests: h.loadTracker.inFlight.Load(),
+		TotalRequests:    h.loadTracker.total.Load(),
+		Goroutines:       runtime.NumGoroutine(),
+		HeapAllocBytes:   h.loadTracker.HeapAlloc(time.Now()),
+	})
+}
+
 // Bytes returns N random bytes generated with an optional seed
 func (h *HTTPBin) Bytes(w http.ResponseWriter, r *http.Request) {
-	handleBytes(w, r, false)
+	h.handleBytes(w, r, false)
 }
 
 // StreamBytes streams N random bytes generated with an optional seed in chunks
 // of a given size.
 func (h *HTTPBin) StreamBytes(w http.ResponseWriter, r *http.Request) {
-	handleBytes(w, r, true)
+	h.handleBytes(w, r, true)
 }
 
 // handleBytes consolidates the logic for validating input params of the Bytes
 // and StreamBytes endpoints and knows how to write the response in chunks if
 // streaming is true.
-func handleBytes(w http.ResponseWriter, r *http.Request, streaming bool) {
+func (h *HTTPBin) handleBytes(w http.ResponseWriter, r *http.Request, streaming bool) {
 	numBytes, err := strconv.Atoi(r.PathValue("numBytes"))
 	if err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid byte count: %w", err))
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid byte count: %w", err))
 		return
 	}
 
 	// rng/seed
 	rng, err := parseSeed(r.URL.Query().Get("seed"))
 	if err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid seed: %w", err))
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid seed: %w", err))
 		return
 	}
 
-	if numBytes < 0 {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid byte count: %d must be greater than 0", numBytes))
+	if numBytes < 0 || int64(numBytes) > h.maxBodySize() {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid byte count: %d not in range [0, %d]", numBytes, h.maxBodySize()))
 		return
 	}
 
+	abortAfter := -1
+	if userAbortAfter := r.URL.Query().Get("abort_after"); userAbortAfter != "" && !streaming {
+		abortAfter, err = strconv.Atoi(userAbortAfter)
+		if err != nil || abortAfter < 0 || abortAfter > numBytes {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid abort_after: must be an integer in range [0, %d]", numBytes))
+			return
+		}
+	}
+
 	// Special case 0 bytes and exit early, since streaming & chunk size do not
 	// matter here.
-	if numBytes == 0 {
+	if numBytes == 0 && abortAfter < 0 {
 		w.Header().Set("Content-Length", "0")
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	if numBytes > 100*1024 {
-		numBytes = 100 * 1024
+	// if not streaming, we generate the whole response up front, so that
+	// Range requests can be served from it. Given the same seed, byte i is
+	// the same regardless of which range is requested.
+	if !streaming {
+		content := make([]byte, numBytes)
+		for i := range content {
+			content[i] = byte(rng.Intn(256))
+		}
+		w.Header().Set("Content-Type", binaryContentType)
+		if abortAfter >= 0 {
+			writeAndAbort(w, content, abortAfter)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+		return
 	}
 
-	var chunkSize int
-	var write func([]byte)
-
-	if streaming {
-		if r.URL.Query().Get("chunk_size") != "" {
-			chunkSize, err = strconv.Atoi(r.URL.Query().Get("chunk_size"))
-			if err != nil {
-				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid chunk_size: %w", err))
-				return
-			}
-		} else {
-			chunkSize = 10 * 1024
+	chunkSize := 10 * 1024
+	if r.URL.Query().Get("chunk_size") != "" {
+		chunkSize, err = strconv.Atoi(r.URL.Query().Get("chunk_size"))
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid chunk_size: %w", err))
+			return
 		}
+	}
 
-		write = func() func(chunk []byte) {
-			f := w.(http.Flusher)
-			return func(chunk []byte) {
-				w.Write(chunk)
-				f.Flush()
-			}
-		}()
-	} else {
-		// if not streaming, we will write the whole response at once
-		chunkSize = numBytes
-		w.Header().Set("Content-Length", strconv.Itoa(numBytes))
-		write = func(chunk []byte) {
-			w.Write(chunk)
+	dropRate, err := parseDropRate(r.URL.Query())
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// use a separate rng to decide which chunks to drop, so that the
+	// chunks that are written match those of an unlossy stream
+	dropRNG, _ := parseSeed(r.URL.Query().Get("seed"))
+
+	f := w.(http.Flusher)
+	write := func(chunk []byte) {
+		if dropRate > 0 && dropRNG.Float64() < dropRate {
+			return
 		}
+		w.Write(chunk)
+		f.Flush()
 	}
 
 	w.Header().Set("Content-Type", binaryContentType)
@@ -921,14 +1863,33 @@ func handleBytes(w http.ResponseWriter, r *http.Request, streaming bool) {
 	}
 }
 
+// writeAndAbort writes a response whose Content-Length promises all of the
+// given content, but only writes its first n bytes before closing the
+// underlying connection, so that the client sees a truncated response.
+func writeAndAbort(w http.ResponseWriter, content []byte, n int) {
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(content[:n])
+
+	rc := http.NewResponseController(w)
+	rc.Flush()
+	conn, _, err := rc.Hijack()
+	if err != nil {
+		// the connection cannot be hijacked (e.g. HTTP/2), so fall back on
+		// asking the server to abort the response
+		panic(http.ErrAbortHandler)
+	}
+	conn.Close()
+}
+
 // Links redirects to the first page in a series of N links
 func (h *HTTPBin) Links(w http.ResponseWriter, r *http.Request) {
 	n, err := strconv.Atoi(r.PathValue("numLinks"))
 	if err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid link count: %w", err))
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid link count: %w", err))
 		return
 	} else if n < 0 || n > 256 {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid link count: %d must be in range [0, 256]", n))
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid link count: %d must be in range [0, 256]", n))
 		return
 	}
 
@@ -936,7 +1897,7 @@ func (h *HTTPBin) Links(w http.ResponseWriter, r *http.Request) {
 	if rawOffset := r.PathValue("offset"); rawOffset != "" {
 		offset, err := strconv.Atoi(rawOffset)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid offset: %w", err))
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid offset: %w", err))
 			return
 		}
 		h.doLinksPage(w, r, n, offset)
@@ -984,38 +1945,70 @@ func (h *HTTPBin) ImageAccept(w http.ResponseWriter, r *http.Request) {
 	case strings.Contains(accept, "image/*"):
 		fallthrough // default to png
 	case strings.Contains(accept, "image/png"):
-		doImage(w, "png")
+		h.doImage(w, "png", 0)
 	case strings.Contains(accept, "image/webp"):
-		doImage(w, "webp")
+		h.doImage(w, "webp", 0)
 	case strings.Contains(accept, "image/svg+xml"):
-		doImage(w, "svg")
+		h.doImage(w, "svg", 0)
 	case strings.Contains(accept, "image/jpeg"):
-		doImage(w, "jpeg")
+		h.doImage(w, "jpeg", 0)
 	default:
-		writeError(w, http.StatusUnsupportedMediaType, nil)
+		h.writeError(w, http.StatusUnsupportedMediaType, nil)
 	}
 }
 
-// Image responds with an image of a specific kind, from /image/<kind>
+// Image responds with an image of a specific kind, from /image/<kind>,
+// optionally padded to approximately the size given by the size query param.
 func (h *HTTPBin) Image(w http.ResponseWriter, r *http.Request) {
-	doImage(w, r.PathValue("kind"))
+	var size int64
+	if userSize := r.URL.Query().Get("size"); userSize != "" {
+		var err error
+		size, err = strconv.ParseInt(userSize, 10, 64)
+		if err != nil || size < 1 || size > h.maxBodySize() {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid size: must be an integer in range [1, %d]", h.maxBodySize()))
+			return
+		}
+	}
+	h.doImage(w, r.PathValue("kind"), int(size))
 }
 
 // doImage responds with a specific kind of image, if there is an image asset
-// of the given kind.
-func doImage(w http.ResponseWriter, kind string) {
+// of the given kind. If size is non-zero, the image is padded to the nearest
+// achievable size, which is reported in the X-Image-Size header.
+func (h *HTTPBin) doImage(w http.ResponseWriter, kind string, size int) {
 	img, err := staticAsset("image." + kind)
 	if err != nil {
-		writeError(w, http.StatusNotFound, nil)
+		h.writeError(w, http.StatusNotFound, nil)
 		return
 	}
 	contentType := "image/" + kind
 	if kind == "svg" {
 		contentType = "image/svg+xml"
 	}
+	if size > 0 {
+		img = padImage(kind, img, size)
+		w.Header().Set("X-Image-Size", strconv.Itoa(len(img)))
+	}
 	writeResponse(w, http.StatusOK, contentType, img)
 }
 
+// staticFiles serves files from the given filesystem, rejecting any request
+// path that is not a valid fs.FS path (e.g. a path traversal attempt).
+func (h *HTTPBin) staticFiles(fsys fs.FS) http.Handler {
+	fileServer := http.FileServerFS(fsys)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.Trim(r.URL.Path, "/")
+		if name == "" {
+			name = "."
+		}
+		if !fs.ValidPath(name) {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid path: %q", r.URL.Path))
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
 // XML responds with an XML document
 func (h *HTTPBin) XML(w http.ResponseWriter, _ *http.Request) {
 	writeResponse(w, http.StatusOK, "application/xml", mustStaticAsset("sample.xml"))
@@ -1038,11 +2031,11 @@ func (h *HTTPBin) DigestAuth(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if qop != "auth" {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid QOP directive: %q != \"auth\"", qop))
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid QOP directive: %q != \"auth\"", qop))
 		return
 	}
 	if algoName != "MD5" && algoName != "SHA-256" {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid algorithm: %s must be one of MD5 or SHA-256", algoName))
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid algorithm: %s must be one of MD5 or SHA-256", algoName))
 		return
 	}
 
@@ -1053,7 +2046,7 @@ func (h *HTTPBin) DigestAuth(w http.ResponseWriter, r *http.Request) {
 
 	if !digest.Check(r, user, password) {
 		w.Header().Set("WWW-Authenticate", digest.Challenge("go-httpbin", algorithm))
-		writeError(w, http.StatusUnauthorized, nil)
+		h.writeError(w, http.StatusUnauthorized, nil)
 		return
 	}
 
@@ -1063,6 +2056,12 @@ func (h *HTTPBin) DigestAuth(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// Panic deliberately panics, to allow verifying that panics are recovered.
+// Only available if debug endpoints are enabled.
+func (h *HTTPBin) Panic(_ http.ResponseWriter, _ *http.Request) {
+	panic("deliberate panic from /panic endpoint")
+}
+
 // UUID - responds with a generated UUID
 func (h *HTTPBin) UUID(w http.ResponseWriter, _ *http.Request) {
 	writeJSON(http.StatusOK, w, uuidResponse{
@@ -1072,14 +2071,190 @@ func (h *HTTPBin) UUID(w http.ResponseWriter, _ *http.Request) {
 
 // Base64 - encodes/decodes input data
 func (h *HTTPBin) Base64(w http.ResponseWriter, r *http.Request) {
-	result, err := newBase64Helper(r, h.MaxBodySize).transform()
+	result, err := newBase64Helper(r, h.maxBodySize()).transform()
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err)
+		h.writeError(w, http.StatusBadRequest, err)
 		return
 	}
 	writeResponse(w, http.StatusOK, textContentType, result)
 }
 
+// Base64Body encodes or decodes the request body, which may be considerably
+// larger than the input accepted in the URL path by the Base64 handler.
+func (h *HTTPBin) Base64Body(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeError(w, bodyErrorStatus(err), fmt.Errorf("error reading request body: %w", err))
+		return
+	}
+	b := &base64Helper{
+		operation: path.Base(r.URL.Path),
+		data:      string(body),
+		maxLen:    h.maxBodySize(),
+	}
+	result, err := b.transform()
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	contentType := textContentType
+	if b.operation == "decode" {
+		contentType = binaryContentType
+	}
+	writeResponse(w, http.StatusOK, contentType, result)
+}
+
+// Reverse responds with the request body's bytes in reverse order, using the
+// request's content type.
+func (h *HTTPBin) Reverse(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeError(w, bodyErrorStatus(err), fmt.Errorf("error reading request body: %w", err))
+		return
+	}
+	slices.Reverse(body)
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = binaryContentType
+	}
+	writeResponse(w, http.StatusOK, contentType, body)
+}
+
+// webhookSecret is the fixed, publicly documented secret used to sign request
+// bodies for the /webhook/verify endpoint.
+const webhookSecret = "go-httpbin"
+
+// WebhookVerify checks the request's X-Signature header against an
+// HMAC-SHA256 signature of the body, as in common webhook verification flows,
+// reporting the expected signature to help debug signing code.
+func (h *HTTPBin) WebhookVerify(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeError(w, bodyErrorStatus(err), fmt.Errorf("error reading request body: %w", err))
+		return
+	}
+
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	signature := r.Header.Get("X-Signature")
+
+	writeJSON(http.StatusOK, w, webhookVerifyResponse{
+		Valid:             hmac.Equal([]byte(signature), []byte(expected)),
+		Signature:         signature,
+		ExpectedSignature: expected,
+	})
+}
+
+// AdminReload atomically applies new values for the runtime-adjustable
+// limits given in a JSON request body, and responds with the limits now in
+// effect. Only available if an admin token is configured.
+func (h *HTTPBin) AdminReload(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		h.writeError(w, http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req adminReloadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, bodyErrorStatus(err), fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	// validate every requested change before applying any of them
+	if req.MaxBodySize != nil && *req.MaxBodySize < 1 {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max_body_size: %d must be positive", *req.MaxBodySize))
+		return
+	}
+	var maxDuration time.Duration
+	if req.MaxDuration != nil {
+		d, err := time.ParseDuration(*req.MaxDuration)
+		if err != nil || d <= 0 {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max_duration: %q must be a positive duration", *req.MaxDuration))
+			return
+		}
+		maxDuration = d
+	}
+	var allowedRedirectDomains map[string]struct{}
+	if req.AllowedRedirectDomains != nil {
+		allowedRedirectDomains = make(map[string]struct{}, len(*req.AllowedRedirectDomains))
+		for _, host := range *req.AllowedRedirectDomains {
+			allowedRedirectDomains[host] = struct{}{}
+		}
+	}
+
+	// apply the requested changes on top of the latest limits, rebuilding
+	// them whenever a concurrent reload swaps in new limits first, so that
+	// the limits it changed and this request omits are preserved
+	var next *runtimeLimits
+	for {
+		current := h.limits.Load()
+		nextMaxBodySize, nextMaxDuration, nextAllowedRedirectDomains := current.maxBodySize, current.maxDuration, current.allowedRedirectDomains
+		if req.MaxBodySize != nil {
+			nextMaxBodySize = *req.MaxBodySize
+		}
+		if req.MaxDuration != nil {
+			nextMaxDuration = maxDuration
+		}
+		if req.AllowedRedirectDomains != nil {
+			nextAllowedRedirectDomains = allowedRedirectDomains
+		}
+		next = h.newRuntimeLimits(nextMaxBodySize, nextMaxDuration, nextAllowedRedirectDomains)
+		if h.limits.CompareAndSwap(current, next) {
+			break
+		}
+	}
+
+	domains := make([]string, 0, len(next.allowedRedirectDomains))
+	for host := range next.allowedRedirectDomains {
+		domains = append(domains, host)
+	}
+	slices.Sort(domains)
+	writeJSON(http.StatusOK, w, adminReloadResponse{
+		MaxBodySize:            next.maxBodySize,
+		MaxDuration:            next.maxDuration.String(),
+		AllowedRedirectDomains: domains,
+	})
+}
+
+// Diff stores the first request made with a given key, and responds to the
+// second by reporting the differences between the two, which helps clients
+// verify that a retried request matches the original.
+func (h *HTTPBin) Diff(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		h.writeError(w, http.StatusBadRequest, errors.New("missing required key parameter"))
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeError(w, bodyErrorStatus(err), fmt.Errorf("error reading request body: %w", err))
+		return
+	}
+	sig := &requestSignature{
+		method:  r.Method,
+		url:     getURL(r).String(),
+		headers: getRequestHeaders(r, h.excludeHeadersProcessor),
+		body:    string(body),
+	}
+	if !utf8.Valid(body) {
+		contentType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+		sig.body = encodeData(body, contentType)
+	}
+
+	first, ok := h.diffStore.Swap(key, sig, time.Now())
+	if !ok {
+		writeJSON(http.StatusOK, w, &diffStoredResponse{Key: key, Stored: true})
+		return
+	}
+	resp := first.diff(sig)
+	resp.Key = key
+	writeJSON(http.StatusOK, w, resp)
+}
+
 // DumpRequest - returns the given request in its HTTP/1.x wire representation.
 // The returned representation is an approximation only;
 // some details of the initial request are lost while parsing it into
@@ -1088,7 +2263,7 @@ func (h *HTTPBin) Base64(w http.ResponseWriter, r *http.Request) {
 func (h *HTTPBin) DumpRequest(w http.ResponseWriter, r *http.Request) {
 	dump, err := httputil.DumpRequest(r, true)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to dump request: %w", err))
+		h.writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to dump request: %w", err))
 		return
 	}
 	w.Write(dump)
@@ -1107,7 +2282,7 @@ func (h *HTTPBin) Bearer(w http.ResponseWriter, r *http.Request) {
 	tokenFields := strings.Fields(reqToken)
 	if len(tokenFields) != 2 || tokenFields[0] != "Bearer" {
 		w.Header().Set("WWW-Authenticate", "Bearer")
-		writeError(w, http.StatusUnauthorized, nil)
+		h.writeError(w, http.StatusUnauthorized, nil)
 		return
 	}
 	writeJSON(http.StatusOK, w, bearerResponse{
@@ -1132,38 +2307,67 @@ func (h *HTTPBin) SSE(w http.ResponseWriter, r *http.Request) {
 		count    = h.DefaultParams.SSECount
 		duration = h.DefaultParams.SSEDuration
 		delay    = h.DefaultParams.SSEDelay
-		err      error
+		retryMs  = -1
+
+		maxDuration = h.maxDuration(r)
+		err         error
 	)
 
 	if userCount := q.Get("count"); userCount != "" {
 		count, err = strconv.Atoi(userCount)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid count: %w", err))
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid count: %w", err))
 			return
 		}
-		if count < 1 || int64(count) > h.maxSSECount {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid count: must in range [1, %d]", h.maxSSECount))
+		maxSSECount := h.limits.Load().maxSSECount
+		if count < 1 || int64(count) > maxSSECount {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid count: must in range [1, %d]", maxSSECount))
 			return
 		}
 	}
 
 	if userDuration := q.Get("duration"); userDuration != "" {
-		duration, err = parseBoundedDuration(userDuration, 1, h.MaxDuration)
+		duration, err = parseBoundedDuration(userDuration, 1, maxDuration)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration: %w", err))
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration: %w", err))
 			return
 		}
 	}
 
 	if userDelay := q.Get("delay"); userDelay != "" {
-		delay, err = parseBoundedDuration(userDelay, 0, h.MaxDuration)
+		delay, err = parseBoundedDuration(userDelay, 0, maxDuration)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid delay: %w", err))
+			return
+		}
+	}
+
+	writeEvent := func(w io.Writer, id int) {
+		writeServerSentEvent(w, id, time.Now())
+	}
+	switch mode := q.Get("mode"); mode {
+	case "", "ping":
+		// default
+	case "jsonpatch":
+		writeEvent = writeJSONPatchEvent
+	default:
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid mode: %q must be one of ping, jsonpatch", mode))
+		return
+	}
+
+	if userRetry := q.Get("retry_ms"); userRetry != "" {
+		retryMs, err = strconv.Atoi(userRetry)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid delay: %w", err))
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid retry_ms: %w", err))
+			return
+		}
+		if retryMs < 0 {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid retry_ms: %d must be non-negative", retryMs))
 			return
 		}
 	}
 
-	if duration+delay > h.MaxDuration {
+	if duration+delay > maxDuration {
 		http.Error(w, "Too much time", http.StatusBadRequest)
 		return
 	}
@@ -1181,7 +2385,7 @@ func (h *HTTPBin) SSE(w http.ResponseWriter, r *http.Request) {
 		case <-time.After(delay):
 			// ok
 		case <-r.Context().Done():
-			w.WriteHeader(499) // "Client Closed Request" https://httpstatuses.com/499
+			h.writeContextDone(w, r)
 			return
 		}
 	}
@@ -1198,11 +2402,16 @@ func (h *HTTPBin) SSE(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", sseContentType)
 	w.WriteHeader(http.StatusOK)
 
+	// optional reconnection time directive, sent ahead of any events
+	if retryMs >= 0 {
+		fmt.Fprintf(w, "retry: %d\n\n", retryMs)
+	}
+
 	flusher := w.(http.Flusher)
 
 	// special case when we only have one event to write
 	if count == 1 {
-		writeServerSentEvent(w, 0, time.Now())
+		writeEvent(w, 0)
 		flusher.Flush()
 		return
 	}
@@ -1211,7 +2420,7 @@ func (h *HTTPBin) SSE(w http.ResponseWriter, r *http.Request) {
 	defer ticker.Stop()
 
 	for i := 0; i < count; i++ {
-		writeServerSentEvent(w, i, time.Now())
+		writeEvent(w, i)
 		flusher.Flush()
 
 		// don't pause after last byte
@@ -1242,12 +2451,35 @@ func writeServerSentEvent(dst io.Writer, id int, ts time.Time) {
 	dst.Write([]byte("\n"))
 }
 
+// writeJSONPatchEvent writes a server-sent event whose data is the id'th in a
+// deterministic sequence of RFC 6902 JSON Patches. Applied in order to an
+// empty JSON object, the first n patches produce the state
+// {"counter": n, "ids": [0, ..., n-1]}.
+func writeJSONPatchEvent(dst io.Writer, id int) {
+	patch := []jsonPatchOperation{
+		{Op: "replace", Path: "/counter", Value: id + 1},
+		{Op: "add", Path: "/ids/-", Value: id},
+	}
+	if id == 0 {
+		patch = []jsonPatchOperation{
+			{Op: "add", Path: "/counter", Value: 1},
+			{Op: "add", Path: "/ids", Value: []int{0}},
+		}
+	}
+	dst.Write([]byte("event: patch\n"))
+	dst.Write([]byte("data: "))
+	json.NewEncoder(dst).Encode(patch)
+	// each SSE ends with two newlines (\n\n), the first of which is written
+	// automatically by json.NewEncoder().Encode()
+	dst.Write([]byte("\n"))
+}
+
 // WebSocketEcho - simple websocket echo server, where the max fragment size
 // and max message size can be controlled by clients.
 func (h *HTTPBin) WebSocketEcho(w http.ResponseWriter, r *http.Request) {
 	var (
-		maxFragmentSize = h.MaxBodySize / 2
-		maxMessageSize  = h.MaxBodySize
+		maxFragmentSize = h.maxBodySize() / 2
+		maxMessageSize  = h.maxBodySize()
 		q               = r.URL.Query()
 		err             error
 	)
@@ -1255,10 +2487,10 @@ func (h *HTTPBin) WebSocketEcho(w http.ResponseWriter, r *http.Request) {
 	if userMaxFragmentSize := q.Get("max_fragment_size"); userMaxFragmentSize != "" {
 		maxFragmentSize, err = strconv.ParseInt(userMaxFragmentSize, 10, 32)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max_fragment_size: %w", err))
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max_fragment_size: %w", err))
 			return
-		} else if maxFragmentSize < 1 || maxFragmentSize > h.MaxBodySize {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max_fragment_size: %d not in range [1, %d]", maxFragmentSize, h.MaxBodySize))
+		} else if maxFragmentSize < 1 || maxFragmentSize > h.maxBodySize() {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max_fragment_size: %d not in range [1, %d]", maxFragmentSize, h.maxBodySize()))
 			return
 		}
 	}
@@ -1266,27 +2498,177 @@ func (h *HTTPBin) WebSocketEcho(w http.ResponseWriter, r *http.Request) {
 	if userMaxMessageSize := q.Get("max_message_size"); userMaxMessageSize != "" {
 		maxMessageSize, err = strconv.ParseInt(userMaxMessageSize, 10, 32)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max_message_size: %w", err))
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max_message_size: %w", err))
 			return
-		} else if maxMessageSize < 1 || maxMessageSize > h.MaxBodySize {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max_message_size: %d not in range [1, %d]", maxMessageSize, h.MaxBodySize))
+		} else if maxMessageSize < 1 || maxMessageSize > h.maxBodySize() {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max_message_size: %d not in range [1, %d]", maxMessageSize, h.maxBodySize()))
 			return
 		}
 	}
 
 	if maxFragmentSize > maxMessageSize {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("max_fragment_size %d must be less than or equal to max_message_size %d", maxFragmentSize, maxMessageSize))
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("max_fragment_size %d must be less than or equal to max_message_size %d", maxFragmentSize, maxMessageSize))
+		return
+	}
+
+	var maxTotalBytes int64
+	if userMaxTotalBytes := q.Get("max_total_bytes"); userMaxTotalBytes != "" {
+		maxTotalBytes, err = strconv.ParseInt(userMaxTotalBytes, 10, 32)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max_total_bytes: %w", err))
+			return
+		} else if maxTotalBytes < 1 {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max_total_bytes: %d must be greater than 0", maxTotalBytes))
+			return
+		}
+	}
+
+	verifyFragments, err := parseBoolParam(q, "verify_fragments")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	handler := websocket.EchoHandler
+	if verifyFragments {
+		handler = websocket.FragmentCountEchoHandler
+	}
+
+	switch only := q.Get("only"); only {
+	case "":
+	case "text", "binary":
+		handler = websocket.RequireMessageType(only == "binary", handler)
+	default:
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid only: %q must be one of text, binary", only))
+		return
+	}
+
+	var closeAfter int64
+	if userCloseAfter := q.Get("close_after"); userCloseAfter != "" {
+		closeAfter, err = strconv.ParseInt(userCloseAfter, 10, 32)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid close_after: %w", err))
+			return
+		} else if closeAfter < 1 {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid close_after: %d must be greater than 0", closeAfter))
+			return
+		}
+		handler = websocket.CloseAfter(int(closeAfter), handler)
+	}
+
+	var handshakeDelay time.Duration
+	if userHandshakeDelay := q.Get("handshake_delay"); userHandshakeDelay != "" {
+		handshakeDelay, err = parseBoundedDuration(userHandshakeDelay, 0, h.maxDuration(r))
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid handshake_delay: %w", err))
+			return
+		}
+	}
+
+	if !h.acquireWebSocket() {
+		h.writeError(w, http.StatusServiceUnavailable, errTooManyWebSockets)
 		return
 	}
+	defer h.releaseWebSocket()
 
 	ws := websocket.New(w, r, websocket.Limits{
-		MaxDuration:     h.MaxDuration,
+		MaxDuration:     h.maxDuration(r),
 		MaxFragmentSize: int(maxFragmentSize),
 		MaxMessageSize:  int(maxMessageSize),
+		MaxTotalBytes:   int(maxTotalBytes),
+	})
+	if handshakeDelay > 0 {
+		select {
+		case <-r.Context().Done():
+			h.writeContextDone(w, r)
+			return
+		case <-time.After(handshakeDelay):
+		}
+	}
+	if err := ws.Handshake(); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ws.Serve(handler)
+}
+
+// WebSocketFuzz is a websocket echo endpoint intended as a target for protocol
+// conformance test suites like Autobahn, which expect to be able to send
+// unfragmented messages up to the maximum message size and which cannot pass
+// any query parameters.
+func (h *HTTPBin) WebSocketFuzz(w http.ResponseWriter, r *http.Request) {
+	if !h.acquireWebSocket() {
+		h.writeError(w, http.StatusServiceUnavailable, errTooManyWebSockets)
+		return
+	}
+	defer h.releaseWebSocket()
+
+	ws := websocket.New(w, r, websocket.Limits{
+		MaxDuration:     h.maxDuration(r),
+		MaxFragmentSize: int(h.maxBodySize()),
+		MaxMessageSize:  int(h.maxBodySize()),
 	})
 	if err := ws.Handshake(); err != nil {
-		writeError(w, http.StatusBadRequest, err)
+		h.writeError(w, http.StatusBadRequest, err)
 		return
 	}
 	ws.Serve(websocket.EchoHandler)
 }
+
+// WebSocketRelay is an experimental endpoint that joins websocket connections
+// into the named room and relays each message received on one connection to
+// every other connection in the room.
+func (h *HTTPBin) WebSocketRelay(w http.ResponseWriter, r *http.Request) {
+	room := r.PathValue("room")
+
+	if !h.acquireWebSocket() {
+		h.writeError(w, http.StatusServiceUnavailable, errTooManyWebSockets)
+		return
+	}
+	defer h.releaseWebSocket()
+
+	ws := websocket.New(w, r, websocket.Limits{
+		MaxDuration:     h.maxDuration(r),
+		MaxFragmentSize: int(h.maxBodySize() / 2),
+		MaxMessageSize:  int(h.maxBodySize()),
+	})
+
+	// join before the handshake, so that a full room can be rejected with a
+	// regular HTTP error response
+	peer, err := h.relayRooms.Join(room, ws)
+	if err != nil {
+		h.writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer h.relayRooms.Leave(room, ws)
+
+	if err := ws.Handshake(); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	peer.ready.Store(true)
+	go peer.deliver()
+	ws.Serve(func(ctx context.Context, msg *websocket.Message) (*websocket.Message, error) {
+		h.relayRooms.Broadcast(room, ws, msg)
+		return nil, nil
+	})
+}
+
+// errTooManyWebSockets is returned when the limit on concurrent websocket
+// connections has been reached
+var errTooManyWebSockets = errors.New("too many websocket connections")
+
+// acquireWebSocket reserves a websocket connection, returning false if the
+// limit on concurrent connections has been reached. Each successful call must
+// be paired with a call to releaseWebSocket.
+func (h *HTTPBin) acquireWebSocket() bool {
+	n := h.webSocketConns.Add(1)
+	if h.maxWebSocketConns > 0 && n > h.maxWebSocketConns {
+		h.webSocketConns.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (h *HTTPBin) releaseWebSocket() {
+	h.webSocketConns.Add(-1)
+}
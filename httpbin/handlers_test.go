@@ -6,26 +6,49 @@ import (
 	"compress/gzip"
 	"compress/zlib"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"image/jpeg"
+	"image/png"
 	"io"
+	"io/fs"
 	"log/slog"
+	"math/big"
 	"mime/multipart"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
+	"net/textproto"
 	"net/url"
 	"os"
 	"reflect"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
 	"github.com/mccutchen/go-httpbin/v2/internal/testing/assert"
 	"github.com/mccutchen/go-httpbin/v2/internal/testing/must"
 )
@@ -110,6 +133,16 @@ func TestIndex(t *testing.T) {
 			assert.Contains(t, body, env.prefix+"/get", "body")
 		})
 
+		t.Run("routes"+env.prefix, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", env.prefix+"/", env)
+			resp := must.DoReq(t, env.client, req)
+			body := must.ReadAll(t, resp.Body)
+			assert.Contains(t, body, fmt.Sprintf(`<a href="%s/loadinfo"><code>%s/loadinfo</code></a>`, env.prefix, env.prefix), "body")
+			assert.Contains(t, body, fmt.Sprintf(`<code>POST %s/post</code>`, env.prefix), "body")
+			assert.Contains(t, body, fmt.Sprintf(`<code>%s/status/{code}</code>`, env.prefix), "body")
+		})
+
 		t.Run("not found"+env.prefix, func(t *testing.T) {
 			t.Parallel()
 			req := newTestRequest(t, "GET", env.prefix+"/foo", env)
@@ -120,6 +153,29 @@ func TestIndex(t *testing.T) {
 	}
 }
 
+func TestIndexCustomRoutes(t *testing.T) {
+	t.Parallel()
+
+	app := New(
+		WithPrefix("/pfx"),
+		WithRoutes(map[string]http.Handler{
+			"GET /custom-route":       http.NotFoundHandler(),
+			"POST /custom-route/{id}": http.NotFoundHandler(),
+		}),
+	)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/pfx/", nil))
+	body := w.Body.String()
+
+	assert.Contains(t, body, `<a href="/pfx/custom-route"><code>GET /pfx/custom-route</code></a></li>`, "body")
+	assert.Contains(t, body, `<code>POST /pfx/custom-route/{id}</code></li>`, "body")
+
+	// built-in routes are listed once, along with their descriptions
+	assert.Contains(t, body, `<a href="/pfx/"><code>GET /pfx/</code></a> This page.</li>`, "body")
+	assert.Contains(t, body, `<code>/pfx/cache/{numSeconds}</code> Sets a Cache-Control header for <em>n</em> seconds. (<a href="/pfx/cache/60">example</a>)</li>`, "body")
+	assert.Equal(t, strings.Count(body, "<code>/pfx/xml</code>"), 1, "expected /xml to be listed once")
+}
+
 func TestEnv(t *testing.T) {
 	t.Run("default environment", func(t *testing.T) {
 		t.Parallel()
@@ -257,6 +313,76 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestPrettyJSON(t *testing.T) {
+	isPretty := func(body string) bool {
+		return strings.Contains(body, "\n  \"")
+	}
+
+	t.Run("pretty by default", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/get")
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+		body := must.ReadAll(t, resp.Body)
+		assert.Equal(t, isPretty(body), true, "expected pretty-printed JSON: %s", body)
+	})
+
+	t.Run("pretty=false overrides server default", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/get?pretty=false")
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+		assert.ContentType(t, resp, jsonContentType)
+		body := must.ReadAll(t, resp.Body)
+		assert.Equal(t, isPretty(body), false, "expected compact JSON: %s", body)
+		assert.Equal(t, strings.Count(body, "\n"), 1, "expected single line of JSON: %s", body)
+
+		result := must.Unmarshal[noBodyResponse](t, strings.NewReader(body))
+		assert.Equal(t, result.Args.Get("pretty"), "false", "expected pretty arg to be echoed")
+	})
+
+	t.Run("compact server default", func(t *testing.T) {
+		t.Parallel()
+
+		app := New(WithCompactJSON(true))
+		srv, client := newTestServer(app)
+		defer srv.Close()
+
+		for query, wantPretty := range map[string]bool{
+			"":              false,
+			"?pretty=false": false,
+			"?pretty=true":  true,
+			"?pretty=1":     true,
+		} {
+			req, err := http.NewRequest("GET", srv.URL+"/get"+query, nil)
+			assert.NilError(t, err)
+			resp := must.DoReq(t, client, req)
+			assert.StatusCode(t, resp, http.StatusOK)
+			body := must.ReadAll(t, resp.Body)
+			assert.Equal(t, isPretty(body), wantPretty, "unexpected formatting for query %q: %s", query, body)
+		}
+	})
+
+	t.Run("invalid pretty is ignored and echoed", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/get?pretty=foo")
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+		body := must.ReadAll(t, resp.Body)
+		assert.Equal(t, isPretty(body), true, "expected server default formatting: %s", body)
+
+		result := must.Unmarshal[noBodyResponse](t, strings.NewReader(body))
+		assert.Equal(t, result.Args.Get("pretty"), "foo", "expected pretty arg to be echoed")
+	})
+
+	t.Run("compact through wrapping writers", func(t *testing.T) {
+		t.Parallel()
+		w := &metaResponseWriter{w: &compactJSONResponseWriter{&metaResponseWriter{w: httptest.NewRecorder()}}}
+		assert.Equal(t, isCompactJSON(w), true, "expected wrapped compact writer to be detected")
+		assert.Equal(t, isCompactJSON(httptest.NewRecorder()), false, "expected plain writer to be pretty")
+	})
+}
+
 func TestHead(t *testing.T) {
 	testCases := []struct {
 		verb     string
@@ -319,15 +445,48 @@ func TestCORS(t *testing.T) {
 		}{
 			{"Access-Control-Allow-Origin", "*"},
 			{"Access-Control-Allow-Credentials", "true"},
-			{"Access-Control-Allow-Methods", "GET, POST, HEAD, PUT, DELETE, PATCH, OPTIONS"},
+			{"Access-Control-Allow-Methods", "GET, HEAD, OPTIONS"},
 			{"Access-Control-Max-Age", "3600"},
 			{"Access-Control-Allow-Headers", ""},
+			{"Allow", "GET, HEAD, OPTIONS"},
 		}
 		for _, test := range headerTests {
 			assert.Header(t, resp, test.key, test.expected)
 		}
 	})
 
+	allowedMethodsTests := []struct {
+		path string
+		want string
+	}{
+		{"/get", "GET, HEAD, OPTIONS"},
+		{"/head", "HEAD, OPTIONS"},
+		{"/post", "POST, OPTIONS"},
+		{"/put", "PUT, OPTIONS"},
+		{"/patch", "PATCH, OPTIONS"},
+		{"/delete", "DELETE, OPTIONS"},
+		{"/anything", corsAllowedMethods},
+		{"/anything/foo/bar", corsAllowedMethods},
+		{"/status/200", corsAllowedMethods},
+		{"/websocket/echo", "GET, HEAD, OPTIONS"},
+		{"/does/not/exist", "OPTIONS"},
+	}
+	for _, env := range envs {
+		env := env
+		for _, test := range allowedMethodsTests {
+			test := test
+			t.Run("CORS/allowed_methods"+env.prefix+test.path, func(t *testing.T) {
+				t.Parallel()
+				req := newTestRequest(t, "OPTIONS", env.prefix+test.path, env)
+				resp := must.DoReq(t, env.client, req)
+				defer consumeAndCloseBody(resp)
+				assert.StatusCode(t, resp, 200)
+				assert.Header(t, resp, "Access-Control-Allow-Methods", test.want)
+				assert.Header(t, resp, "Allow", test.want)
+			})
+		}
+	}
+
 	t.Run("CORS/allow_headers", func(t *testing.T) {
 		t.Parallel()
 
@@ -348,6 +507,459 @@ func TestCORS(t *testing.T) {
 	})
 }
 
+func TestCORSPreflightDebug(t *testing.T) {
+	testCases := map[string]struct {
+		query   string
+		headers map[string]string
+		want    corsPreflightDebugResponse
+	}{
+		"no inputs": {
+			want: corsPreflightDebugResponse{
+				Allowed: false,
+				Headers: http.Header{
+					"Access-Control-Allow-Origin":      {"*"},
+					"Access-Control-Allow-Credentials": {"true"},
+					"Access-Control-Allow-Methods":     {corsAllowedMethods},
+					"Access-Control-Max-Age":           {"3600"},
+				},
+			},
+		},
+		"query params": {
+			query: "?origin=https://example.com&request_method=put&request_headers=X-Foo,X-Bar",
+			want: corsPreflightDebugResponse{
+				Origin:         "https://example.com",
+				RequestMethod:  "PUT",
+				RequestHeaders: "X-Foo,X-Bar",
+				Allowed:        true,
+				Headers: http.Header{
+					"Access-Control-Allow-Origin":      {"https://example.com"},
+					"Access-Control-Allow-Credentials": {"true"},
+					"Access-Control-Allow-Methods":     {corsAllowedMethods},
+					"Access-Control-Max-Age":           {"3600"},
+					"Access-Control-Allow-Headers":     {"X-Foo,X-Bar"},
+				},
+			},
+		},
+		"request headers": {
+			headers: map[string]string{
+				"Origin":                         "https://example.org",
+				"Access-Control-Request-Method":  "DELETE",
+				"Access-Control-Request-Headers": "Authorization",
+			},
+			want: corsPreflightDebugResponse{
+				Origin:         "https://example.org",
+				RequestMethod:  "DELETE",
+				RequestHeaders: "Authorization",
+				Allowed:        true,
+				Headers: http.Header{
+					"Access-Control-Allow-Origin":      {"https://example.org"},
+					"Access-Control-Allow-Credentials": {"true"},
+					"Access-Control-Allow-Methods":     {corsAllowedMethods},
+					"Access-Control-Max-Age":           {"3600"},
+					"Access-Control-Allow-Headers":     {"Authorization"},
+				},
+			},
+		},
+		"query params take precedence": {
+			query: "?origin=https://query.example&request_method=GET",
+			headers: map[string]string{
+				"Origin":                        "https://header.example",
+				"Access-Control-Request-Method": "DELETE",
+			},
+			want: corsPreflightDebugResponse{
+				Origin:        "https://query.example",
+				RequestMethod: "GET",
+				Allowed:       true,
+				Headers: http.Header{
+					"Access-Control-Allow-Origin":      {"https://query.example"},
+					"Access-Control-Allow-Credentials": {"true"},
+					"Access-Control-Allow-Methods":     {corsAllowedMethods},
+					"Access-Control-Max-Age":           {"3600"},
+				},
+			},
+		},
+		"path": {
+			query: "?origin=https://example.com&request_method=POST&path=/get",
+			want: corsPreflightDebugResponse{
+				Origin:        "https://example.com",
+				RequestMethod: "POST",
+				Path:          "/get",
+				Allowed:       false,
+				Headers: http.Header{
+					"Access-Control-Allow-Origin":      {"https://example.com"},
+					"Access-Control-Allow-Credentials": {"true"},
+					"Access-Control-Allow-Methods":     {"GET, HEAD, OPTIONS"},
+					"Access-Control-Max-Age":           {"3600"},
+				},
+			},
+		},
+		"method not allowed": {
+			query: "?origin=https://example.com&request_method=PROPFIND",
+			want: corsPreflightDebugResponse{
+				Origin:        "https://example.com",
+				RequestMethod: "PROPFIND",
+				Allowed:       false,
+				Headers: http.Header{
+					"Access-Control-Allow-Origin":      {"https://example.com"},
+					"Access-Control-Allow-Credentials": {"true"},
+					"Access-Control-Allow-Methods":     {corsAllowedMethods},
+					"Access-Control-Max-Age":           {"3600"},
+				},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "POST", "/cors-preflight-debug"+tc.query)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			resp := must.DoReq(t, client, req)
+			result := mustParseResponse[corsPreflightDebugResponse](t, resp)
+			assert.DeepEqual(t, result, tc.want, "unexpected preflight decision")
+		})
+	}
+
+	t.Run("invalid path", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/cors-preflight-debug?path=get")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
+func TestMaxQueryParams(t *testing.T) {
+	limitedSrv, limitedClient := newTestServer(New(WithMaxQueryParams(3)))
+	t.Cleanup(limitedSrv.Close)
+
+	testCases := []struct {
+		query      string
+		wantStatus int
+	}{
+		{"", http.StatusOK},
+		{"?a=1&b=2&c=3", http.StatusOK},
+		{"?a=1&a=2&a=3&a=4&b=5", http.StatusOK}, // repeated params count once
+		{"?a=1&b=2&c=3&d=4", http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run("limited"+tc.query, func(t *testing.T) {
+			t.Parallel()
+			req, err := http.NewRequest("GET", limitedSrv.URL+"/get"+tc.query, nil)
+			assert.NilError(t, err)
+			resp := must.DoReq(t, limitedClient, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, tc.wantStatus)
+		})
+	}
+
+	t.Run("unlimited by default", func(t *testing.T) {
+		t.Parallel()
+		params := url.Values{}
+		for i := 0; i < 100; i++ {
+			params.Set(fmt.Sprintf("param%d", i), "x")
+		}
+		req := newTestRequest(t, "GET", "/get?"+params.Encode())
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[noBodyResponse](t, resp)
+		assert.Equal(t, len(result.Args), 100, "expected all params to be echoed")
+	})
+}
+
+func TestBodyReadTimeout(t *testing.T) {
+	timeoutSrv, timeoutClient := newTestServer(New(WithBodyReadTimeout(100 * time.Millisecond)))
+	t.Cleanup(timeoutSrv.Close)
+
+	t.Run("slow upload times out", func(t *testing.T) {
+		t.Parallel()
+
+		conn, err := net.Dial("tcp", timeoutSrv.Listener.Addr().String())
+		assert.NilError(t, err)
+		defer conn.Close()
+
+		// send the headers and only part of the promised body, then stall
+		reqBytes := "POST /post HTTP/1.1\r\nHost: test\r\nContent-Type: text/plain\r\nContent-Length: 10\r\n\r\nabc"
+		_, err = conn.Write([]byte(reqBytes))
+		assert.NilError(t, err)
+
+		assert.NilError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		assert.NilError(t, err)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusRequestTimeout)
+	})
+
+	t.Run("prompt upload okay", func(t *testing.T) {
+		t.Parallel()
+
+		req, err := http.NewRequest("POST", timeoutSrv.URL+"/post", strings.NewReader("hello"))
+		assert.NilError(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+		resp := must.DoReq(t, timeoutClient, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.Data, "hello", "incorrect data")
+	})
+
+	t.Run("slow handler after body is read okay", func(t *testing.T) {
+		t.Parallel()
+
+		req, err := http.NewRequest("POST", timeoutSrv.URL+"/delay/0.3", strings.NewReader("hello"))
+		assert.NilError(t, err)
+		resp := must.DoReq(t, timeoutClient, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusOK)
+	})
+}
+
+func TestRequestHook(t *testing.T) {
+	t.Parallel()
+
+	hookSrv, hookClient := newTestServer(New(
+		WithRequestHook(func(r *http.Request) {
+			r.Header.Set("X-Hooked", "true")
+			r.URL.Path = strings.TrimPrefix(r.URL.Path, "/legacy")
+		}),
+	))
+	t.Cleanup(hookSrv.Close)
+
+	for _, path := range []string{"/headers", "/legacy/headers"} {
+		path := path
+		t.Run(path, func(t *testing.T) {
+			t.Parallel()
+			req, err := http.NewRequest("GET", hookSrv.URL+path, nil)
+			assert.NilError(t, err)
+			resp := must.DoReq(t, hookClient, req)
+			result := mustParseResponse[headersResponse](t, resp)
+			assert.Equal(t, result.Headers.Get("X-Hooked"), "true", "expected hook to inject header")
+		})
+	}
+}
+
+func TestForcedStatus(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		code     int
+		wantBody bool
+	}{
+		{http.StatusServiceUnavailable, true},
+		{http.StatusNoContent, false},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(strconv.Itoa(tc.code), func(t *testing.T) {
+			t.Parallel()
+			forcedSrv, forcedClient := newTestServer(New(WithForcedStatus(tc.code)))
+			t.Cleanup(forcedSrv.Close)
+
+			for _, path := range []string{"/get", "/status/418", "/redirect/1", "/robots.txt", "/stream/2", "/not-found"} {
+				path := path
+				t.Run(path, func(t *testing.T) {
+					t.Parallel()
+					req, err := http.NewRequest("GET", forcedSrv.URL+path, nil)
+					assert.NilError(t, err)
+					resp := must.DoReq(t, forcedClient, req)
+					defer consumeAndCloseBody(resp)
+					// compare codes directly, since assert.StatusCode also
+					// checks the content type of error responses
+					assert.Equal(t, resp.StatusCode, tc.code, "incorrect status code")
+				})
+			}
+
+			t.Run("body", func(t *testing.T) {
+				t.Parallel()
+				req, err := http.NewRequest("GET", forcedSrv.URL+"/get", nil)
+				assert.NilError(t, err)
+				resp := must.DoReq(t, forcedClient, req)
+				body := must.ReadAll(t, resp.Body)
+				assert.Equal(t, body != "", tc.wantBody, "incorrect presence of body")
+			})
+		})
+	}
+
+	for _, code := range []int{-1, 100, 199, 600} {
+		code := code
+		t.Run(fmt.Sprintf("invalid code/%d", code), func(t *testing.T) {
+			t.Parallel()
+			defer func() {
+				r := recover()
+				if r == nil {
+					t.Fatalf("expected panic for invalid forced status %d", code)
+				}
+				assert.Contains(t, fmt.Sprint(r), "httpbin: invalid forced status code", "incorrect panic message")
+			}()
+			New(WithForcedStatus(code))
+		})
+	}
+}
+
+func TestRequestIDHeader(t *testing.T) {
+	testCases := map[string]struct {
+		opt        OptionFunc
+		wantHeader string
+	}{
+		"default header name": {
+			opt:        WithRequestIDHeader(""),
+			wantHeader: "X-Request-Id",
+		},
+		"custom header name": {
+			opt:        WithRequestIDHeader("x-correlation-id"),
+			wantHeader: "X-Correlation-Id",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			idSrv, idClient := newTestServer(New(tc.opt))
+			t.Cleanup(idSrv.Close)
+
+			t.Run("generated", func(t *testing.T) {
+				t.Parallel()
+				req, err := http.NewRequest("GET", idSrv.URL+"/headers", nil)
+				assert.NilError(t, err)
+				resp := must.DoReq(t, idClient, req)
+				result := mustParseResponse[headersResponse](t, resp)
+
+				id := resp.Header.Get(tc.wantHeader)
+				testValidUUIDv4(t, id)
+				assert.Equal(t, result.Headers.Get(tc.wantHeader), id, "expected handler to see generated ID")
+			})
+
+			t.Run("echoed", func(t *testing.T) {
+				t.Parallel()
+				req, err := http.NewRequest("GET", idSrv.URL+"/get", nil)
+				assert.NilError(t, err)
+				req.Header.Set(tc.wantHeader, "my-request-id")
+				resp := must.DoReq(t, idClient, req)
+				defer consumeAndCloseBody(resp)
+				assert.Header(t, resp, tc.wantHeader, "my-request-id")
+			})
+		})
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/get")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.Header(t, resp, "X-Request-Id", "")
+	})
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	t.Run("echoed without cache", func(t *testing.T) {
+		t.Parallel()
+		for i := 0; i < 2; i++ {
+			req := newTestRequestWithBody(t, "POST", "/anything", strings.NewReader(fmt.Sprintf("body %d", i)))
+			req.Header.Set("Content-Type", "text/plain")
+			req.Header.Set("Idempotency-Key", "echo-key")
+			resp := must.DoReq(t, client, req)
+			assert.Header(t, resp, "Idempotency-Key", "echo-key")
+			assert.Header(t, resp, "Idempotent-Replayed", "")
+			result := mustParseResponse[bodyResponse](t, resp)
+			assert.Equal(t, result.IdempotencyKey, "echo-key", "expected idempotency key in body")
+			assert.Equal(t, result.Data, fmt.Sprintf("body %d", i), "expected fresh response")
+		}
+	})
+
+	t.Run("echoed on non-JSON endpoints", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/status/204")
+		req.Header.Set("Idempotency-Key", "status-key")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusNoContent)
+		assert.Header(t, resp, "Idempotency-Key", "status-key")
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "POST", "/anything")
+		resp := must.DoReq(t, client, req)
+		assert.Header(t, resp, "Idempotency-Key", "")
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.IdempotencyKey, "", "expected no idempotency key in body")
+	})
+
+	t.Run("replayed from cache", func(t *testing.T) {
+		t.Parallel()
+
+		srv, client := newTestServer(New(WithIdempotencyCache(time.Minute)))
+		defer srv.Close()
+
+		doRequest := func(key string, body string) *http.Response {
+			req, err := http.NewRequest("POST", srv.URL+"/anything", strings.NewReader(body))
+			assert.NilError(t, err)
+			req.Header.Set("Content-Type", "text/plain")
+			req.Header.Set("Idempotency-Key", key)
+			return must.DoReq(t, client, req)
+		}
+
+		resp := doRequest("cache-key", "original")
+		assert.Header(t, resp, "Idempotent-Replayed", "")
+		original := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, original.Data, "original", "unexpected original response")
+
+		// repeated key replays the original response, ignoring the new body
+		resp = doRequest("cache-key", "repeated")
+		assert.Header(t, resp, "Idempotency-Key", "cache-key")
+		assert.Header(t, resp, "Idempotent-Replayed", "true")
+		replayed := mustParseResponse[bodyResponse](t, resp)
+		assert.DeepEqual(t, replayed, original, "expected replayed response")
+
+		// a different key gets a fresh response
+		resp = doRequest("other-key", "fresh")
+		assert.Header(t, resp, "Idempotent-Replayed", "")
+		fresh := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, fresh.Data, "fresh", "expected fresh response")
+	})
+
+	t.Run("cache entries expire", func(t *testing.T) {
+		t.Parallel()
+
+		srv, client := newTestServer(New(WithIdempotencyCache(50 * time.Millisecond)))
+		defer srv.Close()
+
+		for _, wantReplayed := range []string{"", "true"} {
+			req, err := http.NewRequest("GET", srv.URL+"/uuid", nil)
+			assert.NilError(t, err)
+			req.Header.Set("Idempotency-Key", "expiring-key")
+			resp := must.DoReq(t, client, req)
+			consumeAndCloseBody(resp)
+			assert.Header(t, resp, "Idempotent-Replayed", wantReplayed)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		req, err := http.NewRequest("GET", srv.URL+"/uuid", nil)
+		assert.NilError(t, err)
+		req.Header.Set("Idempotency-Key", "expiring-key")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.Header(t, resp, "Idempotent-Replayed", "")
+	})
+
+	t.Run("streaming responses are not cached", func(t *testing.T) {
+		t.Parallel()
+
+		srv, client := newTestServer(New(WithIdempotencyCache(time.Minute)))
+		defer srv.Close()
+
+		for i := 0; i < 2; i++ {
+			req, err := http.NewRequest("GET", srv.URL+"/stream/2", nil)
+			assert.NilError(t, err)
+			req.Header.Set("Idempotency-Key", "stream-key")
+			resp := must.DoReq(t, client, req)
+			consumeAndCloseBody(resp)
+			assert.Header(t, resp, "Idempotent-Replayed", "")
+		}
+	})
+}
+
 func TestIP(t *testing.T) {
 	testCases := map[string]struct {
 		remoteAddr string
@@ -417,73 +1029,1662 @@ func TestUserAgent(t *testing.T) {
 	assert.Equal(t, "test", result.UserAgent, "incorrect user agent")
 }
 
+func TestUserAgentParse(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]userAgentParseResponse{
+		"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0": {
+			Family:  "Firefox",
+			Version: "121.0",
+			OS:      "Linux",
+		},
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15": {
+			Family:    "Safari",
+			Version:   "17.2",
+			OS:        "Mac OS X",
+			OSVersion: "10.15.7",
+		},
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0": {
+			Family:    "Edge",
+			Version:   "120.0.0.0",
+			OS:        "Windows",
+			OSVersion: "10",
+		},
+		"curl/8.4.0": {
+			Family:  "curl",
+			Version: "8.4.0",
+			OS:      "Other",
+		},
+		"": {
+			Family: "Other",
+			OS:     "Other",
+		},
+	}
+	for ua, want := range testCases {
+		ua, want := ua, want
+		t.Run(fmt.Sprintf("%q", ua), func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", "/user-agent/parse")
+			req.Header.Set("User-Agent", ua)
+			resp := must.DoReq(t, client, req)
+			result := mustParseResponse[userAgentParseResponse](t, resp)
+			want.Raw = ua
+			assert.Equal(t, result, want, "incorrect parsed user agent")
+		})
+	}
+}
+
 func TestHeaders(t *testing.T) {
 	t.Parallel()
 
-	req := newTestRequest(t, "GET", "/headers")
-	req.Host = "test-host"
-	req.Header.Set("User-Agent", "test")
-	req.Header.Set("Foo-Header", "foo")
-	req.Header.Add("Bar-Header", "bar1")
-	req.Header.Add("Bar-Header", "bar2")
+	req := newTestRequest(t, "GET", "/headers")
+	req.Host = "test-host"
+	req.Header.Set("User-Agent", "test")
+	req.Header.Set("Foo-Header", "foo")
+	req.Header.Add("Bar-Header", "bar1")
+	req.Header.Add("Bar-Header", "bar2")
+
+	resp := must.DoReq(t, client, req)
+	result := mustParseResponse[headersResponse](t, resp)
+
+	// Host header requires special treatment, because it's a field on the
+	// http.Request struct itself, not part of its headers map
+	host := result.Headers.Get("Host")
+	assert.Equal(t, req.Host, host, "missing or incorrect Host header")
+
+	for k, expectedValues := range req.Header {
+		values := result.Headers.Values(k)
+		assert.DeepEqual(t, expectedValues, values, "missing or incorrect header for key %q", k)
+	}
+}
+
+func TestHeadersWithCounts(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ok", func(t *testing.T) {
+		t.Parallel()
+
+		req := newTestRequest(t, "GET", "/headers?with_counts=true")
+		req.Header.Set("Foo-Header", "foo")
+		req.Header.Add("Bar-Header", "bar1")
+		req.Header.Add("Bar-Header", "bar2")
+
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[headersResponse](t, resp)
+		assert.Equal(t, result.Counts["Foo-Header"], 1, "incorrect count for Foo-Header")
+		assert.Equal(t, result.Counts["Bar-Header"], 2, "incorrect count for Bar-Header")
+		assert.DeepEqual(t, result.Headers.Values("Bar-Header"), []string{"bar1", "bar2"}, "incorrect Bar-Header values")
+	})
+
+	t.Run("omitted by default", func(t *testing.T) {
+		t.Parallel()
+
+		req := newTestRequest(t, "GET", "/headers")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[headersResponse](t, resp)
+		assert.Equal(t, len(result.Counts), 0, "expected no counts")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+
+		req := newTestRequest(t, "GET", "/headers?with_counts=nope")
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
+func TestHeadersPrefix(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		prefix   string
+		wantKeys []string
+	}{
+		"matching prefix": {
+			prefix:   "X-",
+			wantKeys: []string{"X-Bar", "X-Foo"},
+		},
+		"case insensitive": {
+			prefix:   "x-f",
+			wantKeys: []string{"X-Foo"},
+		},
+		"no matches": {
+			prefix:   "Nope-",
+			wantKeys: []string{},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", "/headers?prefix="+url.QueryEscape(tc.prefix))
+			req.Header.Set("X-Foo", "foo")
+			req.Header.Set("X-Bar", "bar")
+			req.Header.Set("Other-Header", "other")
+
+			resp := must.DoReq(t, client, req)
+			result := mustParseResponse[headersResponse](t, resp)
+
+			gotKeys := make([]string, 0, len(result.Headers))
+			for k := range result.Headers {
+				gotKeys = append(gotKeys, k)
+			}
+			slices.Sort(gotKeys)
+			assert.DeepEqual(t, gotKeys, tc.wantKeys, "incorrect filtered headers")
+		})
+	}
+}
+
+func TestPost(t *testing.T) {
+	testRequestWithBody(t, "POST", "/post")
+}
+
+func TestPut(t *testing.T) {
+	testRequestWithBody(t, "PUT", "/put")
+}
+
+func TestDelete(t *testing.T) {
+	testRequestWithBody(t, "DELETE", "/delete")
+}
+
+func TestPatch(t *testing.T) {
+	testRequestWithBody(t, "PATCH", "/patch")
+}
+
+func TestAnything(t *testing.T) {
+	var (
+		verbs = []string{
+			"GET",
+			"DELETE",
+			"PATCH",
+			"POST",
+			"PUT",
+		}
+		paths = []string{
+			"/anything",
+			"/anything/else",
+		}
+	)
+	for _, path := range paths {
+		for _, verb := range verbs {
+			testRequestWithBody(t, verb, path)
+		}
+	}
+
+	t.Run("HEAD", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "HEAD", "/anything")
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+		assert.BodyEquals(t, resp, "")
+		assert.Header(t, resp, "Content-Length", "") // responses to HEAD requests should not have a Content-Length header
+	})
+}
+
+func TestAnythingRawPath(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		path        string
+		wantPath    string
+		wantRawPath string
+	}{
+		{"/anything/a%2Fb", "/anything/a/b", "/anything/a%2Fb"},
+		{"/anything/a%20b", "/anything/a b", "/anything/a%20b"},
+		{"/anything/plain", "/anything/plain", "/anything/plain"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		for _, verb := range []string{"GET", "POST"} {
+			verb := verb
+			t.Run(verb+tc.path, func(t *testing.T) {
+				t.Parallel()
+				req := newTestRequest(t, verb, tc.path)
+				resp := must.DoReq(t, client, req)
+				result := mustParseResponse[bodyResponse](t, resp)
+				assert.Equal(t, result.Path, tc.wantPath, "incorrect decoded path")
+				assert.Equal(t, result.RawPath, tc.wantRawPath, "incorrect raw path")
+			})
+		}
+	}
+}
+
+func TestReceivedAt(t *testing.T) {
+	t.Parallel()
+
+	for _, path := range []string{"/get", "/anything"} {
+		path := path
+		t.Run(path, func(t *testing.T) {
+			t.Parallel()
+
+			before := time.Now().Truncate(time.Millisecond)
+			req := newTestRequest(t, "GET", path)
+			resp := must.DoReq(t, client, req)
+			result := mustParseResponse[bodyResponse](t, resp)
+			after := time.Now()
+
+			receivedAt, err := time.Parse(time.RFC3339Nano, result.ReceivedAt)
+			assert.NilError(t, err)
+			if receivedAt.Before(before) || receivedAt.After(after) {
+				t.Fatalf("expected received_at %s between %s and %s", receivedAt, before, after)
+			}
+			if _, err := time.Parse("2006-01-02T15:04:05.000Z07:00", result.ReceivedAt); err != nil {
+				t.Fatalf("expected received_at %q to have millisecond precision: %s", result.ReceivedAt, err)
+			}
+		})
+	}
+}
+
+func TestDeterministicETag(t *testing.T) {
+	t.Parallel()
+
+	for _, path := range []string{"/get", "/anything"} {
+		path := path
+		t.Run(path, func(t *testing.T) {
+			t.Parallel()
+
+			doReq := func(ifNoneMatch string) *http.Response {
+				req := newTestRequest(t, "GET", path+"?etag=true&foo=bar")
+				if ifNoneMatch != "" {
+					req.Header.Set("If-None-Match", ifNoneMatch)
+				}
+				resp := must.DoReq(t, client, req)
+				t.Cleanup(func() { consumeAndCloseBody(resp) })
+				return resp
+			}
+
+			resp1 := doReq("")
+			assert.StatusCode(t, resp1, http.StatusOK)
+			etag := resp1.Header.Get("ETag")
+			if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+				t.Fatalf("expected strong ETag, got %q", etag)
+			}
+
+			resp2 := doReq("")
+			assert.StatusCode(t, resp2, http.StatusOK)
+			assert.Header(t, resp2, "ETag", etag)
+
+			resp3 := doReq(etag)
+			assert.StatusCode(t, resp3, http.StatusNotModified)
+			assert.Header(t, resp3, "ETag", etag)
+			assert.BodyEquals(t, resp3, "")
+
+			resp4 := doReq(`"other"`)
+			assert.StatusCode(t, resp4, http.StatusOK)
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/get?etag=foo")
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
+func TestAnythingRequestLine(t *testing.T) {
+	t.Parallel()
+
+	t.Run("live request", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/anything/foo?a=1&b=2")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.RequestLine, "GET /anything/foo?a=1&b=2 HTTP/1.1", "incorrect request_line")
+	})
+
+	t.Run("proto version", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/anything")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, *result.ProtoMajor, 1, "incorrect proto_major")
+		assert.Equal(t, *result.ProtoMinor, 1, "incorrect proto_minor")
+	})
+
+	t.Run("proto version omitted by other endpoints", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "POST", "/post")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		if result.ProtoMajor != nil || result.ProtoMinor != nil {
+			t.Fatalf("expected proto version to be omitted, got %v and %v", result.ProtoMajor, result.ProtoMinor)
+		}
+	})
+
+	testCases := []struct {
+		method string
+		target string
+		proto  string
+		want   string
+	}{
+		{"GET", "/anything", "HTTP/1.0", "GET /anything HTTP/1.0"},
+		{"POST", "/anything?x=%20y", "HTTP/1.1", "POST /anything?x=%20y HTTP/1.1"},
+		{"PUT", "/anything/a%2Fb?", "HTTP/2.0", "PUT /anything/a%2Fb? HTTP/2.0"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.want, func(t *testing.T) {
+			t.Parallel()
+
+			// use a recorder so that we can control the request's protocol
+			req := httptest.NewRequest(tc.method, tc.target, nil)
+			req.Proto = tc.proto
+			req.ProtoMajor, req.ProtoMinor, _ = http.ParseHTTPVersion(tc.proto)
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, req)
+
+			assert.Equal(t, w.Code, http.StatusOK, "incorrect status code")
+			result := must.Unmarshal[bodyResponse](t, w.Body)
+			assert.Equal(t, result.RequestLine, tc.want, "incorrect request_line")
+			assert.Equal(t, *result.ProtoMajor, req.ProtoMajor, "incorrect proto_major")
+			assert.Equal(t, *result.ProtoMinor, req.ProtoMinor, "incorrect proto_minor")
+		})
+	}
+}
+
+func TestAnythingSchemeSource(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		headers    map[string]string
+		tls        bool
+		wantScheme string
+		wantSource string
+	}{
+		"default": {
+			wantScheme: "http",
+			wantSource: "default",
+		},
+		"tls": {
+			tls:        true,
+			wantScheme: "https",
+			wantSource: "tls",
+		},
+		"x-forwarded-proto": {
+			headers:    map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Protocol": "http"},
+			wantScheme: "https",
+			wantSource: "x-forwarded-proto",
+		},
+		"x-forwarded-protocol": {
+			headers:    map[string]string{"X-Forwarded-Protocol": "https", "X-Forwarded-Ssl": "off"},
+			wantScheme: "https",
+			wantSource: "x-forwarded-protocol",
+		},
+		"x-forwarded-ssl": {
+			headers:    map[string]string{"X-Forwarded-Ssl": "on"},
+			wantScheme: "https",
+			wantSource: "x-forwarded-ssl",
+		},
+		"headers take precedence over tls": {
+			headers:    map[string]string{"X-Forwarded-Proto": "http"},
+			tls:        true,
+			wantScheme: "http",
+			wantSource: "x-forwarded-proto",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// use a recorder so that we can control the request's TLS state
+			req := httptest.NewRequest("GET", "/anything", nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			if tc.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, req)
+
+			assert.Equal(t, w.Code, http.StatusOK, "incorrect status code")
+			result := must.Unmarshal[bodyResponse](t, w.Body)
+			assert.Equal(t, result.SchemeSource, tc.wantSource, "incorrect scheme_source")
+			assert.Equal(t, strings.SplitN(result.URL, ":", 2)[0], tc.wantScheme, "incorrect URL scheme")
+		})
+	}
+}
+
+func TestAnythingMirrorHeaders(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		mirror     string
+		wantHeader map[string][]string
+		notHeaders []string
+	}{
+		"wildcard": {
+			mirror: "X-Test-*",
+			wantHeader: map[string][]string{
+				"X-Test-Foo": {"foo1", "foo2"},
+				"X-Test-Bar": {"bar"},
+			},
+			notHeaders: []string{"X-Other"},
+		},
+		"case insensitive list": {
+			mirror: "x-test-foo, X-OTHER",
+			wantHeader: map[string][]string{
+				"X-Test-Foo": {"foo1", "foo2"},
+				"X-Other":    {"other"},
+			},
+			notHeaders: []string{"X-Test-Bar"},
+		},
+		"excluded headers are not mirrored": {
+			mirror:     "X-Ignore-*,X-Info-This-Key",
+			notHeaders: []string{"X-Ignore-Me", "X-Info-This-Key"},
+		},
+		"framing headers are not mirrored": {
+			mirror: "*",
+			wantHeader: map[string][]string{
+				"X-Other": {"other"},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", "/anything?mirror_headers="+url.QueryEscape(tc.mirror))
+			req.Header.Add("X-Test-Foo", "foo1")
+			req.Header.Add("X-Test-Foo", "foo2")
+			req.Header.Set("X-Test-Bar", "bar")
+			req.Header.Set("X-Other", "other")
+			req.Header.Set("X-Ignore-Me", "secret")
+			req.Header.Set("X-Info-This-Key", "secret")
+
+			resp := must.DoReq(t, client, req)
+			_ = mustParseResponse[bodyResponse](t, resp)
+			for k, want := range tc.wantHeader {
+				assert.DeepEqual(t, resp.Header.Values(k), want, "incorrect mirrored header %q", k)
+			}
+			for _, k := range tc.notHeaders {
+				assert.Equal(t, resp.Header.Get(k), "", "unexpected mirrored header %q", k)
+			}
+		})
+	}
+}
+
+func TestAnythingConditionalStatus(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		query      string
+		header     string
+		wantStatus int
+	}{
+		{"if_header=X-Test&then_status=503&else_status=200", "X-Test", http.StatusServiceUnavailable},
+		{"if_header=X-Test&then_status=503&else_status=200", "", http.StatusOK},
+		{"if_header=x-test&then_status=201&else_status=418", "X-Test", http.StatusCreated},
+		{"if_header=X-Test&then_status=201&else_status=418", "X-Other", http.StatusTeapot},
+		{"if_header=X-Test&else_status=404", "X-Test", http.StatusOK},
+		{"if_header=X-Test&then_status=404", "", http.StatusOK},
+		{"if_header=Host&then_status=202", "", http.StatusAccepted},
+
+		// errors
+		{"if_header=X-Test&then_status=foo", "X-Test", http.StatusBadRequest},
+		{"if_header=X-Test&then_status=600", "X-Test", http.StatusBadRequest},
+		{"if_header=X-Test&then_status=100", "X-Test", http.StatusBadRequest},
+		{"if_header=X-Test&then_status=204", "X-Test", http.StatusBadRequest},
+		{"if_header=X-Test&then_status=304", "", http.StatusBadRequest},
+		{"if_header=X-Test&else_status=204", "", http.StatusBadRequest},
+		{"if_header=X-Test&then_status=200&else_status=foo", "X-Test", http.StatusBadRequest},
+		{"then_status=503", "", http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("%s/%s", tc.query, tc.header), func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", "/anything?"+tc.query)
+			if tc.header != "" {
+				req.Header.Set(tc.header, "1")
+			}
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, tc.wantStatus)
+		})
+	}
+}
+
+func TestAnythingHAR(t *testing.T) {
+	t.Parallel()
+
+	t.Run("with body", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequestWithBody(t, "POST", "/anything/foo?format=har&b=2&a=1&a=3", strings.NewReader(`{"hello":"world"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Test", "test-value")
+		req.AddCookie(&http.Cookie{Name: "k", Value: "v"})
+
+		resp := must.DoReq(t, client, req)
+		assert.ContentType(t, resp, jsonContentType)
+		result := mustParseResponse[harResponse](t, resp)
+
+		assert.Equal(t, result.Log.Version, "1.2", "incorrect HAR version")
+		assert.Equal(t, result.Log.Creator.Name, "go-httpbin", "incorrect HAR creator")
+		assert.Equal(t, len(result.Log.Entries), 1, "expected a single HAR entry")
+
+		entry := result.Log.Entries[0]
+		if _, err := time.Parse(time.RFC3339Nano, entry.StartedDateTime); err != nil {
+			t.Fatalf("invalid startedDateTime %q: %s", entry.StartedDateTime, err)
+		}
+
+		harReq := entry.Request
+		assert.Equal(t, harReq.Method, "POST", "incorrect method")
+		assert.Equal(t, harReq.URL, req.URL.String(), "incorrect url")
+		assert.Equal(t, harReq.HTTPVersion, "HTTP/1.1", "incorrect httpVersion")
+		assert.DeepEqual(t, harReq.Cookies, []harNameValue{{"k", "v"}}, "incorrect cookies")
+		assert.DeepEqual(t, harReq.QueryString, []harNameValue{
+			{"a", "1"},
+			{"a", "3"},
+			{"b", "2"},
+			{"format", "har"},
+		}, "incorrect queryString")
+		if !slices.Contains(harReq.Headers, harNameValue{"X-Test", "test-value"}) {
+			t.Fatalf("expected X-Test header in %#v", harReq.Headers)
+		}
+		assert.DeepEqual(t, harReq.PostData, &harPostData{
+			MimeType: "application/json",
+			Text:     `{"hello":"world"}`,
+		}, "incorrect postData")
+		assert.Equal(t, harReq.BodySize, 17, "incorrect bodySize")
+		assert.Equal(t, harReq.HeadersSize, -1, "incorrect headersSize")
+	})
+
+	t.Run("without body", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/anything?format=har")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[harResponse](t, resp)
+		harReq := result.Log.Entries[0].Request
+		assert.Equal(t, harReq.Method, "GET", "incorrect method")
+		if harReq.PostData != nil {
+			t.Fatalf("expected no postData, got %#v", harReq.PostData)
+		}
+		assert.Equal(t, harReq.BodySize, 0, "incorrect bodySize")
+	})
+
+	t.Run("binary body size is measured before encoding", func(t *testing.T) {
+		t.Parallel()
+		body := []byte{0xff, 0xfe, 0xfd, 0x00}
+		req := newTestRequestWithBody(t, "POST", "/anything?format=har", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[harResponse](t, resp)
+		harReq := result.Log.Entries[0].Request
+		assert.Equal(t, harReq.BodySize, len(body), "incorrect bodySize")
+		if harReq.PostData == nil || len(harReq.PostData.Text) == len(body) {
+			t.Fatalf("expected encoded postData, got %#v", harReq.PostData)
+		}
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/anything?format=xml")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
+func TestAnythingNegotiateEncoding(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		acceptEncoding string
+		wantChosen     string
+	}{
+		{"gzip, deflate", "gzip"},
+		{"gzip;q=0.1, deflate;q=0.9", "deflate"},
+		{"br", "br"},
+		{"zstd", "zstd"},
+		{"compress", "identity"},
+		{"identity;q=0, *;q=0", ""},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.acceptEncoding, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", "/anything?negotiate_encoding=true")
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			resp := must.DoReq(t, client, req)
+			result := mustParseResponse[bodyResponse](t, resp)
+			if result.EncodingNegotiation == nil {
+				t.Fatalf("expected encoding_negotiation in response")
+			}
+			assert.Equal(t, result.EncodingNegotiation.Chosen, tc.wantChosen, "incorrect chosen encoding")
+			assert.DeepEqual(t, result.EncodingNegotiation.Accepted, parseAcceptEncoding(tc.acceptEncoding), "incorrect accepted encodings")
+		})
+	}
+
+	t.Run("not requested", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/anything")
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		if result.EncodingNegotiation != nil {
+			t.Fatalf("expected no encoding_negotiation, got %#v", result.EncodingNegotiation)
+		}
+	})
+
+	t.Run("invalid negotiate_encoding", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/anything?negotiate_encoding=foo")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
+func TestMaxResponseBodySize(t *testing.T) {
+	t.Parallel()
+
+	t.Run("defaults to max body size", func(t *testing.T) {
+		t.Parallel()
+
+		// a near-limit binary body expands beyond MaxBodySize once base64
+		// encoded, but the limit applies to the raw body, so it is echoed
+		// in full
+		body := bytes.Repeat([]byte{0xff}, int(maxBodySize)-24)
+		req := newTestRequestWithBody(t, "POST", "/post", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.Truncated, false, "expected untruncated response")
+		assert.Equal(t, result.Data, encodeData(body, "application/octet-stream"), "incorrect data")
+	})
+
+	t.Run("small bodies are not truncated", func(t *testing.T) {
+		t.Parallel()
+
+		body := strings.Repeat("x", int(maxBodySize)-24)
+		req := newTestRequestWithBody(t, "POST", "/post", strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.Truncated, false, "expected untruncated response")
+		assert.Equal(t, result.Data, body, "incorrect data")
+	})
+
+	limitedSrv, limitedClient := newTestServer(New(
+		WithMaxBodySize(maxBodySize),
+		WithMaxResponseBodySize(16),
+	))
+	t.Cleanup(limitedSrv.Close)
+
+	t.Run("custom limit", func(t *testing.T) {
+		t.Parallel()
+
+		body := `{"foo": "bar", "baz": [1, 2, 3]}`
+		req, _ := http.NewRequest("POST", limitedSrv.URL+"/anything", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := must.DoReq(t, limitedClient, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.Truncated, true, "expected truncated response")
+		assert.Equal(t, result.Data, body[:16], "incorrect truncated data")
+		assert.Equal(t, result.JSON, nil, "expected truncated JSON to be omitted")
+		assert.Equal(t, result.JSONTruncated, true, "expected omitted JSON to be flagged")
+	})
+
+	t.Run("custom limit with binary body", func(t *testing.T) {
+		t.Parallel()
+
+		// the cut falls mid base64 quantum of the full body's encoding, but
+		// the truncated raw bytes are re-encoded as a valid data URL
+		body := bytes.Repeat([]byte{0xff, 0x00, 0xfe}, 10)
+		req, _ := http.NewRequest("POST", limitedSrv.URL+"/anything", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		resp := must.DoReq(t, limitedClient, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.Truncated, true, "expected truncated response")
+		assert.Equal(t, result.JSONTruncated, false, "expected no JSON to be flagged")
+
+		data, ok := strings.CutPrefix(result.Data, "data:application/octet-stream;base64,")
+		if !ok {
+			t.Fatalf("expected data URL, got %q", result.Data)
+		}
+		decoded, err := base64.URLEncoding.DecodeString(data)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, decoded, body[:16], "incorrect truncated data")
+	})
+
+	t.Run("custom limit with hex encoding", func(t *testing.T) {
+		t.Parallel()
+
+		body := bytes.Repeat([]byte{0xff}, 32)
+		req, _ := http.NewRequest("POST", limitedSrv.URL+"/anything?encoding=hex", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		resp := must.DoReq(t, limitedClient, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.Truncated, true, "expected truncated response")
+		assert.Equal(t, result.Data, hex.EncodeToString(body[:16]), "incorrect truncated data")
+	})
+}
+
+func TestAnythingExpectContinue(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]bool{
+		"100-continue": true,
+		"100-Continue": true,
+		"":             false,
+	}
+	for expect, want := range testCases {
+		expect, want := expect, want
+		t.Run(fmt.Sprintf("expect=%q", expect), func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequestWithBody(t, "POST", "/anything", strings.NewReader("body"))
+			if expect != "" {
+				req.Header.Set("Expect", expect)
+			}
+			resp := must.DoReq(t, client, req)
+			result := mustParseResponse[bodyResponse](t, resp)
+			assert.Equal(t, result.ExpectContinue, want, "incorrect expect_continue")
+		})
+	}
+
+	t.Run("omitted by other endpoints", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequestWithBody(t, "POST", "/post", strings.NewReader("body"))
+		req.Header.Set("Expect", "100-continue")
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+		if body := must.ReadAll(t, resp.Body); strings.Contains(body, "expect_continue") {
+			t.Fatalf("expected expect_continue to be omitted, got body %s", body)
+		}
+	})
+}
+
+func TestParamAndHeaderCounts(t *testing.T) {
+	t.Parallel()
+
+	for _, path := range []string{"/get", "/anything"} {
+		path := path
+		t.Run(path, func(t *testing.T) {
+			t.Parallel()
+
+			// repeated params and headers are counted once, and the Host
+			// header is always reported
+			r := httptest.NewRequest("GET", path+"?a=1&a=2&b=3&c=", nil)
+			r.Header.Add("X-Foo", "1")
+			r.Header.Add("X-Foo", "2")
+			r.Header.Set("X-Bar", "3")
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+			assert.Equal(t, w.Code, http.StatusOK, "incorrect status code")
+
+			result := must.Unmarshal[bodyResponse](t, w.Body)
+			assert.Equal(t, *result.QueryParamCount, 3, "incorrect query_param_count")
+			assert.Equal(t, *result.HeaderCount, 3, "incorrect header_count")
+		})
+	}
+
+	t.Run("no params", func(t *testing.T) {
+		t.Parallel()
+		r := httptest.NewRequest("GET", "/get", nil)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		assert.Contains(t, w.Body.String(), `"query_param_count": 0`, "expected zero query_param_count")
+	})
+
+	t.Run("omitted by other endpoints", func(t *testing.T) {
+		t.Parallel()
+		r := httptest.NewRequest("POST", "/post?a=1", nil)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		assert.Equal(t, w.Code, http.StatusOK, "incorrect status code")
+
+		result := must.Unmarshal[bodyResponse](t, w.Body)
+		if result.QueryParamCount != nil || result.HeaderCount != nil {
+			t.Fatalf("expected counts to be omitted, got %v and %v", result.QueryParamCount, result.HeaderCount)
+		}
+	})
+}
+
+func TestAnythingIncludeDump(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ok", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequestWithBody(t, "POST", "/anything?include_dump=true", strings.NewReader("hello"))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Test", "dump")
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Contains(t, result.Dump, "POST /anything?include_dump=true HTTP/1.1\r\n", "expected request line in dump")
+		assert.Contains(t, result.Dump, "X-Test: dump\r\n", "expected header in dump")
+		assert.Contains(t, result.Dump, "\r\n\r\nhello", "expected body in dump")
+		assert.Equal(t, result.Data, "hello", "expected body to still be parsed")
+	})
+
+	t.Run("omitted by default", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/anything")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.Dump, "", "expected no dump")
+	})
+
+	for _, query := range []string{"include_dump=foo", "include_dump=true&truncate=true"} {
+		query := query
+		t.Run("bad request "+query, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", "/anything?"+query)
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, http.StatusBadRequest)
+		})
+	}
+}
+
+func TestAnythingConnectionReused(t *testing.T) {
+	t.Parallel()
+
+	// a dedicated server ensures the first request opens a new connection
+	connSrv, connClient := newTestServer(New())
+	t.Cleanup(connSrv.Close)
+
+	doReq := func(client *http.Client) *bool {
+		req, err := http.NewRequest("GET", connSrv.URL+"/anything", nil)
+		assert.NilError(t, err)
+		resp := must.DoReq(t, client, req)
+		return mustParseResponse[bodyResponse](t, resp).ConnectionReused
+	}
+
+	t.Run("keep-alive", func(t *testing.T) {
+		first := doReq(connClient)
+		second := doReq(connClient)
+		if first == nil || *first {
+			t.Fatalf("expected first request not to reuse connection, got %v", first)
+		}
+		if second == nil || !*second {
+			t.Fatalf("expected second request to reuse connection, got %v", second)
+		}
+	})
+
+	t.Run("keep-alive disabled", func(t *testing.T) {
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		for i := 0; i < 2; i++ {
+			if reused := doReq(client); reused == nil || *reused {
+				t.Fatalf("expected request %d not to reuse connection, got %v", i, reused)
+			}
+		}
+	})
+
+	t.Run("not counted", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/anything", nil)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		result := must.Unmarshal[bodyResponse](t, w.Body)
+		if result.ConnectionReused != nil {
+			t.Fatalf("expected connection_reused to be omitted, got %v", *result.ConnectionReused)
+		}
+	})
+}
+
+func TestAnythingHexEncoding(t *testing.T) {
+	t.Parallel()
+
+	body := []byte{0x00, 0x01, 0xfe, 0xff, 'h', 'i'}
+	for _, contentType := range []string{"application/octet-stream", "text/plain"} {
+		contentType := contentType
+		t.Run(contentType, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequestWithBody(t, "POST", "/anything?encoding=hex", bytes.NewReader(body))
+			req.Header.Set("Content-Type", contentType)
+			resp := must.DoReq(t, client, req)
+			result := mustParseResponse[bodyResponse](t, resp)
+			assert.Equal(t, result.Data, hex.EncodeToString(body), "incorrect hex-encoded data")
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/anything?encoding=base32")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
+func TestAnythingEntropy(t *testing.T) {
+	t.Parallel()
+
+	randomBody := make([]byte, app.MaxBodySize)
+	_, err := crand.Read(randomBody)
+	assert.NilError(t, err)
+
+	testCases := map[string]struct {
+		body    []byte
+		wantMin float64
+		wantMax float64
+	}{
+		"empty":     {nil, 0, 0},
+		"all zeros": {make([]byte, app.MaxBodySize), 0, 0},
+		"two bytes": {bytes.Repeat([]byte("ab"), 100), 1, 1},
+		"random":    {randomBody, 7.5, 8},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequestWithBody(t, "POST", "/anything?entropy=true", bytes.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/octet-stream")
+			resp := must.DoReq(t, client, req)
+			result := mustParseResponse[bodyResponse](t, resp)
+			if result.Entropy == nil {
+				t.Fatalf("expected entropy in response")
+			}
+			if *result.Entropy < tc.wantMin || *result.Entropy > tc.wantMax {
+				t.Fatalf("expected entropy in range [%v, %v], got %v", tc.wantMin, tc.wantMax, *result.Entropy)
+			}
+		})
+	}
+
+	t.Run("omitted by default", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequestWithBody(t, "POST", "/anything", strings.NewReader("hello"))
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.Entropy, nil, "expected no entropy in response")
+	})
+
+	badTests := map[string]struct {
+		url  string
+		body []byte
+	}{
+		"invalid param":  {"/anything?entropy=maybe", nil},
+		"body too large": {"/anything?entropy=true", make([]byte, app.MaxBodySize+1)},
+	}
+	for name, tc := range badTests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequestWithBody(t, "POST", tc.url, bytes.NewReader(tc.body))
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, http.StatusBadRequest)
+		})
+	}
+}
+
+func TestAnythingAuthScheme(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]*authSchemeResponse{
+		"Basic dXNlcjpwYXNz":                         {Scheme: "Basic", CredentialPresent: true},
+		"Bearer abc.def.ghi":                         {Scheme: "Bearer", CredentialPresent: true},
+		`Digest username="user", realm="go-httpbin"`: {Scheme: "Digest", CredentialPresent: true},
+		"Bearer": {Scheme: "Bearer", CredentialPresent: false},
+		"":       nil,
+	}
+	for authorization, want := range testCases {
+		authorization, want := authorization, want
+		t.Run(fmt.Sprintf("authorization=%q", authorization), func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", "/anything")
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			resp := must.DoReq(t, client, req)
+			result := mustParseResponse[bodyResponse](t, resp)
+			assert.DeepEqual(t, result.AuthScheme, want, "incorrect auth_scheme")
+		})
+	}
+
+	for path, method := range map[string]string{"/post": "POST", "/put": "PUT", "/delay/0": "GET"} {
+		path, method := path, method
+		t.Run("omitted by "+path, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, method, path)
+			req.Header.Set("Authorization", "Bearer abc.def.ghi")
+			resp := must.DoReq(t, client, req)
+			assert.StatusCode(t, resp, http.StatusOK)
+			result := mustParseResponse[bodyResponse](t, resp)
+			if result.AuthScheme != nil {
+				t.Fatalf("expected auth_scheme to be omitted, got %#v", result.AuthScheme)
+			}
+		})
+	}
+}
+
+func TestAnythingCompressResponse(t *testing.T) {
+	t.Parallel()
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip":    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"deflate": func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+	}
+	for encoding, decode := range decoders {
+		encoding, decode := encoding, decode
+		t.Run(encoding, func(t *testing.T) {
+			t.Parallel()
+
+			req := newTestRequestWithBody(t, "POST", "/anything?compress_response="+encoding, strings.NewReader("hello"))
+			req.Header.Set("Content-Type", "text/plain")
+			// compression is forced even if the client does not accept it
+			req.Header.Set("Accept-Encoding", "identity")
+
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, http.StatusOK)
+			assert.ContentType(t, resp, jsonContentType)
+			assert.Header(t, resp, "Content-Encoding", encoding)
+
+			compressed := must.ReadAll(t, resp.Body)
+			assert.Header(t, resp, "Content-Length", strconv.Itoa(len(compressed)))
+
+			r, err := decode(strings.NewReader(compressed))
+			assert.NilError(t, err)
+			result := must.Unmarshal[bodyResponse](t, r)
+			assert.Equal(t, result.Data, "hello", "incorrect data")
+			assert.Equal(t, result.Args.Get("compress_response"), encoding, "incorrect args")
+		})
+	}
+
+	t.Run("with pretty=false", func(t *testing.T) {
+		t.Parallel()
+
+		req := newTestRequest(t, "GET", "/anything?compress_response=gzip&pretty=false")
+		req.Header.Set("Accept-Encoding", "identity")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusOK)
+		assert.Header(t, resp, "Content-Encoding", "gzip")
+
+		r, err := gzip.NewReader(resp.Body)
+		assert.NilError(t, err)
+		body := strings.TrimSuffix(must.ReadAll(t, r), "\n")
+		if strings.Contains(body, "\n") {
+			t.Fatalf("expected compact JSON, got %q", body)
+		}
+		result := must.Unmarshal[bodyResponse](t, strings.NewReader(body))
+		assert.Equal(t, result.Args.Get("pretty"), "false", "incorrect args")
+	})
+
+	t.Run("with har format", func(t *testing.T) {
+		t.Parallel()
+
+		req := newTestRequest(t, "GET", "/anything?compress_response=gzip&format=har")
+		req.Header.Set("Accept-Encoding", "identity")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusOK)
+		assert.Header(t, resp, "Content-Encoding", "gzip")
+
+		r, err := gzip.NewReader(resp.Body)
+		assert.NilError(t, err)
+		result := must.Unmarshal[harResponse](t, r)
+		assert.Equal(t, len(result.Log.Entries), 1, "expected a single HAR entry")
+	})
+
+	t.Run("invalid compress_response", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/anything?compress_response=br")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
+func TestAnythingTruncate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("over limit", func(t *testing.T) {
+		t.Parallel()
+		body := strings.Repeat("a", int(maxBodySize)) + strings.Repeat("b", 100)
+		req := newTestRequestWithBody(t, "POST", "/anything?truncate=true", strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.Data, body[:maxBodySize], "incorrect truncated data")
+		assert.Equal(t, result.Truncated, true, "expected truncated flag")
+	})
+
+	t.Run("over limit json", func(t *testing.T) {
+		t.Parallel()
+		body := `{"foo": "` + strings.Repeat("a", int(maxBodySize)) + `"}`
+		req := newTestRequestWithBody(t, "POST", "/anything?truncate=true", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.Data, body[:maxBodySize], "incorrect truncated data")
+		assert.Equal(t, result.JSON, nil, "expected no parsed json")
+		assert.Equal(t, result.Truncated, true, "expected truncated flag")
+	})
+
+	t.Run("at limit", func(t *testing.T) {
+		t.Parallel()
+		body := strings.Repeat("a", int(maxBodySize))
+		req := newTestRequestWithBody(t, "POST", "/anything?truncate=true", strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.Data, body, "incorrect data")
+		assert.Equal(t, result.Truncated, false, "expected no truncated flag")
+	})
+
+	t.Run("over per-request limit", func(t *testing.T) {
+		t.Parallel()
+		body := strings.Repeat("a", 10) + strings.Repeat("b", 10)
+		req := newTestRequestWithBody(t, "POST", "/anything?truncate=true", strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set(maxBodySizeHeader, "10")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.Data, body[:10], "incorrect truncated data")
+		assert.Equal(t, result.Truncated, true, "expected truncated flag")
+	})
+
+	t.Run("over limit without truncate", func(t *testing.T) {
+		t.Parallel()
+		body := strings.Repeat("a", int(maxBodySize)+100)
+		req := newTestRequestWithBody(t, "POST", "/anything", strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "POST", "/anything?truncate=foo")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
+func TestAnythingHeadersHash(t *testing.T) {
+	t.Parallel()
+
+	getHash := func(t *testing.T, headers map[string][]string) string {
+		t.Helper()
+		req := newTestRequest(t, "GET", "/anything?headers_hash=sha256")
+		for k, vals := range headers {
+			for _, v := range vals {
+				req.Header.Add(k, v)
+			}
+		}
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.HeadersHash, hashHeaders(result.Headers), "hash does not match reported headers")
+		return result.HeadersHash
+	}
+
+	t.Run("stable", func(t *testing.T) {
+		t.Parallel()
+		headers := map[string][]string{"X-Foo": {"a", "b"}, "X-Bar": {"c"}}
+		assert.Equal(t, getHash(t, headers), getHash(t, headers), "expected stable hash")
+	})
+
+	t.Run("changes when a header is added", func(t *testing.T) {
+		t.Parallel()
+		base := getHash(t, map[string][]string{"X-Foo": {"a"}})
+		added := getHash(t, map[string][]string{"X-Foo": {"a"}, "X-Bar": {"b"}})
+		if base == added {
+			t.Fatalf("expected hash to change when a header is added, got %s for both", base)
+		}
+	})
+
+	t.Run("not requested", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/anything")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.HeadersHash, "", "expected no headers_hash")
+	})
+
+	t.Run("invalid headers_hash", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/anything?headers_hash=md5")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
+func TestAnythingTiming(t *testing.T) {
+	t.Parallel()
+
+	t.Run("breakdown", func(t *testing.T) {
+		t.Parallel()
+
+		// send the body slowly, so that reading it takes measurable time
+		pr, pw := io.Pipe()
+		go func() {
+			pw.Write([]byte("hello, "))
+			time.Sleep(50 * time.Millisecond)
+			pw.Write([]byte("world"))
+			pw.Close()
+		}()
+
+		req := newTestRequestWithBody(t, "POST", "/anything?timing=true", pr)
+		req.Header.Set("Content-Type", "text/plain")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.Data, "hello, world", "incorrect data")
+
+		timing := result.Timing
+		if timing == nil {
+			t.Fatalf("expected timing in response")
+		}
+		if timing.BodyReadMS < 40 {
+			t.Fatalf("expected body_read_ms >= 40, got %v", timing.BodyReadMS)
+		}
+		if timing.ProcessingMS < 0 {
+			t.Fatalf("expected non-negative processing_ms, got %v", timing.ProcessingMS)
+		}
+		if timing.TotalMS < timing.BodyReadMS || timing.TotalMS < timing.ProcessingMS {
+			t.Fatalf("expected total_ms to cover body_read_ms and processing_ms, got %#v", timing)
+		}
+	})
+
+	t.Run("not requested", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "POST", "/anything")
+		resp := must.DoReq(t, client, req)
+		body := must.ReadAll(t, resp.Body)
+		if strings.Contains(body, `"timing"`) {
+			t.Fatalf("expected no timing in response: %s", body)
+		}
+	})
+
+	t.Run("invalid timing", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "POST", "/anything?timing=foo")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
+func TestIncludeConnectionInfo(t *testing.T) {
+	t.Parallel()
+
+	connApp := New(WithIncludeConnectionInfo(true))
+	tlsSrv := httptest.NewTLSServer(connApp)
+	t.Cleanup(tlsSrv.Close)
+	tlsClient := tlsSrv.Client()
+	tlsClient.Transport.(*http.Transport).TLSClientConfig.MaxVersion = tls.VersionTLS12
+	plainSrv, plainClient := newTestServer(connApp)
+	t.Cleanup(plainSrv.Close)
+
+	for _, path := range []string{"/get", "/anything"} {
+		path := path
+		t.Run("tls"+path, func(t *testing.T) {
+			t.Parallel()
+			req, err := http.NewRequest("GET", tlsSrv.URL+path, nil)
+			assert.NilError(t, err)
+			resp := must.DoReq(t, tlsClient, req)
+			result := mustParseResponse[bodyResponse](t, resp)
+			if result.Connection == nil {
+				t.Fatalf("expected connection info")
+			}
+			assert.Equal(t, result.Connection.Proto, "HTTP/1.1", "incorrect proto")
+			assert.Equal(t, result.Connection.ClientIP, "127.0.0.1", "incorrect client ip")
+			assert.Equal(t, result.Connection.TLSVersion, "TLS 1.2", "incorrect TLS version")
+			assert.Equal(t, result.Connection.TLSCipherSuite, tls.CipherSuiteName(resp.TLS.CipherSuite), "incorrect TLS cipher suite")
+		})
+
+		t.Run("plaintext"+path, func(t *testing.T) {
+			t.Parallel()
+			req, err := http.NewRequest("GET", plainSrv.URL+path, nil)
+			assert.NilError(t, err)
+			resp := must.DoReq(t, plainClient, req)
+			result := mustParseResponse[bodyResponse](t, resp)
+			if result.Connection == nil {
+				t.Fatalf("expected connection info")
+			}
+			assert.Equal(t, *result.Connection, connectionResponse{
+				Proto:    "HTTP/1.1",
+				ClientIP: "127.0.0.1",
+			}, "incorrect connection info")
+		})
+
+		t.Run("disabled"+path, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", path)
+			resp := must.DoReq(t, client, req)
+			result := mustParseResponse[bodyResponse](t, resp)
+			if result.Connection != nil {
+				t.Fatalf("expected no connection info by default, got %#v", result.Connection)
+			}
+		})
+	}
+}
+
+func TestTLSServerName(t *testing.T) {
+	t.Parallel()
+
+	// httptest's TLS certificate is valid for example.com, so we can send that
+	// as our SNI server name
+	tlsSrv := httptest.NewTLSServer(app)
+	t.Cleanup(tlsSrv.Close)
+	tlsClient := tlsSrv.Client()
+	tlsClient.Transport.(*http.Transport).TLSClientConfig.ServerName = "example.com"
+
+	for _, path := range []string{"/get", "/anything"} {
+		path := path
+		t.Run("tls"+path, func(t *testing.T) {
+			t.Parallel()
+			req, err := http.NewRequest("GET", tlsSrv.URL+path, nil)
+			assert.NilError(t, err)
+			resp := must.DoReq(t, tlsClient, req)
+			result := mustParseResponse[bodyResponse](t, resp)
+			assert.Equal(t, result.TLSServerName, "example.com", "incorrect TLS server name")
+		})
+
+		t.Run("plaintext"+path, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", path)
+			resp := must.DoReq(t, client, req)
+			body := must.ReadAll(t, resp.Body)
+			if strings.Contains(body, "tls_server_name") {
+				t.Fatalf("expected no tls_server_name for plaintext request: %s", body)
+			}
+		})
+	}
+}
+
+func TestClientCertChain(t *testing.T) {
+	t.Parallel()
+
+	// generate a chain of root CA -> intermediate CA -> client certificates
+	newCert := func(cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+		assert.NilError(t, err)
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(time.Now().UnixNano()),
+			Subject:               pkix.Name{CommonName: cn},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  parent == nil || cn != "client",
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		der, err := x509.CreateCertificate(crand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+		assert.NilError(t, err)
+		cert, err := x509.ParseCertificate(der)
+		assert.NilError(t, err)
+		return cert, key
+	}
+	root, rootKey := newCert("root", nil, nil)
+	intermediate, intermediateKey := newCert("intermediate", root, rootKey)
+	leaf, leafKey := newCert("client", intermediate, intermediateKey)
 
-	resp := must.DoReq(t, client, req)
-	result := mustParseResponse[headersResponse](t, resp)
+	tlsSrv := httptest.NewUnstartedServer(app)
+	tlsSrv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	tlsSrv.StartTLS()
+	t.Cleanup(tlsSrv.Close)
 
-	// Host header requires special treatment, because it's a field on the
-	// http.Request struct itself, not part of its headers map
-	host := result.Headers.Get("Host")
-	assert.Equal(t, req.Host, host, "missing or incorrect Host header")
+	t.Run("mtls", func(t *testing.T) {
+		t.Parallel()
+		// clone the server's client transport, which is shared by all of
+		// the subtests
+		transport := tlsSrv.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = []tls.Certificate{{
+			Certificate: [][]byte{leaf.Raw, intermediate.Raw},
+			PrivateKey:  leafKey,
+		}}
+		tlsClient := &http.Client{Transport: transport}
+		req, err := http.NewRequest("GET", tlsSrv.URL+"/anything", nil)
+		assert.NilError(t, err)
+		resp := must.DoReq(t, tlsClient, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.DeepEqual(t, *result.ClientCertChain, clientCertChainResponse{
+			Length: 2,
+			Certificates: []clientCertificate{
+				{SubjectCN: "client"},
+				{SubjectCN: "intermediate"},
+			},
+		}, "incorrect client cert chain")
+	})
 
-	for k, expectedValues := range req.Header {
-		values := result.Headers.Values(k)
-		assert.DeepEqual(t, expectedValues, values, "missing or incorrect header for key %q", k)
-	}
-}
+	t.Run("tls without client cert", func(t *testing.T) {
+		t.Parallel()
+		req, err := http.NewRequest("GET", tlsSrv.URL+"/anything", nil)
+		assert.NilError(t, err)
+		resp := must.DoReq(t, tlsSrv.Client(), req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.DeepEqual(t, *result.ClientCertChain, clientCertChainResponse{Certificates: []clientCertificate{}}, "expected empty client cert chain")
+	})
 
-func TestPost(t *testing.T) {
-	testRequestWithBody(t, "POST", "/post")
-}
+	t.Run("plaintext", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/anything")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.DeepEqual(t, *result.ClientCertChain, clientCertChainResponse{Certificates: []clientCertificate{}}, "expected empty client cert chain")
+	})
 
-func TestPut(t *testing.T) {
-	testRequestWithBody(t, "PUT", "/put")
+	t.Run("omitted by other endpoints", func(t *testing.T) {
+		t.Parallel()
+		req, err := http.NewRequest("POST", tlsSrv.URL+"/post", nil)
+		assert.NilError(t, err)
+		resp := must.DoReq(t, tlsSrv.Client(), req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		if result.ClientCertChain != nil {
+			t.Fatalf("expected client_cert_chain to be omitted, got %#v", result.ClientCertChain)
+		}
+	})
 }
 
-func TestDelete(t *testing.T) {
-	testRequestWithBody(t, "DELETE", "/delete")
-}
+func TestAnythingDecodeJWT(t *testing.T) {
+	encodeSegment := func(v string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(v))
+	}
+	validJWT := strings.Join([]string{
+		encodeSegment(`{"alg":"HS256","typ":"JWT"}`),
+		encodeSegment(`{"sub":"1234567890","name":"John Doe","admin":true}`),
+		"c2lnbmF0dXJl",
+	}, ".")
 
-func TestPatch(t *testing.T) {
-	testRequestWithBody(t, "PATCH", "/patch")
+	t.Run("valid", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "POST", "/anything?decode_jwt=true")
+		req.Header.Set("Authorization", "Bearer "+validJWT)
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.Equal(t, result.JWTError, "", "unexpected jwt_error")
+		assert.DeepEqual(t, result.JWT, &jwtResponse{
+			Header: map[string]interface{}{"alg": "HS256", "typ": "JWT"},
+			Claims: map[string]interface{}{"sub": "1234567890", "name": "John Doe", "admin": true},
+		}, "unexpected decoded JWT")
+	})
+
+	errorTests := map[string]struct {
+		authorization string
+		wantError     string
+	}{
+		"missing":             {"", "missing bearer token"},
+		"basic auth":          {"Basic dXNlcjpwYXNz", "missing bearer token"},
+		"wrong segment count": {"Bearer abc.def", "malformed JWT: expected 3 segments, got 2"},
+		"bad header encoding": {"Bearer !!!." + encodeSegment(`{}`) + ".sig", "malformed JWT header"},
+		"bad claims json":     {"Bearer " + encodeSegment(`{}`) + "." + encodeSegment(`not json`) + ".sig", "malformed JWT claims"},
+	}
+	for name, tc := range errorTests {
+		tc := tc
+		t.Run("malformed/"+name, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", "/anything?decode_jwt=1")
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			resp := must.DoReq(t, client, req)
+			result := mustParseResponse[bodyResponse](t, resp)
+			assert.Contains(t, result.JWTError, tc.wantError, "jwt_error")
+			if result.JWT != nil {
+				t.Fatalf("expected no decoded JWT, got %#v", result.JWT)
+			}
+		})
+	}
+
+	t.Run("not requested", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "POST", "/anything")
+		req.Header.Set("Authorization", "Bearer "+validJWT)
+		resp := must.DoReq(t, client, req)
+		body := must.ReadAll(t, resp.Body)
+		if strings.Contains(body, `"jwt`) {
+			t.Fatalf("expected no JWT fields in response: %s", body)
+		}
+	})
+
+	t.Run("invalid decode_jwt", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "POST", "/anything?decode_jwt=foo")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
 }
 
-func TestAnything(t *testing.T) {
-	var (
-		verbs = []string{
-			"GET",
-			"DELETE",
-			"PATCH",
-			"POST",
-			"PUT",
+func TestAnythingContentTypeParams(t *testing.T) {
+	t.Parallel()
+
+	t.Run("charset", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequestWithBody(t, "POST", "/anything", strings.NewReader("hello"))
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.DeepEqual(t, result.ContentTypeParams, map[string]string{"charset": "utf-8"}, "incorrect content type params")
+	})
+
+	t.Run("multipart boundary", func(t *testing.T) {
+		t.Parallel()
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		assert.NilError(t, mw.WriteField("foo", "bar"))
+		assert.NilError(t, mw.Close())
+
+		req := newTestRequestWithBody(t, "POST", "/anything", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.DeepEqual(t, result.ContentTypeParams, map[string]string{"boundary": mw.Boundary()}, "incorrect content type params")
+		assert.DeepEqual(t, result.Form, url.Values{"foo": {"bar"}}, "incorrect form data")
+	})
+
+	t.Run("no params", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequestWithBody(t, "POST", "/anything", strings.NewReader("hello"))
+		req.Header.Set("Content-Type", "text/plain")
+		resp := must.DoReq(t, client, req)
+		body := must.ReadAll(t, resp.Body)
+		if strings.Contains(body, "content_type_params") {
+			t.Fatalf("expected no content_type_params in response: %s", body)
 		}
-		paths = []string{
-			"/anything",
-			"/anything/else",
+	})
+}
+
+func TestRoute(t *testing.T) {
+	testCases := []struct {
+		method    string
+		path      string
+		wantRoute string
+	}{
+		{"GET", "/anything", "/anything"},
+		{"GET", "/anything/foo/bar", "/anything/"},
+		{"POST", "/anything/foo/bar", "/anything/"},
+		{"GET", "/get", "GET /get"},
+		{"POST", "/post", "POST /post"},
+		{"GET", "/delay/0", "/delay/{duration}"},
+	}
+	for _, env := range envs {
+		env := env
+		for _, tc := range testCases {
+			tc := tc
+			t.Run(tc.method+" "+env.prefix+tc.path, func(t *testing.T) {
+				t.Parallel()
+				req := newTestRequest(t, tc.method, env.prefix+tc.path, env)
+				resp := must.DoReq(t, env.client, req)
+				result := mustParseResponse[bodyResponse](t, resp)
+				assert.Equal(t, result.Route, tc.wantRoute, "unexpected route")
+			})
 		}
-	)
-	for _, path := range paths {
-		for _, verb := range verbs {
-			testRequestWithBody(t, verb, path)
+	}
+
+	t.Run("non-JSON endpoints", func(t *testing.T) {
+		t.Parallel()
+		for path, wantRoute := range map[string]string{
+			"/status/200":          "/status/{code}",
+			"/base64/encode/foo":   "/base64/{operation}/{data}",
+			"/links/10/2":          "/links/{numLinks}/{offset}",
+			"/this/does/not/exist": "",
+		} {
+			r := httptest.NewRequest("GET", path, nil)
+			assert.Equal(t, app.getRoute(r), wantRoute, "unexpected route for %s", path)
 		}
+	})
+}
+
+func TestStrictQuery(t *testing.T) {
+	testCases := []struct {
+		method     string
+		path       string
+		wantStatus int
+	}{
+		// malformed queries are rejected in strict mode
+		{"GET", "/get?strict_query=true&foo=%ZZ", http.StatusBadRequest},
+		{"GET", "/get?strict_query=1&foo=bar;baz", http.StatusBadRequest},
+		{"GET", "/anything?strict_query=true&foo=%ZZ", http.StatusBadRequest},
+		{"POST", "/anything/foo?strict_query=true&foo=%ZZ", http.StatusBadRequest},
+		{"HEAD", "/anything?strict_query=true&foo=%ZZ", http.StatusBadRequest},
+
+		// well-formed queries are accepted in strict mode
+		{"GET", "/get?strict_query=true&foo=bar", http.StatusOK},
+		{"POST", "/anything?strict_query=true&foo=bar", http.StatusOK},
+
+		// malformed queries are accepted in lenient mode
+		{"GET", "/get?foo=%ZZ", http.StatusOK},
+		{"GET", "/get?strict_query=false&foo=%ZZ", http.StatusOK},
+		{"POST", "/anything?foo=%ZZ", http.StatusOK},
+
+		// invalid strict_query value
+		{"GET", "/get?strict_query=foo", http.StatusBadRequest},
+		{"GET", "/anything?strict_query=foo", http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, tc.method, tc.path)
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, tc.wantStatus)
+		})
 	}
 
-	t.Run("HEAD", func(t *testing.T) {
+	t.Run("error detail", func(t *testing.T) {
 		t.Parallel()
-		req := newTestRequest(t, "HEAD", "/anything")
+		req := newTestRequest(t, "GET", "/get?strict_query=true&foo=%ZZ")
 		resp := must.DoReq(t, client, req)
-		assert.StatusCode(t, resp, http.StatusOK)
-		assert.BodyEquals(t, resp, "")
-		assert.Header(t, resp, "Content-Length", "") // responses to HEAD requests should not have a Content-Length header
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+		result := must.Unmarshal[errorRespnose](t, resp.Body)
+		assert.Contains(t, result.Detail, "invalid query string", "error detail")
+	})
+
+	t.Run("lenient mode drops malformed params", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/get?foo=%ZZ&bar=baz")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[noBodyResponse](t, resp)
+		assert.DeepEqual(t, result.Args, url.Values{"bar": {"baz"}}, "expected malformed param to be dropped")
 	})
 }
 
@@ -508,6 +2709,7 @@ func testRequestWithBody(t *testing.T, verb, path string) {
 	testFuncs := []testFunc{
 		testRequestWithBodyBinaryBody,
 		testRequestWithBodyBodyTooBig,
+		testRequestWithBodyCompressedBody,
 		testRequestWithBodyEmptyBody,
 		testRequestWithBodyExpect100Continue,
 		testRequestWithBodyFormEncodedBody,
@@ -516,11 +2718,13 @@ func testRequestWithBody(t *testing.T, verb, path string) {
 		testRequestWithBodyInvalidFormEncodedBody,
 		testRequestWithBodyInvalidJSON,
 		testRequestWithBodyInvalidMultiPartBody,
+		testRequestWithBodyInvalidUTF8,
 		testRequestWithBodyJSON,
 		testRequestWithBodyMultiPartBody,
 		testRequestWithBodyMultiPartBodyFiles,
 		testRequestWithBodyQueryParams,
 		testRequestWithBodyQueryParamsAndBody,
+		testRequestWithBodyRequireContentType,
 		testRequestWithBodyTransferEncoding,
 	}
 	for _, testFunc := range testFuncs {
@@ -567,6 +2771,34 @@ func testRequestWithBodyBinaryBody(t *testing.T, verb string, path string) {
 	}
 }
 
+func testRequestWithBodyInvalidUTF8(t *testing.T, verb string, path string) {
+	tests := []struct {
+		contentType     string
+		wantContentType string
+	}{
+		{"text/plain", "text/plain"},
+		{"text/html; charset=utf-8", "text/html"},
+		{"", "application/octet-stream"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run("content type/"+test.contentType, func(t *testing.T) {
+			t.Parallel()
+
+			requestBody := []byte("invalid \xff\xfe utf-8")
+			req := newTestRequestWithBody(t, verb, path, bytes.NewReader(requestBody))
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+
+			resp := must.DoReq(t, client, req)
+			result := mustParseResponse[bodyResponse](t, resp)
+			expected := "data:" + test.wantContentType + ";base64," + base64.URLEncoding.EncodeToString(requestBody)
+			assert.Equal(t, result.Data, expected, "expected binary encoded response data")
+		})
+	}
+}
+
 func testRequestWithBodyEmptyBody(t *testing.T, verb string, path string) {
 	tests := []struct {
 		contentType string
@@ -629,6 +2861,39 @@ func testRequestWithBodyHTML(t *testing.T, verb, path string) {
 	assert.BodyContains(t, resp, data)
 }
 
+func testRequestWithBodyRequireContentType(t *testing.T, verb, path string) {
+	testCases := []struct {
+		contentType string
+		required    string
+		wantStatus  int
+	}{
+		{"application/json", "application/json", http.StatusOK},
+		{"application/json; charset=utf-8", "application/json", http.StatusOK},
+		{"Application/JSON", "application/json", http.StatusOK},
+		{"text/plain", "application/json", http.StatusUnsupportedMediaType},
+		{"", "application/json", http.StatusUnsupportedMediaType},
+		{"application/json", "", http.StatusOK},
+		{"application/json", "not a media type", http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("%q/%q", tc.contentType, tc.required), func(t *testing.T) {
+			t.Parallel()
+			params := url.Values{}
+			if tc.required != "" {
+				params.Set("require_content_type", tc.required)
+			}
+			req := newTestRequestWithBody(t, verb, path+"?"+params.Encode(), strings.NewReader(`{"foo": "bar"}`))
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, tc.wantStatus)
+		})
+	}
+}
+
 func testRequestWithBodyExpect100Continue(t *testing.T, verb, path string) {
 	// The stdlib http client automagically handles 100 Continue responses
 	// by continuing the request until a "final" 200 OK response is
@@ -686,6 +2951,7 @@ func testRequestWithBodyExpect100Continue(t *testing.T, verb, path string) {
 
 			got := must.Unmarshal[bodyResponse](t, resp.Body)
 			assert.Equal(t, got.Data, string(body), "incorrect body")
+			assert.Equal(t, got.ExpectContinue, strings.HasPrefix(path, "/anything"), "expect_continue should only be reported by /anything")
 		}
 	})
 
@@ -856,6 +3122,13 @@ func testRequestWithBodyMultiPartBodyFiles(t *testing.T, verb, path string) {
 	// Add a file to the multipart request
 	part, _ := mw.CreateFormFile("fieldname", "filename")
 	part.Write([]byte("hello world"))
+
+	// And another with an explicit content type
+	partHeader := make(textproto.MIMEHeader)
+	partHeader.Set("Content-Disposition", `form-data; name="data"; filename="data.csv"`)
+	partHeader.Set("Content-Type", "text/csv")
+	part, _ = mw.CreatePart(partHeader)
+	part.Write([]byte("a,b\n1,2\n"))
 	mw.Close()
 
 	req := newTestRequestWithBody(t, verb, path, bytes.NewReader(body.Bytes()))
@@ -873,8 +3146,16 @@ func testRequestWithBodyMultiPartBodyFiles(t *testing.T, verb, path string) {
 	// response, with the field as key and content as value
 	wantFiles := url.Values{
 		"fieldname": {"hello world"},
+		"data":      {"a,b\n1,2\n"},
 	}
 	assert.DeepEqual(t, result.Files, wantFiles, "files mismatch")
+
+	// along with metadata describing each file, ordered by field name
+	wantMetadata := []fileMetadataResponse{
+		{Field: "data", Filename: "data.csv", Size: 8, ContentType: "text/csv"},
+		{Field: "fieldname", Filename: "filename", Size: 11, ContentType: "application/octet-stream"},
+	}
+	assert.DeepEqual(t, result.FilesMetadata, wantMetadata, "files metadata mismatch")
 }
 
 func testRequestWithBodyInvalidFormEncodedBody(t *testing.T, verb, path string) {
@@ -927,6 +3208,89 @@ func testRequestWithBodyJSON(t *testing.T, verb, path string) {
 	assert.DeepEqual(t, roundTrippedInput, input, "round-tripped JSON mismatch")
 }
 
+func testRequestWithBodyCompressedBody(t *testing.T, verb, path string) {
+	compressors := map[string]func(t *testing.T, data []byte) []byte{
+		"gzip": func(t *testing.T, data []byte) []byte {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			_, err := zw.Write(data)
+			assert.NilError(t, err)
+			assert.NilError(t, zw.Close())
+			return buf.Bytes()
+		},
+		"deflate": func(t *testing.T, data []byte) []byte {
+			var buf bytes.Buffer
+			zw := zlib.NewWriter(&buf)
+			_, err := zw.Write(data)
+			assert.NilError(t, err)
+			assert.NilError(t, zw.Close())
+			return buf.Bytes()
+		},
+	}
+	for encoding, compress := range compressors {
+		encoding, compress := encoding, compress
+
+		t.Run(encoding+"/json", func(t *testing.T) {
+			t.Parallel()
+			body := `{"foo": "bar", "baz": [1, 2, 3]}`
+			req := newTestRequestWithBody(t, verb, path, bytes.NewReader(compress(t, []byte(body))))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", encoding)
+
+			resp := must.DoReq(t, client, req)
+			result := mustParseResponse[bodyResponse](t, resp)
+			assert.Equal(t, result.Data, body, "response data mismatch")
+			assert.DeepEqual(t, result.JSON, any(map[string]any{
+				"foo": "bar",
+				"baz": []any{float64(1), float64(2), float64(3)},
+			}), "json mismatch")
+		})
+
+		t.Run(encoding+"/form", func(t *testing.T) {
+			t.Parallel()
+			body := "foo=foo&bar=bar1&bar=bar2"
+			req := newTestRequestWithBody(t, verb, path, bytes.NewReader(compress(t, []byte(body))))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Content-Encoding", encoding)
+
+			resp := must.DoReq(t, client, req)
+			result := mustParseResponse[bodyResponse](t, resp)
+			assert.DeepEqual(t, result.Form, url.Values{
+				"foo": {"foo"},
+				"bar": {"bar1", "bar2"},
+			}, "form values mismatch")
+		})
+
+		t.Run(encoding+"/decompression bomb", func(t *testing.T) {
+			t.Parallel()
+			// compresses to far fewer than MaxBodySize bytes, but decompresses
+			// to more
+			payload := compress(t, make([]byte, app.MaxBodySize*10))
+			if int64(len(payload)) >= app.MaxBodySize {
+				t.Fatalf("expected compressed payload smaller than max body size, got %d bytes", len(payload))
+			}
+			req := newTestRequestWithBody(t, verb, path, bytes.NewReader(payload))
+			req.Header.Set("Content-Type", "application/octet-stream")
+			req.Header.Set("Content-Encoding", encoding)
+
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, http.StatusBadRequest)
+		})
+
+		t.Run(encoding+"/invalid data", func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequestWithBody(t, verb, path, strings.NewReader("not compressed"))
+			req.Header.Set("Content-Type", "text/plain")
+			req.Header.Set("Content-Encoding", encoding)
+
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, http.StatusBadRequest)
+		})
+	}
+}
+
 func testRequestWithBodyInvalidJSON(t *testing.T, verb, path string) {
 	req := newTestRequestWithBody(t, verb, path, strings.NewReader("foo"))
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
@@ -984,12 +3348,13 @@ func testRequestWithBodyQueryParamsAndBody(t *testing.T, verb, path string) {
 
 func testRequestWithBodyTransferEncoding(t *testing.T, verb, path string) {
 	testCases := []struct {
-		given string
-		want  string
+		given     string
+		want      string
+		wantField []string
 	}{
-		{"", ""},
-		{"identity", ""},
-		{"chunked", "chunked"},
+		{"", "", []string{}},
+		{"identity", "", []string{}},
+		{"chunked", "chunked", []string{"chunked"}},
 	}
 	for _, tc := range testCases {
 		tc := tc
@@ -1007,6 +3372,7 @@ func testRequestWithBodyTransferEncoding(t *testing.T, verb, path string) {
 			result := mustParseResponse[bodyResponse](t, resp)
 			got := result.Headers.Get("Transfer-Encoding")
 			assert.Equal(t, got, tc.want, "Transfer-Encoding header mismatch")
+			assert.DeepEqual(t, result.TransferEncoding, tc.wantField, "transfer_encoding field mismatch")
 		})
 	}
 }
@@ -1126,28 +3492,138 @@ func TestStatus(t *testing.T) {
 			t.Parallel()
 			req, _ := http.NewRequest("GET", srv.URL+"/status/200:0.7,429:0.2,503:0.1", nil)
 			resp := must.DoReq(t, client, req)
-			defer consumeAndCloseBody(resp)
-			if resp.StatusCode != 200 && resp.StatusCode != 429 && resp.StatusCode != 503 {
-				t.Fatalf("expected status code 200, 429, or 503, got %d", resp.StatusCode)
-			}
-		})
+			defer consumeAndCloseBody(resp)
+			if resp.StatusCode != 200 && resp.StatusCode != 429 && resp.StatusCode != 503 {
+				t.Fatalf("expected status code 200, 429, or 503, got %d", resp.StatusCode)
+			}
+		})
+
+		t.Run("bad weight", func(t *testing.T) {
+			t.Parallel()
+			req, _ := http.NewRequest("GET", srv.URL+"/status/200:foo,500:1", nil)
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, http.StatusBadRequest)
+		})
+
+		t.Run("bad choice", func(t *testing.T) {
+			t.Parallel()
+			req, _ := http.NewRequest("GET", srv.URL+"/status/200:1,foo:1", nil)
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, http.StatusBadRequest)
+		})
+	})
+
+	t.Run("linger", func(t *testing.T) {
+		t.Parallel()
+
+		linger := 300 * time.Millisecond
+
+		// we need a raw connection to observe when the server closes it
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		assert.NilError(t, err)
+		defer conn.Close()
+
+		start := time.Now()
+		req := newTestRequest(t, "GET", "/status/418?linger=300ms")
+		reqBytes, err := httputil.DumpRequestOut(req, false)
+		assert.NilError(t, err)
+		_, err = conn.Write(reqBytes)
+		assert.NilError(t, err)
+
+		// the complete response arrives promptly
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, req)
+		assert.NilError(t, err)
+		assert.StatusCode(t, resp, http.StatusTeapot)
+		assert.BodyContains(t, resp, "I'm a teapot!")
+		if elapsed := time.Since(start); elapsed >= linger {
+			t.Fatalf("expected response before %s, got it after %s", linger, elapsed)
+		}
+
+		// but the connection is held open for the linger duration
+		_, err = br.ReadByte()
+		assert.Error(t, err, io.EOF)
+		assert.RoughlyEqual(t, time.Since(start), linger, 50*time.Millisecond)
+	})
+
+	t.Run("linger content length", func(t *testing.T) {
+		t.Parallel()
+		for code, want := range map[int]string{200: "0", 204: "", 418: strconv.Itoa(len("I'm a teapot!"))} {
+			req := newTestRequest(t, "GET", fmt.Sprintf("/status/%d?linger=10ms", code))
+			resp := must.DoReq(t, client, req)
+			consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, code)
+			assert.Header(t, resp, "Content-Length", want)
+		}
+	})
 
-		t.Run("bad weight", func(t *testing.T) {
+	for _, linger := range []string{"foo", "-1s", "2s"} {
+		linger := linger
+		t.Run("bad linger "+linger, func(t *testing.T) {
 			t.Parallel()
-			req, _ := http.NewRequest("GET", srv.URL+"/status/200:foo,500:1", nil)
+			req := newTestRequest(t, "GET", "/status/200?linger="+linger)
 			resp := must.DoReq(t, client, req)
 			defer consumeAndCloseBody(resp)
 			assert.StatusCode(t, resp, http.StatusBadRequest)
 		})
+	}
+}
 
-		t.Run("bad choice", func(t *testing.T) {
+func TestStatusSequence(t *testing.T) {
+	t.Parallel()
+
+	doStatus := func(t *testing.T, path string) int {
+		t.Helper()
+		req := newTestRequest(t, "GET", path)
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		return resp.StatusCode
+	}
+
+	t.Run("stops at end", func(t *testing.T) {
+		t.Parallel()
+		var got []int
+		for i := 0; i < 5; i++ {
+			got = append(got, doStatus(t, "/status/sequence/200,500,503?key=seq-stop"))
+		}
+		assert.DeepEqual(t, got, []int{200, 500, 503, 503, 503}, "incorrect status sequence")
+	})
+
+	t.Run("cycles", func(t *testing.T) {
+		t.Parallel()
+		var got []int
+		for i := 0; i < 5; i++ {
+			got = append(got, doStatus(t, "/status/sequence/201,418?key=seq-cycle&cycle=true"))
+		}
+		assert.DeepEqual(t, got, []int{201, 418, 201, 418, 201}, "incorrect status sequence")
+	})
+
+	t.Run("keys are independent", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, doStatus(t, "/status/sequence/200,500?key=seq-a"), 200, "incorrect status for key a")
+		assert.Equal(t, doStatus(t, "/status/sequence/200,500?key=seq-b"), 200, "incorrect status for key b")
+		assert.Equal(t, doStatus(t, "/status/sequence/200,500?key=seq-a"), 500, "incorrect status for key a")
+	})
+
+	errorTests := []string{
+		"/status/sequence/200,500",
+		"/status/sequence/200,foo?key=seq-err",
+		"/status/sequence/200,600?key=seq-err",
+		"/status/sequence/200,?key=seq-err",
+		"/status/sequence/200?key=seq-err&cycle=foo",
+	}
+	for _, path := range errorTests {
+		path := path
+		t.Run("error"+path, func(t *testing.T) {
 			t.Parallel()
-			req, _ := http.NewRequest("GET", srv.URL+"/status/200:1,foo:1", nil)
+			req := newTestRequest(t, "GET", path)
 			resp := must.DoReq(t, client, req)
 			defer consumeAndCloseBody(resp)
 			assert.StatusCode(t, resp, http.StatusBadRequest)
 		})
-	})
+	}
 }
 
 func TestUnstable(t *testing.T) {
@@ -1196,7 +3672,85 @@ func TestUnstable(t *testing.T) {
 		})
 	}
 
+	t.Run("body", func(t *testing.T) {
+		t.Parallel()
+
+		// rand.NewSource(1234567890).Float64() => 0.08
+		bodyTests := []struct {
+			url    string
+			status int
+			rate   float64
+		}{
+			{"/unstable?seed=1234567890&body=true", 500, 0.5},
+			{"/unstable?seed=1234567890&failure_rate=0.07&body=true", 200, 0.07},
+		}
+		var rolls []float64
+		for _, test := range bodyTests {
+			req := newTestRequest(t, "GET", test.url)
+			resp := must.DoReq(t, client, req)
+			assert.StatusCode(t, resp, test.status)
+			assert.ContentType(t, resp, jsonContentType)
+			result := must.Unmarshal[unstableResponse](t, resp.Body)
+			consumeAndCloseBody(resp)
+			assert.Equal(t, result.Status, test.status, "incorrect status")
+			assert.Equal(t, result.Failed, test.status != 200, "incorrect failed")
+			assert.Equal(t, result.FailureRate, test.rate, "incorrect failure_rate")
+			if result.Roll < 0.08 || result.Roll >= 0.09 {
+				t.Fatalf("expected roll ~0.08 for seed, got %v", result.Roll)
+			}
+			rolls = append(rolls, result.Roll)
+		}
+		assert.Equal(t, rolls[0], rolls[1], "expected same roll for same seed")
+	})
+
+	t.Run("max_delay", func(t *testing.T) {
+		t.Parallel()
+
+		const maxDelay = 200 * time.Millisecond
+		doDelayed := func(t *testing.T, url string) (*http.Response, time.Duration) {
+			t.Helper()
+			start := time.Now()
+			req := newTestRequest(t, "GET", url)
+			resp := must.DoReq(t, client, req)
+			consumeAndCloseBody(resp)
+			return resp, time.Since(start)
+		}
+
+		url := fmt.Sprintf("/unstable?seed=1234567890&max_delay=%s", maxDelay)
+		var delays []time.Duration
+		for i := 0; i < 2; i++ {
+			resp, elapsed := doDelayed(t, url)
+
+			// adding a delay does not change the seeded outcome
+			assert.StatusCode(t, resp, 500)
+
+			delay := decodeServerTimings(resp.Header.Get("Server-Timing"))["initial_delay"].dur
+			if delay < 0 || delay > maxDelay {
+				t.Fatalf("expected delay in range [0, %s], got %s", maxDelay, delay)
+			}
+			if elapsed < delay {
+				t.Fatalf("expected request to take at least %s, took %s", delay, elapsed)
+			}
+			delays = append(delays, delay)
+		}
+		assert.Equal(t, delays[0], delays[1], "expected same delay for same seed")
+
+		// no delay without max_delay
+		resp, _ := doDelayed(t, "/unstable?seed=1234567890")
+		assert.Header(t, resp, "Server-Timing", "")
+	})
+
+	t.Run("empty body by default", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/unstable?seed=1234567890")
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, 500)
+		assert.BodyEquals(t, resp, "")
+	})
+
 	badTests := []string{
+		// bad body
+		"/unstable?body=foo",
 		// bad failure_rate
 		"/unstable?failure_rate=foo",
 		"/unstable?failure_rate=-1",
@@ -1204,6 +3758,10 @@ func TestUnstable(t *testing.T) {
 		// bad seed
 		"/unstable?seed=3.14",
 		"/unstable?seed=foo",
+		// bad max_delay
+		"/unstable?max_delay=foo",
+		"/unstable?max_delay=-1s",
+		"/unstable?max_delay=1h",
 	}
 	for _, test := range badTests {
 		test := test
@@ -1283,6 +3841,10 @@ func TestRedirects(t *testing.T) {
 		{"%s/absolute-redirect/1", "http://host/get%.s"},
 		{"%s/absolute-redirect/2", "http://host/absolute-redirect/1%.s"},
 		{"%s/absolute-redirect/100", "http://host/absolute-redirect/99%.s"},
+
+		{"%s/redirect/1?delay_per_hop=0", "%s/get"},
+		{"%s/redirect/2?delay_per_hop=0", "%s/relative-redirect/1?delay_per_hop=0"},
+		{"%s/absolute-redirect/2?delay_per_hop=0", "http://host/absolute-redirect/1?delay_per_hop=0%.s"},
 	}
 
 	for _, env := range envs {
@@ -1328,6 +3890,11 @@ func TestRedirects(t *testing.T) {
 		{"%s/absolute-redirect/3.14", http.StatusBadRequest},
 		{"%s/absolute-redirect/foo", http.StatusBadRequest},
 		{"%s/absolute-redirect/10/foo", http.StatusNotFound},
+
+		{"%s/redirect/2?delay_per_hop=foo", http.StatusBadRequest},
+		{"%s/redirect/2?delay_per_hop=-1s", http.StatusBadRequest},
+		{"%s/redirect/2?delay_per_hop=600ms", http.StatusBadRequest},
+		{"%s/relative-redirect/11?delay_per_hop=100ms", http.StatusBadRequest},
 	}
 
 	for _, env := range envs {
@@ -1346,6 +3913,26 @@ func TestRedirects(t *testing.T) {
 	}
 }
 
+func TestRedirectsDelayPerHop(t *testing.T) {
+	t.Parallel()
+
+	// follow the whole redirect chain with a client that allows redirects
+	delay := 100 * time.Millisecond
+	followClient := &http.Client{Timeout: 5 * time.Second}
+
+	req := newTestRequest(t, "GET", fmt.Sprintf("/redirect/2?delay_per_hop=%s", delay))
+	start := time.Now()
+	resp := must.DoReq(t, followClient, req)
+	elapsed := time.Since(start)
+	defer consumeAndCloseBody(resp)
+
+	assert.StatusCode(t, resp, http.StatusOK)
+	assert.Equal(t, resp.Request.URL.Path, "/get", "incorrect final URL")
+	if elapsed < 2*delay {
+		t.Fatalf("expected redirect chain to take at least %s, took %s", 2*delay, elapsed)
+	}
+}
+
 func TestRedirectTo(t *testing.T) {
 	okTests := []struct {
 		url              string
@@ -1373,10 +3960,34 @@ func TestRedirectTo(t *testing.T) {
 		})
 	}
 
+	t.Run("with_headers", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "POST", "/redirect-to?url=/get&with_headers=true")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusFound)
+		assert.Header(t, resp, "Location", "/get")
+		assert.Header(t, resp, "X-Original-Method", "POST")
+		assert.Header(t, resp, "X-Original-URL", req.URL.String())
+	})
+
+	t.Run("without_headers", func(t *testing.T) {
+		t.Parallel()
+		for _, url := range []string{"/redirect-to?url=/get", "/redirect-to?url=/get&with_headers=false"} {
+			req := newTestRequest(t, "GET", url)
+			resp := must.DoReq(t, client, req)
+			consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, http.StatusFound)
+			assert.Header(t, resp, "X-Original-Method", "")
+			assert.Header(t, resp, "X-Original-URL", "")
+		}
+	})
+
 	badTests := []struct {
 		url            string
 		expectedStatus int
 	}{
+		{"/redirect-to?url=foo&with_headers=foo", http.StatusBadRequest},                      // invalid with_headers
 		{"/redirect-to", http.StatusBadRequest},                                               // missing url
 		{"/redirect-to?status_code=302", http.StatusBadRequest},                               // missing url
 		{"/redirect-to?url=foo&status_code=201", http.StatusBadRequest},                       // invalid status code
@@ -1417,7 +4028,7 @@ func TestRedirectTo(t *testing.T) {
 			defer consumeAndCloseBody(resp)
 			assert.StatusCode(t, resp, test.expectedStatus)
 			if test.expectedStatus >= 400 {
-				assert.BodyEquals(t, resp, app.forbiddenRedirectError)
+				assert.BodyEquals(t, resp, app.limits.Load().forbiddenRedirectError)
 			}
 		})
 	}
@@ -1531,6 +4142,52 @@ func TestCookies(t *testing.T) {
 	}
 }
 
+func TestRawCookies(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		header string
+		want   rawCookiesResponse
+	}{
+		"no cookies": {
+			want: rawCookiesResponse{Cookies: []rawCookie{}},
+		},
+		"modern cookies": {
+			header: "k1=v1; k2=v2",
+			want: rawCookiesResponse{Cookies: []rawCookie{
+				{Name: "k1", Value: "v1"},
+				{Name: "k2", Value: "v2"},
+			}},
+		},
+		"legacy cookies": {
+			header: `$Version="1"; Customer="WILE_E_COYOTE"; $Path="/acme"; Part_Number="Rocket_Launcher_0001"; $Path="/acme"; $Domain=".example.com"`,
+			want: rawCookiesResponse{
+				Version: "1",
+				Cookies: []rawCookie{
+					{Name: "Customer", Value: "WILE_E_COYOTE", Attributes: map[string]string{"Path": "/acme"}},
+					{Name: "Part_Number", Value: "Rocket_Launcher_0001", Attributes: map[string]string{"Path": "/acme", "Domain": ".example.com"}},
+				},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := newTestRequest(t, "GET", "/cookies/raw")
+			if tc.header != "" {
+				req.Header.Set("Cookie", tc.header)
+			}
+			resp := must.DoReq(t, client, req)
+			assert.StatusCode(t, resp, http.StatusOK)
+
+			result := mustParseResponse[rawCookiesResponse](t, resp)
+			assert.DeepEqual(t, result, tc.want, "incorrect cookies")
+		})
+	}
+}
+
 func TestBasicAuth(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		for _, method := range []string{"GET", "POST", "PUT", "DELETE", "PATCH"} {
@@ -1595,6 +4252,48 @@ func TestBasicAuth(t *testing.T) {
 		}
 	})
 
+	t.Run("custom realm", func(t *testing.T) {
+		realmTests := []struct {
+			realm         string
+			wantChallenge string
+		}{
+			{"", `Basic realm="Fake Realm"`},
+			{"My Realm", `Basic realm="My Realm"`},
+			{`Say "hi"`, `Basic realm="Say \"hi\""`},
+			{`back\slash`, `Basic realm="back\\slash"`},
+		}
+		for _, test := range realmTests {
+			test := test
+			t.Run(test.realm, func(t *testing.T) {
+				t.Parallel()
+				req := newTestRequest(t, "GET", "/basic-auth/user/pass?realm="+url.QueryEscape(test.realm))
+				resp := must.DoReq(t, client, req)
+				defer consumeAndCloseBody(resp)
+				assert.StatusCode(t, resp, http.StatusUnauthorized)
+				assert.Header(t, resp, "WWW-Authenticate", test.wantChallenge)
+			})
+		}
+
+		t.Run("authorized", func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", "/basic-auth/user/pass?realm=My+Realm")
+			req.SetBasicAuth("user", "pass")
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, http.StatusOK)
+			assert.Header(t, resp, "WWW-Authenticate", "")
+		})
+
+		t.Run("control characters rejected", func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", "/basic-auth/user/pass?realm="+url.QueryEscape("bad\r\nX-Injected: 1"))
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, http.StatusBadRequest)
+			assert.Header(t, resp, "X-Injected", "")
+		})
+	})
+
 	errorTests := []struct {
 		url    string
 		status int
@@ -1763,6 +4462,70 @@ func TestGzip(t *testing.T) {
 	}
 }
 
+func TestBrotli(t *testing.T) {
+	t.Parallel()
+
+	req := newTestRequest(t, "GET", "/brotli")
+	req.Header.Set("Accept-Encoding", "none") // disable automagic gzip decompression in default http client
+
+	resp := must.DoReq(t, client, req)
+	assert.Header(t, resp, "Content-Encoding", "br")
+	assert.ContentType(t, resp, jsonContentType)
+	assert.StatusCode(t, resp, http.StatusOK)
+
+	compressedContentLengthStr := resp.Header.Get("Content-Length")
+	if compressedContentLengthStr == "" {
+		t.Fatalf("missing Content-Length header in response")
+	}
+
+	compressedContentLength, err := strconv.Atoi(compressedContentLengthStr)
+	assert.NilError(t, err)
+
+	body, err := io.ReadAll(brotli.NewReader(resp.Body))
+	assert.NilError(t, err)
+
+	result := must.Unmarshal[noBodyResponse](t, bytes.NewBuffer(body))
+	assert.Equal(t, result.Brotli, true, "expected resp.Brotli == true")
+
+	if len(body) <= compressedContentLength {
+		t.Fatalf("expected compressed body")
+	}
+}
+
+func TestZstd(t *testing.T) {
+	t.Parallel()
+
+	req := newTestRequest(t, "GET", "/zstd")
+	req.Header.Set("Accept-Encoding", "none") // disable automagic gzip decompression in default http client
+
+	resp := must.DoReq(t, client, req)
+	assert.Header(t, resp, "Content-Encoding", "zstd")
+	assert.ContentType(t, resp, jsonContentType)
+	assert.StatusCode(t, resp, http.StatusOK)
+
+	compressedContentLengthStr := resp.Header.Get("Content-Length")
+	if compressedContentLengthStr == "" {
+		t.Fatalf("missing Content-Length header in response")
+	}
+
+	compressedContentLength, err := strconv.Atoi(compressedContentLengthStr)
+	assert.NilError(t, err)
+
+	zr, err := zstd.NewReader(resp.Body)
+	assert.NilError(t, err)
+	defer zr.Close()
+
+	body, err := io.ReadAll(zr)
+	assert.NilError(t, err)
+
+	result := must.Unmarshal[noBodyResponse](t, bytes.NewBuffer(body))
+	assert.Equal(t, result.Zstd, true, "expected resp.Zstd == true")
+
+	if len(body) <= compressedContentLength {
+		t.Fatalf("expected compressed body")
+	}
+}
+
 func TestDeflate(t *testing.T) {
 	t.Parallel()
 
@@ -1795,6 +4558,88 @@ func TestDeflate(t *testing.T) {
 	}
 }
 
+func TestCompressionLevel(t *testing.T) {
+	// a moderately compressible query param to ensure that the choice of
+	// compression level has an observable impact on response size
+	var sb strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&sb, "%d-%d,", i, i*i%97)
+	}
+	payload := url.QueryEscape(sb.String())
+
+	doCompressedRequest := func(t *testing.T, client *http.Client, url string) int {
+		t.Helper()
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NilError(t, err)
+		req.Header.Set("Accept-Encoding", "none") // disable automagic gzip decompression in default http client
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusOK)
+		size, err := strconv.Atoi(resp.Header.Get("Content-Length"))
+		assert.NilError(t, err)
+		return size
+	}
+
+	for _, path := range []string{"/gzip", "/deflate"} {
+		path := path
+		t.Run("level 1 vs 9"+path, func(t *testing.T) {
+			t.Parallel()
+			fastSize := doCompressedRequest(t, client, srv.URL+path+"?level=1&payload="+payload)
+			bestSize := doCompressedRequest(t, client, srv.URL+path+"?level=9&payload="+payload)
+			if fastSize <= bestSize {
+				t.Fatalf("expected level 1 size %d > level 9 size %d", fastSize, bestSize)
+			}
+		})
+
+		for _, level := range []string{"0", "10", "-2", "foo", "1.5"} {
+			level := level
+			t.Run("bad level"+path+"/"+level, func(t *testing.T) {
+				t.Parallel()
+				req := newTestRequest(t, "GET", path+"?level="+level)
+				resp := must.DoReq(t, client, req)
+				defer consumeAndCloseBody(resp)
+				assert.StatusCode(t, resp, http.StatusBadRequest)
+			})
+		}
+	}
+
+	t.Run("server default level", func(t *testing.T) {
+		t.Parallel()
+
+		fastSrv, fastClient := newTestServer(New(WithDefaultCompressionLevel(1)))
+		defer fastSrv.Close()
+		bestSrv, bestClient := newTestServer(New(WithDefaultCompressionLevel(9)))
+		defer bestSrv.Close()
+
+		fastSize := doCompressedRequest(t, fastClient, fastSrv.URL+"/gzip?payload="+payload)
+		bestSize := doCompressedRequest(t, bestClient, bestSrv.URL+"/gzip?payload="+payload)
+		if fastSize <= bestSize {
+			t.Fatalf("expected level 1 size %d > level 9 size %d", fastSize, bestSize)
+		}
+
+		// explicit level overrides server default
+		overrideSize := doCompressedRequest(t, fastClient, fastSrv.URL+"/gzip?level=9&payload="+payload)
+		if overrideSize >= fastSize {
+			t.Fatalf("expected ?level=9 size %d < server default size %d", overrideSize, fastSize)
+		}
+	})
+
+	for _, level := range []int{-2, 0, 10} {
+		level := level
+		t.Run(fmt.Sprintf("invalid server default level/%d", level), func(t *testing.T) {
+			t.Parallel()
+			defer func() {
+				r := recover()
+				if r == nil {
+					t.Fatalf("expected panic for invalid default compression level %d", level)
+				}
+				assert.Contains(t, fmt.Sprint(r), "httpbin: invalid default compression level", "incorrect panic message")
+			}()
+			New(WithDefaultCompressionLevel(level))
+		})
+	}
+}
+
 func TestStream(t *testing.T) {
 	t.Parallel()
 
@@ -1840,6 +4685,10 @@ func TestStream(t *testing.T) {
 		{"/stream/foo", http.StatusBadRequest},
 		{"/stream/3.1415", http.StatusBadRequest},
 		{"/stream/10/foo", http.StatusNotFound},
+		{"/stream/10?drop_rate=foo", http.StatusBadRequest},
+		{"/stream/10?drop_rate=-0.1", http.StatusBadRequest},
+		{"/stream/10?drop_rate=1.5", http.StatusBadRequest},
+		{"/stream/10?seed=foo", http.StatusBadRequest},
 	}
 
 	for _, test := range badTests {
@@ -1854,6 +4703,88 @@ func TestStream(t *testing.T) {
 	}
 }
 
+func TestStreamArray(t *testing.T) {
+	t.Parallel()
+
+	for _, url := range []string{"/stream/5?format=array", "/stream/1?format=array", "/stream/50?format=array&drop_rate=1"} {
+		url := url
+		t.Run(url, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", url)
+			resp := must.DoReq(t, client, req)
+			assert.StatusCode(t, resp, http.StatusOK)
+			assert.ContentType(t, resp, jsonContentType)
+			assert.Header(t, resp, "Content-Length", "")
+
+			results := must.Unmarshal[[]streamResponse](t, resp.Body)
+			for i, sr := range results {
+				if i > 0 && sr.ID <= results[i-1].ID {
+					t.Fatalf("expected increasing ids, got %d after %d", sr.ID, results[i-1].ID)
+				}
+			}
+		})
+	}
+
+	t.Run("all items", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/stream/5?format=array")
+		resp := must.DoReq(t, client, req)
+		results := must.Unmarshal[[]streamResponse](t, resp.Body)
+		assert.Equal(t, len(results), 5, "incorrect number of items")
+		for i, sr := range results {
+			assert.Equal(t, sr.ID, i, "bad id")
+		}
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/stream/5?format=xml")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
+func TestStreamDropRate(t *testing.T) {
+	t.Parallel()
+
+	readIDs := func(t *testing.T, url string) []int {
+		t.Helper()
+		req := newTestRequest(t, "GET", url)
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusOK)
+
+		ids := []int{}
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			sr := must.Unmarshal[streamResponse](t, bytes.NewReader(scanner.Bytes()))
+			ids = append(ids, sr.ID)
+		}
+		assert.NilError(t, scanner.Err())
+		return ids
+	}
+
+	t.Run("drops roughly expected fraction", func(t *testing.T) {
+		t.Parallel()
+		ids := readIDs(t, "/stream/100?drop_rate=0.5&seed=1234")
+		if len(ids) < 30 || len(ids) > 70 {
+			t.Fatalf("expected roughly 50 of 100 lines, got %d", len(ids))
+		}
+		assert.DeepEqual(t, readIDs(t, "/stream/100?drop_rate=0.5&seed=1234"), ids, "expected same lines dropped for same seed")
+	})
+
+	t.Run("zero drop rate keeps all lines", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, len(readIDs(t, "/stream/50?drop_rate=0&seed=1234")), 50, "incorrect line count")
+	})
+
+	t.Run("full drop rate drops all lines", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, len(readIDs(t, "/stream/50?drop_rate=1")), 0, "incorrect line count")
+	})
+}
+
 func TestTrailers(t *testing.T) {
 	t.Parallel()
 
@@ -1934,12 +4865,17 @@ func TestDelay(t *testing.T) {
 			elapsed := time.Since(start)
 
 			defer consumeAndCloseBody(resp)
-			_ = mustParseResponse[bodyResponse](t, resp)
+			result := mustParseResponse[bodyResponse](t, resp)
 
 			if elapsed < test.expectedDelay {
 				t.Fatalf("expected delay of %s, got %s", test.expectedDelay, elapsed)
 			}
 
+			actualDelay := time.Duration(result.ActualDelayMS * float64(time.Millisecond))
+			if actualDelay < test.expectedDelay || actualDelay > elapsed {
+				t.Fatalf("expected actual_delay_ms in range [%s, %s], got %s", test.expectedDelay, elapsed, actualDelay)
+			}
+
 			timings := decodeServerTimings(resp.Header.Get("Server-Timing"))
 			assert.DeepEqual(t, timings, map[string]serverTiming{
 				"initial_delay": {"initial_delay", test.expectedDelay, "initial delay"},
@@ -1974,6 +4910,38 @@ func TestDelay(t *testing.T) {
 		assert.Equal(t, w.Code, 499, "incorrect status code")
 	})
 
+	t.Run("heartbeat", func(t *testing.T) {
+		t.Parallel()
+
+		delay := 500 * time.Millisecond
+		start := time.Now()
+		req := newTestRequest(t, "GET", "/delay/500ms?heartbeat=true&heartbeat_interval=100ms")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusOK)
+		assert.ContentType(t, resp, jsonContentType)
+
+		// heartbeat bytes arrive well before the delay has elapsed
+		br := bufio.NewReader(resp.Body)
+		b, err := br.ReadByte()
+		assert.NilError(t, err)
+		assert.Equal(t, b, byte('\n'), "incorrect heartbeat byte")
+		if elapsed := time.Since(start); elapsed >= delay {
+			t.Fatalf("expected heartbeat before %s, got first byte after %s", delay, elapsed)
+		}
+
+		// followed by the final body, which is still valid JSON
+		var result bodyResponse
+		assert.NilError(t, json.NewDecoder(br).Decode(&result))
+		if elapsed := time.Since(start); elapsed < delay {
+			t.Fatalf("expected final body after %s, got it after %s", delay, elapsed)
+		}
+		actualDelay := time.Duration(result.ActualDelayMS * float64(time.Millisecond))
+		if actualDelay < delay {
+			t.Fatalf("expected actual_delay_ms of at least %s, got %s", delay, actualDelay)
+		}
+	})
+
 	badTests := []struct {
 		url  string
 		code int
@@ -1987,6 +4955,10 @@ func TestDelay(t *testing.T) {
 		{"/delay/1.5", http.StatusBadRequest},
 		{"/delay/-1", http.StatusBadRequest},
 		{"/delay/-3.14", http.StatusBadRequest},
+
+		{"/delay/0?heartbeat=foo", http.StatusBadRequest},
+		{"/delay/0?heartbeat=true&heartbeat_interval=0", http.StatusBadRequest},
+		{"/delay/0?heartbeat=true&heartbeat_interval=2s", http.StatusBadRequest},
 	}
 
 	for _, test := range badTests {
@@ -2001,6 +4973,88 @@ func TestDelay(t *testing.T) {
 	}
 }
 
+func TestEndpointTimeouts(t *testing.T) {
+	t.Parallel()
+
+	timeoutSrv, timeoutClient := newTestServer(New(
+		WithMaxDuration(time.Second),
+		WithEndpointTimeouts(map[string]time.Duration{
+			"/delay":          100 * time.Millisecond,
+			"/drip":           2 * time.Second,
+			"/websocket/echo": 50 * time.Millisecond,
+		}),
+	))
+	t.Cleanup(timeoutSrv.Close)
+
+	doGet := func(t *testing.T, path string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("GET", timeoutSrv.URL+path, nil)
+		return must.DoReq(t, timeoutClient, req)
+	}
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		// tighter override
+		{"/delay/50ms", http.StatusOK},
+		{"/delay/0.5", http.StatusBadRequest},
+
+		// looser override
+		{"/drip?duration=1500ms&delay=0&numbytes=1", http.StatusOK},
+
+		// global MaxDuration applies to other endpoints
+		{"/sse?duration=500ms&count=1", http.StatusOK},
+		{"/sse?duration=1500ms&count=1", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.path, func(t *testing.T) {
+			t.Parallel()
+			resp := doGet(t, test.path)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, test.wantStatus)
+		})
+	}
+
+	t.Run("deadline is enforced", func(t *testing.T) {
+		t.Parallel()
+
+		req, _ := http.NewRequest("GET", timeoutSrv.URL+"/websocket/echo", nil)
+		req.Header.Set("Connection", "upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+
+		start := time.Now()
+		resp := must.DoReq(t, timeoutClient, req)
+		defer resp.Body.Close()
+		assert.StatusCode(t, resp, http.StatusSwitchingProtocols)
+
+		// the server closes the idle connection once the endpoint timeout
+		// elapses, well before the global MaxDuration
+		_, err := io.ReadAll(resp.Body)
+		assert.NilError(t, err)
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("expected connection to close after endpoint timeout, took %s", elapsed)
+		}
+	})
+
+	t.Run("deadline exceeded causes 503", func(t *testing.T) {
+		t.Parallel()
+
+		// the endpoint deadline is the cause of cancelation, rather than
+		// the client going away
+		ctx, cancel := context.WithTimeoutCause(context.Background(), 0, errEndpointTimeout)
+		defer cancel()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequestWithContext(ctx, "GET", "/delay/1s", nil)
+		app.ServeHTTP(w, req)
+		assert.Equal(t, w.Code, http.StatusServiceUnavailable, "incorrect status code")
+	})
+}
+
 func TestDrip(t *testing.T) {
 	t.Parallel()
 
@@ -2076,6 +5130,9 @@ func TestDrip(t *testing.T) {
 		// So, here we instead manally write the request to the wire and read
 		// the initial response, which will give us access to the 100 Continue
 		// indication we need.
+		//
+		// Note that this relies on the default numbytes, since explicitly
+		// asking for body bytes with a 1xx status code is rejected.
 		t.Parallel()
 
 		req := newTestRequest(t, "GET", "/drip?code=100")
@@ -2217,6 +5274,12 @@ func TestDrip(t *testing.T) {
 		{&url.Values{"code": {"25"}}, http.StatusBadRequest},
 		{&url.Values{"code": {"600"}}, http.StatusBadRequest},
 
+		// status codes that cannot carry the requested body
+		{&url.Values{"code": {"100"}, "numbytes": {"1"}}, http.StatusBadRequest},
+		{&url.Values{"code": {"103"}, "numbytes": {"10"}}, http.StatusBadRequest},
+		{&url.Values{"code": {"204"}, "numbytes": {"1"}}, http.StatusBadRequest},
+		{&url.Values{"code": {"304"}, "numbytes": {"1"}}, http.StatusBadRequest},
+
 		// request would take too long
 		{&url.Values{"duration": {"750ms"}, "delay": {"500ms"}}, http.StatusBadRequest},
 	}
@@ -2414,6 +5477,104 @@ func TestRange(t *testing.T) {
 	}
 }
 
+func TestRangeEchoRange(t *testing.T) {
+	t.Parallel()
+
+	// byteRange builds an expected satisfiable range
+	byteRange := func(spec string, suffix bool, start, end int64) byteRangeResponse {
+		return byteRangeResponse{Spec: spec, Suffix: suffix, Satisfiable: true, Start: &start, End: &end}
+	}
+
+	testCases := []struct {
+		rangeHeader string
+		want        rangeResponse
+	}{
+		{
+			rangeHeader: "",
+			want:        rangeResponse{Size: 100, Satisfiable: true, Ranges: []byteRangeResponse{}},
+		},
+		{
+			rangeHeader: "bytes=10-24",
+			want: rangeResponse{Size: 100, Satisfiable: true, Ranges: []byteRangeResponse{
+				byteRange("10-24", false, 10, 24),
+			}},
+		},
+		{
+			rangeHeader: "bytes=90-",
+			want: rangeResponse{Size: 100, Satisfiable: true, Ranges: []byteRangeResponse{
+				byteRange("90-", false, 90, 99),
+			}},
+		},
+		{
+			rangeHeader: "bytes=-5",
+			want: rangeResponse{Size: 100, Satisfiable: true, Ranges: []byteRangeResponse{
+				byteRange("-5", true, 95, 99),
+			}},
+		},
+		{
+			rangeHeader: "bytes=-500",
+			want: rangeResponse{Size: 100, Satisfiable: true, Ranges: []byteRangeResponse{
+				byteRange("-500", true, 0, 99),
+			}},
+		},
+		{
+			rangeHeader: "bytes=0-0, 50-200, 150-",
+			want: rangeResponse{Size: 100, Satisfiable: true, Ranges: []byteRangeResponse{
+				byteRange("0-0", false, 0, 0),
+				byteRange("50-200", false, 50, 99),
+				{Spec: "150-"},
+			}},
+		},
+		{
+			rangeHeader: "bytes=100-200",
+			want: rangeResponse{Size: 100, Satisfiable: false, Ranges: []byteRangeResponse{
+				{Spec: "100-200"},
+			}},
+		},
+		{
+			rangeHeader: "bytes=-0",
+			want: rangeResponse{Size: 100, Satisfiable: false, Ranges: []byteRangeResponse{
+				{Spec: "-0", Suffix: true},
+			}},
+		},
+		{
+			rangeHeader: "bytes=20-10",
+			want:        rangeResponse{Size: 100, Ranges: []byteRangeResponse{}, Error: `invalid range "20-10": invalid last byte position`},
+		},
+		{
+			rangeHeader: "items=0-10",
+			want:        rangeResponse{Size: 100, Ranges: []byteRangeResponse{}, Error: "invalid range: unit must be bytes"},
+		},
+		{
+			rangeHeader: "bytes=5",
+			want:        rangeResponse{Size: 100, Ranges: []byteRangeResponse{}, Error: `invalid range "5": missing hyphen`},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.rangeHeader, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", "/range/100?echo_range=true")
+			if tc.rangeHeader != "" {
+				req.Header.Set("Range", tc.rangeHeader)
+			}
+			resp := must.DoReq(t, client, req)
+			assert.StatusCode(t, resp, http.StatusOK)
+			result := mustParseResponse[rangeResponse](t, resp)
+			tc.want.Header = tc.rangeHeader
+			assert.DeepEqual(t, result, tc.want, "incorrect range response")
+		})
+	}
+
+	t.Run("invalid echo_range", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/range/100?echo_range=foo")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
 func TestHTML(t *testing.T) {
 	t.Parallel()
 	req := newTestRequest(t, "GET", "/html")
@@ -2438,6 +5599,42 @@ func TestDeny(t *testing.T) {
 	assert.BodyContains(t, resp, `YOU SHOULDN'T BE HERE`)
 }
 
+func TestDenyContentType(t *testing.T) {
+	t.Parallel()
+
+	t.Run("query param", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/deny?content_type=text/html")
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+		assert.ContentType(t, resp, "text/html")
+		assert.BodyContains(t, resp, `YOU SHOULDN'T BE HERE`)
+	})
+
+	t.Run("invalid query param", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/deny?content_type=text%2F")
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("option", func(t *testing.T) {
+		t.Parallel()
+		denySrv, denyClient := newTestServer(New(WithDenyContentType(htmlContentType)))
+		defer denySrv.Close()
+
+		req, err := http.NewRequest("GET", denySrv.URL+"/deny", nil)
+		assert.NilError(t, err)
+		resp := must.DoReq(t, denyClient, req)
+		assert.ContentType(t, resp, htmlContentType)
+
+		req, err = http.NewRequest("GET", denySrv.URL+"/deny?content_type=application/xml", nil)
+		assert.NilError(t, err)
+		resp = must.DoReq(t, denyClient, req)
+		assert.ContentType(t, resp, "application/xml")
+	})
+}
+
 func TestCache(t *testing.T) {
 	t.Run("ok_no_cache", func(t *testing.T) {
 		t.Parallel()
@@ -2486,6 +5683,18 @@ func TestCacheControl(t *testing.T) {
 		assert.Header(t, resp, "Cache-Control", "public, max-age=60")
 	})
 
+	t.Run("ok_no_store", func(t *testing.T) {
+		t.Parallel()
+
+		req := newTestRequest(t, "GET", "/cache/no-store")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[noBodyResponse](t, resp)
+		assert.Header(t, resp, "Cache-Control", "no-store, no-cache, must-revalidate")
+		assert.Header(t, resp, "Pragma", "no-cache")
+		assert.Header(t, resp, "Expires", "0")
+		assert.Equal(t, result.Path, "/cache/no-store", "incorrect path")
+	})
+
 	badTests := []struct {
 		url            string
 		expectedStatus int
@@ -2498,70 +5707,356 @@ func TestCacheControl(t *testing.T) {
 		test := test
 		t.Run("bad"+test.url, func(t *testing.T) {
 			t.Parallel()
-			req := newTestRequest(t, "GET", test.url)
+			req := newTestRequest(t, "GET", test.url)
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, test.expectedStatus)
+		})
+	}
+}
+
+func TestETag(t *testing.T) {
+	t.Run("ok_no_headers", func(t *testing.T) {
+		t.Parallel()
+
+		url := "/etag/abc"
+		req := newTestRequest(t, "GET", url)
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+		assert.Header(t, resp, "ETag", `"abc"`)
+		assert.Header(t, resp, "Cache-Control", "no-cache")
+	})
+
+	t.Run("not_modified_includes_validators", func(t *testing.T) {
+		t.Parallel()
+
+		req := newTestRequest(t, "GET", "/etag/abc")
+		req.Header.Set("If-None-Match", `"abc"`)
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusNotModified)
+		assert.Header(t, resp, "ETag", `"abc"`)
+		assert.Header(t, resp, "Cache-Control", "no-cache")
+		assert.BodySize(t, resp, 0)
+	})
+
+	tests := []struct {
+		name           string
+		etag           string
+		headerKey      string
+		headerVal      string
+		expectedStatus int
+	}{
+		{"if_none_match_matches", "abc", "If-None-Match", `"abc"`, http.StatusNotModified},
+		{"if_none_match_matches_list", "abc", "If-None-Match", `"123", "abc"`, http.StatusNotModified},
+		{"if_none_match_matches_star", "abc", "If-None-Match", "*", http.StatusNotModified},
+		{"if_none_match_matches_w_prefix", "c3piozzzz", "If-None-Match", `W/"xyzzy", W/"r2d2xxxx", W/"c3piozzzz"`, http.StatusNotModified},
+		{"if_none_match_has_no_match", "abc", "If-None-Match", `"123"`, http.StatusOK},
+
+		{"if_match_matches", "abc", "If-Match", `"abc"`, http.StatusOK},
+		{"if_match_matches_list", "abc", "If-Match", `"123", "abc"`, http.StatusOK},
+		{"if_match_matches_star", "abc", "If-Match", "*", http.StatusOK},
+		{"if_match_has_no_match", "abc", "If-Match", `"xxxxxx"`, http.StatusPreconditionFailed},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run("ok_"+test.name, func(t *testing.T) {
+			t.Parallel()
+			url := "/etag/" + test.etag
+			req := newTestRequest(t, "GET", url)
+			req.Header.Add(test.headerKey, test.headerVal)
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, test.expectedStatus)
+		})
+	}
+
+	badTests := []struct {
+		url            string
+		expectedStatus int
+	}{
+		{"/etag/foo/bar", http.StatusNotFound},
+	}
+	for _, test := range badTests {
+		test := test
+		t.Run(fmt.Sprintf("bad/%s", test.url), func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", test.url)
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, test.expectedStatus)
+		})
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	t.Parallel()
+
+	const schema = `{"type": "object", "required": ["name"], "properties": {"name": {"type": "string"}, "age": {"type": "integer", "minimum": 0}}}`
+
+	doValidate := func(t *testing.T, schema string, body string) *http.Response {
+		t.Helper()
+		path := "/validate-json?" + url.Values{"schema": {schema}}.Encode()
+		req := newTestRequestWithBody(t, "POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return must.DoReq(t, client, req)
+	}
+
+	t.Run("conforming document", func(t *testing.T) {
+		t.Parallel()
+		resp := doValidate(t, schema, `{"name": "Ann", "age": 30}`)
+		result := mustParseResponse[validateJSONResponse](t, resp)
+		assert.DeepEqual(t, result, validateJSONResponse{
+			Valid:  true,
+			Errors: []jsonValidationError{},
+		}, "incorrect response")
+	})
+
+	t.Run("non-conforming document", func(t *testing.T) {
+		t.Parallel()
+		resp := doValidate(t, schema, `{"age": -1}`)
+		result := mustParseResponse[validateJSONResponse](t, resp)
+		assert.DeepEqual(t, result, validateJSONResponse{
+			Valid: false,
+			Errors: []jsonValidationError{
+				{Path: "", Message: "missing property 'name'"},
+				{Path: "/age", Message: "minimum: got -1, want 0"},
+			},
+		}, "incorrect response")
+	})
+
+	t.Run("local refs are resolved", func(t *testing.T) {
+		t.Parallel()
+		resp := doValidate(t, `{"$defs": {"name": {"type": "string"}}, "items": {"$ref": "#/$defs/name"}}`, `["Ann", 1]`)
+		result := mustParseResponse[validateJSONResponse](t, resp)
+		assert.Equal(t, result.Valid, false, "expected document to be invalid")
+		assert.Equal(t, len(result.Errors), 1, "expected a single error")
+		assert.Equal(t, result.Errors[0].Path, "/1", "incorrect error path")
+	})
+
+	badTests := map[string]struct {
+		schema string
+		body   string
+	}{
+		"missing schema":    {"", `{}`},
+		"invalid schema":    {`{"type": "float"}`, `{}`},
+		"malformed schema":  {`{`, `{}`},
+		"external file ref": {`{"$ref": "file:///etc/passwd"}`, `{}`},
+		"external http ref": {`{"$ref": "https://example.com/schema.json"}`, `{}`},
+		"schema too large":  {`{"description": "` + strings.Repeat("x", int(maxBodySize)) + `"}`, `{}`},
+		"invalid body JSON": {schema, `{"name": `},
+		"body too large":    {schema, `"` + strings.Repeat("x", int(maxBodySize)) + `"`},
+	}
+	for name, test := range badTests {
+		test := test
+		t.Run("bad/"+name, func(t *testing.T) {
+			t.Parallel()
+			resp := doValidate(t, test.schema, test.body)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, http.StatusBadRequest)
+		})
+	}
+
+	t.Run("method not allowed", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/validate-json")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusMethodNotAllowed)
+	})
+}
+
+func TestFlaky(t *testing.T) {
+	t.Parallel()
+
+	doFlaky := func(t *testing.T, query string) flakyResponse {
+		t.Helper()
+		req := newTestRequest(t, "GET", "/flaky?"+query)
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.ContentType(t, resp, jsonContentType)
+		result := must.Unmarshal[flakyResponse](t, resp.Body)
+		assert.StatusCode(t, resp, result.Status)
+		return result
+	}
+
+	t.Run("fails until success_after attempts", func(t *testing.T) {
+		t.Parallel()
+		for attempt := 1; attempt <= 5; attempt++ {
+			result := doFlaky(t, "key=fails-until&success_after=3")
+			wantStatus := http.StatusOK
+			if attempt <= 3 {
+				wantStatus = http.StatusInternalServerError
+			}
+			assert.DeepEqual(t, result, flakyResponse{
+				Key:          "fails-until",
+				Status:       wantStatus,
+				Attempt:      attempt,
+				SuccessAfter: 3,
+			}, "incorrect response")
+		}
+	})
+
+	t.Run("defaults to one failure", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, doFlaky(t, "key=default").Status, http.StatusInternalServerError, "incorrect first status")
+		assert.Equal(t, doFlaky(t, "key=default").Status, http.StatusOK, "incorrect second status")
+	})
+
+	t.Run("zero success_after never fails", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, doFlaky(t, "key=zero&success_after=0").Status, http.StatusOK, "incorrect status")
+	})
+
+	t.Run("keys are independent", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, doFlaky(t, "key=independent-a&success_after=1").Status, http.StatusInternalServerError, "incorrect status for a")
+		assert.Equal(t, doFlaky(t, "key=independent-a&success_after=1").Status, http.StatusOK, "incorrect status for a")
+		assert.Equal(t, doFlaky(t, "key=independent-b&success_after=1").Status, http.StatusInternalServerError, "incorrect status for b")
+	})
+
+	for _, query := range []string{
+		"",
+		"key=bad&success_after=foo",
+		"key=bad&success_after=-1",
+	} {
+		query := query
+		t.Run("bad/"+query, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", "/flaky?"+query)
 			resp := must.DoReq(t, client, req)
 			defer consumeAndCloseBody(resp)
-			assert.StatusCode(t, resp, test.expectedStatus)
+			assert.StatusCode(t, resp, http.StatusBadRequest)
 		})
 	}
 }
 
-func TestETag(t *testing.T) {
-	t.Run("ok_no_headers", func(t *testing.T) {
+func TestLoadInfo(t *testing.T) {
+	t.Parallel()
+
+	loadSrv, loadClient := newTestServer(New())
+	t.Cleanup(loadSrv.Close)
+
+	const numRequests = 3
+	for i := 0; i < numRequests; i++ {
+		req, _ := http.NewRequest("GET", loadSrv.URL+"/get", nil)
+		resp := must.DoReq(t, loadClient, req)
+		consumeAndCloseBody(resp)
+	}
+
+	req, _ := http.NewRequest("GET", loadSrv.URL+"/loadinfo", nil)
+	resp := must.DoReq(t, loadClient, req)
+	assert.StatusCode(t, resp, http.StatusOK)
+	assert.ContentType(t, resp, jsonContentType)
+
+	// all fields must be present and numeric
+	fields := must.Unmarshal[map[string]any](t, resp.Body)
+	for _, name := range []string{"in_flight_requests", "total_requests", "goroutines", "heap_alloc_bytes"} {
+		if _, ok := fields[name].(float64); !ok {
+			t.Fatalf("expected numeric %s field, got %#v", name, fields[name])
+		}
+	}
+
+	// the /loadinfo request itself is both in flight and counted
+	assert.Equal(t, fields["in_flight_requests"].(float64), 1, "incorrect in_flight_requests")
+	assert.Equal(t, fields["total_requests"].(float64), numRequests+1, "incorrect total_requests")
+	if fields["goroutines"].(float64) < 1 {
+		t.Fatalf("expected at least 1 goroutine, got %v", fields["goroutines"])
+	}
+	if fields["heap_alloc_bytes"].(float64) <= 0 {
+		t.Fatalf("expected positive heap_alloc_bytes, got %v", fields["heap_alloc_bytes"])
+	}
+}
+
+func TestBurst(t *testing.T) {
+	t.Parallel()
+
+	t.Run("reports gaps across a burst", func(t *testing.T) {
 		t.Parallel()
 
-		url := "/etag/abc"
-		req := newTestRequest(t, "GET", url)
+		key := fmt.Sprintf("burst-test-%d", time.Now().UnixNano())
+		gap := 50 * time.Millisecond
+		var result burstResponse
+		for i := 0; i < 4; i++ {
+			if i > 0 {
+				time.Sleep(gap)
+			}
+			req := newTestRequest(t, "GET", "/burst?key="+key)
+			resp := must.DoReq(t, client, req)
+			result = mustParseResponse[burstResponse](t, resp)
+			assert.Equal(t, result.Count, i+1, "incorrect count")
+		}
+
+		assert.Equal(t, result.Key, key, "incorrect key")
+		minGap := float64(gap / time.Millisecond)
+		if result.MinGapMS < minGap {
+			t.Fatalf("expected min_gap_ms >= %v, got %v", minGap, result.MinGapMS)
+		}
+		if result.MaxGapMS < result.MinGapMS || result.MaxGapMS > 1000 {
+			t.Fatalf("expected sane max_gap_ms, got %#v", result)
+		}
+		if result.MeanGapMS < result.MinGapMS || result.MeanGapMS > result.MaxGapMS {
+			t.Fatalf("expected mean_gap_ms between min and max, got %#v", result)
+		}
+	})
+
+	t.Run("first request has no gaps", func(t *testing.T) {
+		t.Parallel()
+		key := fmt.Sprintf("burst-single-%d", time.Now().UnixNano())
+		req := newTestRequest(t, "GET", "/burst?key="+key)
 		resp := must.DoReq(t, client, req)
-		assert.StatusCode(t, resp, http.StatusOK)
-		assert.Header(t, resp, "ETag", `"abc"`)
+		result := mustParseResponse[burstResponse](t, resp)
+		assert.DeepEqual(t, result, burstResponse{Key: key, Count: 1}, "incorrect response")
 	})
 
-	tests := []struct {
-		name           string
-		etag           string
-		headerKey      string
-		headerVal      string
-		expectedStatus int
-	}{
-		{"if_none_match_matches", "abc", "If-None-Match", `"abc"`, http.StatusNotModified},
-		{"if_none_match_matches_list", "abc", "If-None-Match", `"123", "abc"`, http.StatusNotModified},
-		{"if_none_match_matches_star", "abc", "If-None-Match", "*", http.StatusNotModified},
-		{"if_none_match_matches_w_prefix", "c3piozzzz", "If-None-Match", `W/"xyzzy", W/"r2d2xxxx", W/"c3piozzzz"`, http.StatusNotModified},
-		{"if_none_match_has_no_match", "abc", "If-None-Match", `"123"`, http.StatusOK},
+	t.Run("missing key", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/burst")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
 
-		{"if_match_matches", "abc", "If-Match", `"abc"`, http.StatusOK},
-		{"if_match_matches_list", "abc", "If-Match", `"123", "abc"`, http.StatusOK},
-		{"if_match_matches_star", "abc", "If-Match", "*", http.StatusOK},
-		{"if_match_has_no_match", "abc", "If-Match", `"xxxxxx"`, http.StatusPreconditionFailed},
-	}
-	for _, test := range tests {
-		test := test
-		t.Run("ok_"+test.name, func(t *testing.T) {
+func TestBytesAbortAfter(t *testing.T) {
+	t.Parallel()
+
+	for _, n := range []int{0, 100} {
+		n := n
+		t.Run(fmt.Sprintf("abort_after=%d", n), func(t *testing.T) {
 			t.Parallel()
-			url := "/etag/" + test.etag
-			req := newTestRequest(t, "GET", url)
-			req.Header.Add(test.headerKey, test.headerVal)
-			resp := must.DoReq(t, client, req)
-			defer consumeAndCloseBody(resp)
-			assert.StatusCode(t, resp, test.expectedStatus)
+
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			assert.NilError(t, err)
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			_, err = fmt.Fprintf(conn, "GET /bytes/1024?abort_after=%d HTTP/1.1\r\nHost: test\r\n\r\n", n)
+			assert.NilError(t, err)
+
+			r := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(r, nil)
+			assert.NilError(t, err)
+			assert.StatusCode(t, resp, http.StatusOK)
+			assert.Header(t, resp, "Content-Length", "1024")
+
+			body, err := io.ReadAll(resp.Body)
+			assert.Error(t, err, io.ErrUnexpectedEOF)
+			assert.Equal(t, len(body), n, "incorrect number of bytes before abort")
+
+			// the connection was closed rather than kept alive
+			_, err = r.ReadByte()
+			assert.Error(t, err, io.EOF)
 		})
 	}
 
-	badTests := []struct {
-		url            string
-		expectedStatus int
-	}{
-		{"/etag/foo/bar", http.StatusNotFound},
-	}
-	for _, test := range badTests {
-		test := test
-		t.Run(fmt.Sprintf("bad/%s", test.url), func(t *testing.T) {
+	for _, query := range []string{"abort_after=-1", "abort_after=1025", "abort_after=foo"} {
+		query := query
+		t.Run("invalid "+query, func(t *testing.T) {
 			t.Parallel()
-			req := newTestRequest(t, "GET", test.url)
+			req := newTestRequest(t, "GET", "/bytes/1024?"+query)
 			resp := must.DoReq(t, client, req)
 			defer consumeAndCloseBody(resp)
-			assert.StatusCode(t, resp, test.expectedStatus)
+			assert.StatusCode(t, resp, http.StatusBadRequest)
 		})
 	}
 }
@@ -2592,13 +6087,54 @@ func TestBytes(t *testing.T) {
 		assert.BodyEquals(t, resp, want)
 	})
 
+	t.Run("ok_range", func(t *testing.T) {
+		t.Parallel()
+
+		url := "/bytes/1024?seed=1234567890"
+		resp := must.DoReq(t, client, newTestRequest(t, "GET", url))
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusOK)
+		assert.Header(t, resp, "Accept-Ranges", "bytes")
+		full := must.ReadAll(t, resp.Body)
+
+		rangeTests := []struct {
+			rangeHeader string
+			start, end  int
+		}{
+			{"bytes=0-15", 0, 15},
+			{"bytes=500-599", 500, 599},
+			{"bytes=1000-", 1000, 1023},
+			{"bytes=-10", 1014, 1023},
+		}
+		for _, test := range rangeTests {
+			req := newTestRequest(t, "GET", url)
+			req.Header.Set("Range", test.rangeHeader)
+			resp := must.DoReq(t, client, req)
+			assert.StatusCode(t, resp, http.StatusPartialContent)
+			assert.ContentType(t, resp, binaryContentType)
+			assert.Header(t, resp, "Content-Range", fmt.Sprintf("bytes %d-%d/1024", test.start, test.end))
+			assert.BodyEquals(t, resp, full[test.start:test.end+1])
+		}
+	})
+
+	t.Run("unsatisfiable_range", func(t *testing.T) {
+		t.Parallel()
+
+		req := newTestRequest(t, "GET", "/bytes/16?seed=1234567890")
+		req.Header.Set("Range", "bytes=16-")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.Equal(t, resp.StatusCode, http.StatusRequestedRangeNotSatisfiable, "incorrect status code")
+		assert.Header(t, resp, "Content-Range", "bytes */16")
+	})
+
 	edgeCaseTests := []struct {
 		url                   string
 		expectedContentLength int
 	}{
 		{"/bytes/0", 0},
 		{"/bytes/1", 1},
-		{"/bytes/99999999", 100 * 1024},
+		{fmt.Sprintf("/bytes/%d", app.MaxBodySize), int(app.MaxBodySize)},
 
 		// negative seed allowed
 		{"/bytes/16?seed=-12345", 16},
@@ -2623,6 +6159,8 @@ func TestBytes(t *testing.T) {
 		expectedStatus int
 	}{
 		{"/bytes/-1", http.StatusBadRequest},
+		{fmt.Sprintf("/bytes/%d", app.MaxBodySize+1), http.StatusBadRequest},
+		{"/bytes/99999999", http.StatusBadRequest},
 
 		{"/bytes", http.StatusNotFound},
 		{"/bytes/16/foo", http.StatusNotFound},
@@ -2690,6 +6228,10 @@ func TestStreamBytes(t *testing.T) {
 
 		{"/stream-bytes/16?chunk_size=foo", http.StatusBadRequest},
 		{"/stream-bytes/16?chunk_size=3.14", http.StatusBadRequest},
+
+		{"/stream-bytes/16?drop_rate=foo", http.StatusBadRequest},
+		{"/stream-bytes/16?drop_rate=-1", http.StatusBadRequest},
+		{"/stream-bytes/16?drop_rate=2", http.StatusBadRequest},
 	}
 	for _, test := range badTests {
 		test := test
@@ -2703,6 +6245,67 @@ func TestStreamBytes(t *testing.T) {
 	}
 }
 
+func TestBytesMaxBodySize(t *testing.T) {
+	t.Parallel()
+
+	maxBodySize := int64(2048)
+	bytesSrv, bytesClient := newTestServer(New(WithMaxBodySize(maxBodySize)))
+	t.Cleanup(bytesSrv.Close)
+
+	for _, path := range []string{"/bytes", "/stream-bytes"} {
+		path := path
+		t.Run(path+"/max", func(t *testing.T) {
+			t.Parallel()
+			req, err := http.NewRequest("GET", fmt.Sprintf("%s%s/%d", bytesSrv.URL, path, maxBodySize), nil)
+			assert.NilError(t, err)
+			resp := must.DoReq(t, bytesClient, req)
+			assert.StatusCode(t, resp, http.StatusOK)
+			assert.BodySize(t, resp, int(maxBodySize))
+		})
+
+		t.Run(path+"/too large", func(t *testing.T) {
+			t.Parallel()
+			req, err := http.NewRequest("GET", fmt.Sprintf("%s%s/%d", bytesSrv.URL, path, maxBodySize+1), nil)
+			assert.NilError(t, err)
+			resp := must.DoReq(t, bytesClient, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, http.StatusBadRequest)
+		})
+	}
+}
+
+func TestStreamBytesDropRate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("drops roughly expected fraction", func(t *testing.T) {
+		t.Parallel()
+		url := "/stream-bytes/1000?chunk_size=10&drop_rate=0.25&seed=1234"
+
+		req := newTestRequest(t, "GET", url)
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+		assert.Header(t, resp, "Content-Length", "")
+		body := must.ReadAll(t, resp.Body)
+		if len(body)%10 != 0 {
+			t.Fatalf("expected only whole chunks to be written, got %d bytes", len(body))
+		}
+		if len(body) < 600 || len(body) > 900 {
+			t.Fatalf("expected roughly 750 of 1000 bytes, got %d", len(body))
+		}
+
+		req = newTestRequest(t, "GET", url)
+		resp = must.DoReq(t, client, req)
+		assert.BodyEquals(t, resp, body)
+	})
+
+	t.Run("zero drop rate keeps all chunks", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/stream-bytes/1000?chunk_size=100&drop_rate=0")
+		resp := must.DoReq(t, client, req)
+		assert.BodySize(t, resp, 1000)
+	})
+}
+
 func TestLinks(t *testing.T) {
 	for _, env := range envs {
 		env := env
@@ -2815,30 +6418,300 @@ func TestImage(t *testing.T) {
 		})
 	}
 
-	imageTests := []struct {
-		url            string
-		expectedStatus int
-	}{
-		{"/image/png", http.StatusOK},
-		{"/image/jpeg", http.StatusOK},
-		{"/image/webp", http.StatusOK},
-		{"/image/svg", http.StatusOK},
-
-		{"/image/raw", http.StatusNotFound},
-		{"/image/jpg", http.StatusNotFound},
-		{"/image/png/foo", http.StatusNotFound},
+	imageTests := []struct {
+		url            string
+		expectedStatus int
+	}{
+		{"/image/png", http.StatusOK},
+		{"/image/jpeg", http.StatusOK},
+		{"/image/webp", http.StatusOK},
+		{"/image/svg", http.StatusOK},
+
+		{"/image/raw", http.StatusNotFound},
+		{"/image/jpg", http.StatusNotFound},
+		{"/image/png/foo", http.StatusNotFound},
+	}
+
+	for _, test := range imageTests {
+		test := test
+		t.Run("error"+test.url, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequest(t, "GET", test.url)
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, test.expectedStatus)
+		})
+	}
+}
+
+func TestImageSize(t *testing.T) {
+	t.Parallel()
+
+	maxBodySize := int64(256 * 1024)
+	imageSrv, imageClient := newTestServer(New(WithMaxBodySize(maxBodySize)))
+	t.Cleanup(imageSrv.Close)
+
+	decoders := map[string]func([]byte) error{
+		"png": func(b []byte) error {
+			_, err := png.Decode(bytes.NewReader(b))
+			return err
+		},
+		"jpeg": func(b []byte) error {
+			_, err := jpeg.Decode(bytes.NewReader(b))
+			return err
+		},
+		"svg": func(b []byte) error {
+			dec := xml.NewDecoder(bytes.NewReader(b))
+			for {
+				if _, err := dec.Token(); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+			}
+		},
+		"webp": func(b []byte) error { return nil },
+	}
+
+	testCases := []struct {
+		kind     string
+		size     int
+		wantSize int
+	}{
+		{"png", 20000, 20000},
+		{"png", 8100, 8110}, // nearest size achievable with a tEXt chunk
+		{"png", 100, 8090},  // images are never shrunk
+		{"jpeg", 40000, 40000},
+		{"jpeg", 200000, 200000}, // padding split across multiple segments
+		{"svg", 10000, 10000},
+		{"webp", 20000, 10568}, // padding not supported
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("%s/size=%d", tc.kind, tc.size), func(t *testing.T) {
+			t.Parallel()
+			req, err := http.NewRequest("GET", fmt.Sprintf("%s/image/%s?size=%d", imageSrv.URL, tc.kind, tc.size), nil)
+			assert.NilError(t, err)
+			resp := must.DoReq(t, imageClient, req)
+			assert.StatusCode(t, resp, http.StatusOK)
+			assert.Header(t, resp, "X-Image-Size", strconv.Itoa(tc.wantSize))
+
+			body := must.ReadAll(t, resp.Body)
+			assert.Equal(t, len(body), tc.wantSize, "incorrect image size")
+			assert.NilError(t, decoders[tc.kind]([]byte(body)))
+		})
+	}
+
+	for _, size := range []string{"0", "-1", "foo", strconv.FormatInt(maxBodySize+1, 10)} {
+		size := size
+		t.Run("invalid size="+size, func(t *testing.T) {
+			t.Parallel()
+			req, err := http.NewRequest("GET", imageSrv.URL+"/image/png?size="+size, nil)
+			assert.NilError(t, err)
+			resp := must.DoReq(t, imageClient, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, http.StatusBadRequest)
+		})
+	}
+}
+
+func TestWebhookVerify(t *testing.T) {
+	t.Parallel()
+
+	body := `{"event": "push"}`
+	mac := hmac.New(sha256.New, []byte("go-httpbin"))
+	mac.Write([]byte(body))
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	testCases := map[string]struct {
+		signature string
+		wantValid bool
+	}{
+		"correct signature": {signature, true},
+		"wrong signature":   {"sha256=" + strings.Repeat("0", 64), false},
+		"missing signature": {"", false},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequestWithBody(t, "POST", "/webhook/verify", strings.NewReader(body))
+			if tc.signature != "" {
+				req.Header.Set("X-Signature", tc.signature)
+			}
+			resp := must.DoReq(t, client, req)
+			assert.StatusCode(t, resp, http.StatusOK)
+
+			result := mustParseResponse[webhookVerifyResponse](t, resp)
+			assert.Equal(t, result.Valid, tc.wantValid, "incorrect validity")
+			assert.Equal(t, result.Signature, tc.signature, "incorrect signature")
+			assert.Equal(t, result.ExpectedSignature, signature, "incorrect expected signature")
+		})
+	}
+
+	t.Run("body too large", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequestWithBody(t, "POST", "/webhook/verify", strings.NewReader(strings.Repeat("x", int(app.MaxBodySize)+1)))
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
+func TestAdminReload(t *testing.T) {
+	t.Parallel()
+
+	const token = "secret"
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequestWithBody(t, "POST", "/admin/reload", strings.NewReader(`{}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusNotFound)
+	})
+
+	unauthorizedTests := map[string]string{
+		"missing token": "",
+		"wrong token":   "Bearer wrong",
+		"wrong scheme":  "Basic " + token,
+	}
+	for name, authorization := range unauthorizedTests {
+		authorization := authorization
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			adminSrv, adminClient := newTestServer(New(WithAdminToken(token)))
+			t.Cleanup(adminSrv.Close)
+
+			req, err := http.NewRequest("POST", adminSrv.URL+"/admin/reload", strings.NewReader(`{"max_body_size": 1}`))
+			assert.NilError(t, err)
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			resp := must.DoReq(t, adminClient, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, http.StatusUnauthorized)
+			assert.Header(t, resp, "WWW-Authenticate", "Bearer")
+		})
+	}
+
+	badRequestTests := map[string]string{
+		"invalid json":          `{`,
+		"zero max_body_size":    `{"max_body_size": 0}`,
+		"invalid max_duration":  `{"max_duration": "soon"}`,
+		"negative max_duration": `{"max_duration": "-1s"}`,
+		"wrong type":            `{"max_body_size": "big"}`,
 	}
-
-	for _, test := range imageTests {
-		test := test
-		t.Run("error"+test.url, func(t *testing.T) {
+	for name, body := range badRequestTests {
+		body := body
+		t.Run(name, func(t *testing.T) {
 			t.Parallel()
-			req := newTestRequest(t, "GET", test.url)
-			resp := must.DoReq(t, client, req)
+			adminApp := New(WithAdminToken(token), WithMaxBodySize(1024))
+			adminSrv, adminClient := newTestServer(adminApp)
+			t.Cleanup(adminSrv.Close)
+
+			req, err := http.NewRequest("POST", adminSrv.URL+"/admin/reload", strings.NewReader(body))
+			assert.NilError(t, err)
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp := must.DoReq(t, adminClient, req)
 			defer consumeAndCloseBody(resp)
-			assert.StatusCode(t, resp, test.expectedStatus)
+			assert.StatusCode(t, resp, http.StatusBadRequest)
+			assert.Equal(t, adminApp.maxBodySize(), 1024, "max body size changed by invalid reload")
 		})
 	}
+
+	t.Run("applies new limits", func(t *testing.T) {
+		t.Parallel()
+		adminSrv, adminClient := newTestServer(New(
+			WithAdminToken(token),
+			WithMaxBodySize(1024),
+			WithMaxDuration(time.Second),
+		))
+		t.Cleanup(adminSrv.Close)
+
+		doReq := func(method, path, body string) *http.Response {
+			t.Helper()
+			req, err := http.NewRequest(method, adminSrv.URL+path, strings.NewReader(body))
+			assert.NilError(t, err)
+			req.Header.Set("Authorization", "Bearer "+token)
+			return must.DoReq(t, adminClient, req)
+		}
+
+		payload := strings.Repeat("x", 100)
+
+		// under the initial limits, the request is accepted
+		resp := doReq("POST", "/post", payload)
+		consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusOK)
+
+		resp = doReq("POST", "/admin/reload", `{"max_body_size": 50, "allowed_redirect_domains": ["example.org"]}`)
+		assert.StatusCode(t, resp, http.StatusOK)
+		result := mustParseResponse[adminReloadResponse](t, resp)
+		assert.DeepEqual(t, result, adminReloadResponse{
+			MaxBodySize:            50,
+			MaxDuration:            "1s",
+			AllowedRedirectDomains: []string{"example.org"},
+		}, "incorrect reload response")
+
+		// the same request now exceeds the new limit
+		resp = doReq("POST", "/post", payload)
+		consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+
+		resp = doReq("GET", "/bytes/51", "")
+		consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+
+		resp = doReq("GET", "/redirect-to?url=https://example.com", "")
+		assert.StatusCode(t, resp, http.StatusForbidden)
+		assert.BodyContains(t, resp, "- example.org")
+
+		// omitted limits are left unchanged
+		resp = doReq("POST", "/admin/reload", `{"max_duration": "100ms"}`)
+		assert.StatusCode(t, resp, http.StatusOK)
+		result = mustParseResponse[adminReloadResponse](t, resp)
+		assert.DeepEqual(t, result, adminReloadResponse{
+			MaxBodySize:            50,
+			MaxDuration:            "100ms",
+			AllowedRedirectDomains: []string{"example.org"},
+		}, "incorrect reload response")
+
+		resp = doReq("GET", "/delay/200ms", "")
+		consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("concurrent reloads of different limits are all applied", func(t *testing.T) {
+		t.Parallel()
+		adminApp := New(WithAdminToken(token), WithMaxBodySize(1024), WithMaxDuration(time.Second))
+		adminSrv, adminClient := newTestServer(adminApp)
+		t.Cleanup(adminSrv.Close)
+
+		const n = 20
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			body := `{"max_body_size": 50}`
+			if i%2 == 1 {
+				body = `{"max_duration": "100ms"}`
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, err := http.NewRequest("POST", adminSrv.URL+"/admin/reload", strings.NewReader(body))
+				assert.NilError(t, err)
+				req.Header.Set("Authorization", "Bearer "+token)
+				resp := must.DoReq(t, adminClient, req)
+				defer consumeAndCloseBody(resp)
+				assert.StatusCode(t, resp, http.StatusOK)
+			}()
+		}
+		wg.Wait()
+
+		limits := adminApp.limits.Load()
+		assert.Equal(t, limits.maxBodySize, 50, "incorrect max body size")
+		assert.Equal(t, limits.maxDuration, 100*time.Millisecond, "incorrect max duration")
+	})
 }
 
 func TestXML(t *testing.T) {
@@ -2849,6 +6722,76 @@ func TestXML(t *testing.T) {
 	assert.BodyContains(t, resp, `<?xml version='1.0' encoding='us-ascii'?>`)
 }
 
+func TestStaticDir(t *testing.T) {
+	fsys := fstest.MapFS{
+		"secret.txt":          {Data: []byte("top secret")},
+		"public/hello.txt":    {Data: []byte("hello, world")},
+		"public/nested/a.txt": {Data: []byte("nested")},
+	}
+	publicFS, err := fs.Sub(fsys, "public")
+	assert.NilError(t, err)
+
+	app := New(WithStaticDir(publicFS, "/static/"))
+	srv, client := newTestServer(app)
+	t.Cleanup(srv.Close)
+
+	t.Run("ok", func(t *testing.T) {
+		t.Parallel()
+		for path, want := range map[string]string{
+			"/static/hello.txt":    "hello, world",
+			"/static/nested/a.txt": "nested",
+		} {
+			req, err := http.NewRequest("GET", srv.URL+path, nil)
+			assert.NilError(t, err)
+			resp := must.DoReq(t, client, req)
+			assert.StatusCode(t, resp, http.StatusOK)
+			assert.BodyEquals(t, resp, want)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		t.Parallel()
+		req, err := http.NewRequest("GET", srv.URL+"/static/missing.txt", nil)
+		assert.NilError(t, err)
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusNotFound)
+	})
+
+	t.Run("path traversal", func(t *testing.T) {
+		t.Parallel()
+		for _, path := range []string{
+			"/static/../secret.txt",
+			"/static/..%2fsecret.txt",
+			"/static/nested/..%2f..%2fsecret.txt",
+		} {
+			req, err := http.NewRequest("GET", srv.URL+path, nil)
+			assert.NilError(t, err)
+			resp := must.DoReq(t, client, req)
+			body := must.ReadAll(t, resp.Body)
+			if resp.StatusCode == http.StatusOK || strings.Contains(body, "top secret") {
+				t.Fatalf("expected traversal attempt %q to be rejected, got %d response %q", path, resp.StatusCode, body)
+			}
+		}
+
+		// exercise the handler directly to bypass any path cleaning done by
+		// the client or mux
+		r := httptest.NewRequest("GET", "/", nil)
+		r.URL.Path = "/../secret.txt"
+		w := httptest.NewRecorder()
+		app.staticFiles(fsys).ServeHTTP(w, r)
+		assert.Equal(t, w.Code, http.StatusBadRequest, "expected traversal attempt to be rejected")
+	})
+
+	t.Run("unset by default", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/static/hello.txt")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusNotFound)
+	})
+}
+
 func testValidUUIDv4(t *testing.T, uuid string) {
 	t.Helper()
 	assert.Equal(t, len(uuid), 36, "incorrect uuid length")
@@ -2983,25 +6926,395 @@ func TestBase64(t *testing.T) {
 	}
 }
 
+func TestBase64Body(t *testing.T) {
+	t.Parallel()
+
+	// use a dedicated server allowing much larger bodies than the input that
+	// may be given in the URL path
+	bigSrv, bigClient := newTestServer(New(WithMaxBodySize(1024 * 1024)))
+	t.Cleanup(bigSrv.Close)
+
+	doPost := func(t *testing.T, path string, body []byte) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest("POST", bigSrv.URL+path, bytes.NewReader(body))
+		assert.NilError(t, err)
+		return must.DoReq(t, bigClient, req)
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		t.Parallel()
+
+		input := make([]byte, 512*1024)
+		for i := range input {
+			input[i] = byte(i % 251)
+		}
+
+		resp := doPost(t, "/base64/encode", input)
+		assert.StatusCode(t, resp, http.StatusOK)
+		assert.ContentType(t, resp, textContentType)
+		encoded := must.ReadAll(t, resp.Body)
+		assert.Equal(t, encoded, base64.URLEncoding.EncodeToString(input), "incorrect encoding")
+
+		resp = doPost(t, "/base64/decode", []byte(encoded))
+		assert.StatusCode(t, resp, http.StatusOK)
+		assert.ContentType(t, resp, binaryContentType)
+		decoded := must.ReadAll(t, resp.Body)
+		assert.Equal(t, decoded, string(input), "incorrect round trip")
+	})
+
+	t.Run("decode std encoding", func(t *testing.T) {
+		t.Parallel()
+		resp := doPost(t, "/base64/decode", []byte("8J+Ziywg8J+MjSEK4oCm"))
+		assert.StatusCode(t, resp, http.StatusOK)
+		assert.BodyEquals(t, resp, "🙋, 🌍!\n…")
+	})
+
+	errorTests := []struct {
+		path string
+		body []byte
+	}{
+		{"/base64/encode", nil},
+		{"/base64/decode", nil},
+		{"/base64/decode", []byte("invalid_base64_encoded_string")},
+		{"/base64/encode", bytes.Repeat([]byte("X"), 1024*1024+1)},
+	}
+	for _, tc := range errorTests {
+		tc := tc
+		t.Run(fmt.Sprintf("error%s/%d", tc.path, len(tc.body)), func(t *testing.T) {
+			t.Parallel()
+			resp := doPost(t, tc.path, tc.body)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, http.StatusBadRequest)
+		})
+	}
+}
+
+func TestMaxBodySizeHeader(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		headerValue string
+		bodySize    int
+		wantStatus  int
+	}{
+		{"10", 10, http.StatusOK},
+		{"10", 11, http.StatusBadRequest},
+		{"0", 0, http.StatusOK},
+		{"0", 1, http.StatusBadRequest},
+
+		// values above the server's limit are clamped to it
+		{"999999", int(maxBodySize), http.StatusOK},
+		{"999999", int(maxBodySize) + 1, http.StatusBadRequest},
+
+		{"foo", 0, http.StatusBadRequest},
+		{"-1", 0, http.StatusBadRequest},
+		{"1.5", 0, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("%s/%d", test.headerValue, test.bodySize), func(t *testing.T) {
+			t.Parallel()
+			body := strings.Repeat("x", test.bodySize)
+			req := newTestRequestWithBody(t, "POST", "/post", strings.NewReader(body))
+			req.Header.Set("Content-Type", "text/plain")
+			req.Header.Set("X-Max-Body-Size", test.headerValue)
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, test.wantStatus)
+		})
+	}
+}
+
+func TestSemicolonSeparators(t *testing.T) {
+	t.Parallel()
+
+	t.Run("default query", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "POST", "/post?a=1;b=2&c=3")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.DeepEqual(t, result.Args, url.Values{"c": {"3"}}, "expected semicolon-separated args to be dropped")
+	})
+
+	t.Run("default form", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequestWithBody(t, "POST", "/post", strings.NewReader("a=1;b=2"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("semicolon query and form", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequestWithBody(t, "POST", "/post?a=1;b=2&semicolon=true", strings.NewReader("x=1;y=2&z=3"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp := must.DoReq(t, client, req)
+		result := mustParseResponse[bodyResponse](t, resp)
+		assert.DeepEqual(t, result.Args, url.Values{"a": {"1"}, "b": {"2"}, "semicolon": {"true"}}, "incorrect args")
+		assert.DeepEqual(t, result.Form, url.Values{"x": {"1"}, "y": {"2"}, "z": {"3"}}, "incorrect form")
+	})
+
+	t.Run("invalid semicolon param", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "POST", "/post?semicolon=foo")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	doReq := func(t *testing.T, method, key, body string, headers map[string]string) *http.Response {
+		t.Helper()
+		req := newTestRequestWithBody(t, method, "/diff?key="+key, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return must.DoReq(t, client, req)
+	}
+
+	t.Run("differing requests", func(t *testing.T) {
+		t.Parallel()
+
+		resp := doReq(t, "POST", "differing", `{"a":1}`, map[string]string{"X-Foo": "bar"})
+		stored := mustParseResponse[diffStoredResponse](t, resp)
+		assert.DeepEqual(t, stored, diffStoredResponse{Key: "differing", Stored: true}, "incorrect first response")
+
+		resp = doReq(t, "PUT", "differing", `{"a":2}`, map[string]string{"X-Foo": "baz", "X-New": "1"})
+		result := mustParseResponse[diffResponse](t, resp)
+		assert.DeepEqual(t, result, diffResponse{
+			Key:    "differing",
+			Method: &diffValue[string]{"POST", "PUT"},
+			Headers: map[string]diffValue[[]string]{
+				"X-Foo": {[]string{"bar"}, []string{"baz"}},
+				"X-New": {nil, []string{"1"}},
+			},
+			Body: &diffValue[string]{`{"a":1}`, `{"a":2}`},
+		}, "incorrect diff")
+
+		// the key is cleared after the second request
+		resp = doReq(t, "POST", "differing", "", nil)
+		stored = mustParseResponse[diffStoredResponse](t, resp)
+		assert.Equal(t, stored.Stored, true, "expected key to be cleared")
+	})
+
+	t.Run("identical requests", func(t *testing.T) {
+		t.Parallel()
+
+		for i := 0; i < 2; i++ {
+			resp := doReq(t, "POST", "identical", "same body", map[string]string{"X-Foo": "bar"})
+			if i == 0 {
+				consumeAndCloseBody(resp)
+				continue
+			}
+			result := mustParseResponse[diffResponse](t, resp)
+			assert.DeepEqual(t, result, diffResponse{Key: "identical", Identical: true}, "incorrect diff")
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		t.Parallel()
+		resp := doReq(t, "GET", "", "", nil)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("store expiry and eviction", func(t *testing.T) {
+		t.Parallel()
+
+		store := newDiffStore(time.Minute, 2, 0)
+		now := time.Now()
+		sig := &requestSignature{method: "GET"}
+
+		_, ok := store.Swap("a", sig, now)
+		assert.Equal(t, ok, false, "expected first swap to store")
+		_, ok = store.Swap("a", sig, now.Add(2*time.Minute))
+		assert.Equal(t, ok, false, "expected expired entry to be replaced")
+
+		_, _ = store.Swap("b", sig, now)
+		_, _ = store.Swap("c", sig, now)
+		_, ok = store.Swap("a", sig, now)
+		assert.Equal(t, ok, false, "expected oldest entry to be evicted")
+		got, ok := store.Swap("c", sig, now)
+		assert.Equal(t, ok, true, "expected entry to be found")
+		assert.Equal(t, got, sig, "incorrect signature")
+	})
+
+	t.Run("store byte budget", func(t *testing.T) {
+		t.Parallel()
+
+		store := newDiffStore(time.Minute, 10, 100)
+		now := time.Now()
+		sig := &requestSignature{body: strings.Repeat("x", 40)}
+
+		_, _ = store.Swap("a", sig, now)
+		_, _ = store.Swap("b", sig, now)
+		_, _ = store.Swap("c", sig, now) // evicts "a"
+		_, ok := store.Swap("a", sig, now)
+		assert.Equal(t, ok, false, "expected oldest entry to be evicted")
+
+		_, _ = store.Swap("big", &requestSignature{body: strings.Repeat("x", 101)}, now)
+		_, ok = store.Swap("big", sig, now)
+		assert.Equal(t, ok, false, "expected oversized entry not to be stored")
+	})
+}
+
+func TestReverse(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		body            []byte
+		contentType     string
+		wantBody        []byte
+		wantContentType string
+	}{
+		"text": {
+			body:            []byte("hello, world"),
+			contentType:     "text/plain; charset=utf-8",
+			wantBody:        []byte("dlrow ,olleh"),
+			wantContentType: "text/plain; charset=utf-8",
+		},
+		"binary without content type": {
+			body:            []byte{0x00, 0x01, 0xfe, 0xff},
+			wantBody:        []byte{0xff, 0xfe, 0x01, 0x00},
+			wantContentType: binaryContentType,
+		},
+		"empty": {
+			contentType:     "application/json",
+			wantBody:        []byte{},
+			wantContentType: "application/json",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			req := newTestRequestWithBody(t, "POST", "/reverse", bytes.NewReader(tc.body))
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			resp := must.DoReq(t, client, req)
+			assert.StatusCode(t, resp, http.StatusOK)
+			assert.ContentType(t, resp, tc.wantContentType)
+			assert.BodyEquals(t, resp, string(tc.wantBody))
+		})
+	}
+
+	t.Run("body too large", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequestWithBody(t, "POST", "/reverse", bytes.NewReader(make([]byte, maxBodySize+1)))
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
 func TestDumpRequest(t *testing.T) {
 	t.Parallel()
 
-	req := newTestRequest(t, "GET", "/dump/request?foo=bar")
-	req.Host = "test-host"
-	req.Header.Set("x-test-header2", "Test-Value2")
-	req.Header.Set("x-test-header1", "Test-Value1")
+	req := newTestRequest(t, "GET", "/dump/request?foo=bar")
+	req.Host = "test-host"
+	req.Header.Set("x-test-header2", "Test-Value2")
+	req.Header.Set("x-test-header1", "Test-Value1")
+
+	resp := must.DoReq(t, client, req)
+	assert.ContentType(t, resp, textContentType)
+	assert.BodyEquals(t, resp, "GET /dump/request?foo=bar HTTP/1.1\r\nHost: test-host\r\nAccept-Encoding: gzip\r\nUser-Agent: Go-http-client/1.1\r\nX-Test-Header1: Test-Value1\r\nX-Test-Header2: Test-Value2\r\n\r\n")
+}
+
+func TestJSON(t *testing.T) {
+	t.Parallel()
+	req := newTestRequest(t, "GET", "/json")
+	resp := must.DoReq(t, client, req)
+	assert.ContentType(t, resp, jsonContentType)
+	assert.BodyContains(t, resp, `Wake up to WonderWidgets!`)
+}
+
+func TestContentDigest(t *testing.T) {
+	sha256Digest := func(body string) string {
+		sum := sha256.Sum256([]byte(body))
+		return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	}
+
+	t.Run("no digest by default", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/json")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusOK)
+		assert.Header(t, resp, "Content-Digest", "")
+	})
+
+	t.Run("sha-256 via query param", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/json?digest=sha-256")
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+		body := must.ReadAll(t, resp.Body)
+		assert.Header(t, resp, "Content-Digest", sha256Digest(body))
+	})
+
+	t.Run("sha-512 via query param", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/json?digest=SHA-512")
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+		body := must.ReadAll(t, resp.Body)
+		sum := sha512.Sum512([]byte(body))
+		assert.Header(t, resp, "Content-Digest", "sha-512=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+	})
+
+	t.Run("enabled by server default", func(t *testing.T) {
+		t.Parallel()
+
+		app := New(WithContentDigest(true))
+		srv, client := newTestServer(app)
+		defer srv.Close()
+
+		for _, path := range []string{"/json", "/get", "/status/418", "/base64/encode/digest"} {
+			req, err := http.NewRequest("GET", srv.URL+path, nil)
+			assert.NilError(t, err)
+			resp := must.DoReq(t, client, req)
+			body := must.ReadAll(t, resp.Body)
+			assert.Header(t, resp, "Content-Digest", sha256Digest(body))
+		}
+	})
 
-	resp := must.DoReq(t, client, req)
-	assert.ContentType(t, resp, textContentType)
-	assert.BodyEquals(t, resp, "GET /dump/request?foo=bar HTTP/1.1\r\nHost: test-host\r\nAccept-Encoding: gzip\r\nUser-Agent: Go-http-client/1.1\r\nX-Test-Header1: Test-Value1\r\nX-Test-Header2: Test-Value2\r\n\r\n")
-}
+	t.Run("empty body has no digest", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/status/204?digest=sha-256")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusNoContent)
+		assert.Header(t, resp, "Content-Digest", "")
+	})
 
-func TestJSON(t *testing.T) {
-	t.Parallel()
-	req := newTestRequest(t, "GET", "/json")
-	resp := must.DoReq(t, client, req)
-	assert.ContentType(t, resp, jsonContentType)
-	assert.BodyContains(t, resp, `Wake up to WonderWidgets!`)
+	t.Run("streaming endpoints are exempt", func(t *testing.T) {
+		t.Parallel()
+		for _, path := range []string{"/stream/3", "/stream-bytes/100", "/drip?duration=50ms&numbytes=3"} {
+			req := newTestRequest(t, "GET", path)
+			q := req.URL.Query()
+			q.Set("digest", "sha-256")
+			req.URL.RawQuery = q.Encode()
+			resp := must.DoReq(t, client, req)
+			defer consumeAndCloseBody(resp)
+			assert.StatusCode(t, resp, http.StatusOK)
+			assert.Header(t, resp, "Content-Digest", "")
+		}
+	})
+
+	t.Run("unsupported digest is ignored and echoed", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/get?digest=md5")
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+		assert.Header(t, resp, "Content-Digest", "")
+		result := mustParseResponse[noBodyResponse](t, resp)
+		assert.Equal(t, result.Args.Get("digest"), "md5", "expected digest arg to be echoed")
+	})
 }
 
 func TestBearer(t *testing.T) {
@@ -3062,22 +7375,44 @@ func TestBearer(t *testing.T) {
 	}
 }
 
-func TestNotImplemented(t *testing.T) {
-	tests := []struct {
-		url string
-	}{
-		{"/brotli"},
-	}
-	for _, test := range tests {
-		test := test
-		t.Run(test.url, func(t *testing.T) {
-			t.Parallel()
-			req := newTestRequest(t, "GET", test.url)
-			resp := must.DoReq(t, client, req)
-			defer consumeAndCloseBody(resp)
-			assert.StatusCode(t, resp, http.StatusNotImplemented)
-		})
-	}
+func TestConfig(t *testing.T) {
+	t.Parallel()
+
+	t.Run("default", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/config")
+		resp := must.DoReq(t, client, req)
+		assert.StatusCode(t, resp, http.StatusOK)
+		result := mustParseResponse[configResponse](t, resp)
+		assert.DeepEqual(t, result.Timeouts, timeoutsResponse{
+			MaxDuration: maxDuration.String(),
+		}, "incorrect timeouts")
+	})
+
+	t.Run("server timeouts", func(t *testing.T) {
+		t.Parallel()
+		app := New(
+			WithMaxDuration(3*time.Second),
+			WithBodyReadTimeout(2*time.Second),
+			WithServerTimeouts(ServerTimeouts{
+				ReadTimeout:       5 * time.Second,
+				ReadHeaderTimeout: time.Second,
+				IdleTimeout:       90 * time.Second,
+			}),
+		)
+		r := httptest.NewRequest("GET", "/config", nil)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		assert.Equal(t, w.Code, http.StatusOK, "incorrect status code")
+		result := must.Unmarshal[configResponse](t, w.Body)
+		assert.DeepEqual(t, result.Timeouts, timeoutsResponse{
+			MaxDuration:          "3s",
+			BodyReadTimeout:      "2s",
+			SrvReadTimeout:       "5s",
+			SrvReadHeaderTimeout: "1s",
+			SrvIdleTimeout:       "1m30s",
+		}, "incorrect timeouts")
+	})
 }
 
 func TestHostname(t *testing.T) {
@@ -3180,7 +7515,7 @@ func TestSSE(t *testing.T) {
 
 		{&url.Values{"count": {"1"}}, 0, 1},
 		{&url.Values{"count": {"011"}}, 0, 11},
-		{&url.Values{"count": {fmt.Sprintf("%d", app.maxSSECount)}}, 0, int(app.maxSSECount)},
+		{&url.Values{"count": {fmt.Sprintf("%d", app.limits.Load().maxSSECount)}}, 0, int(app.limits.Load().maxSSECount)},
 
 		{&url.Values{"duration": {"250ms"}, "delay": {"250ms"}}, 500 * time.Millisecond, 10},
 		{&url.Values{"duration": {"250ms"}, "delay": {"0.25s"}}, 500 * time.Millisecond, 10},
@@ -3229,7 +7564,11 @@ func TestSSE(t *testing.T) {
 		{&url.Values{"count": {"0"}}, http.StatusBadRequest},
 		{&url.Values{"count": {"-1"}}, http.StatusBadRequest},
 		{&url.Values{"count": {"0xff"}}, http.StatusBadRequest},
-		{&url.Values{"count": {fmt.Sprintf("%d", app.maxSSECount+1)}}, http.StatusBadRequest},
+		{&url.Values{"count": {fmt.Sprintf("%d", app.limits.Load().maxSSECount+1)}}, http.StatusBadRequest},
+
+		{&url.Values{"retry_ms": {"foo"}}, http.StatusBadRequest},
+		{&url.Values{"retry_ms": {"-1"}}, http.StatusBadRequest},
+		{&url.Values{"retry_ms": {"1.5"}}, http.StatusBadRequest},
 
 		// request would take too long
 		{&url.Values{"duration": {"750ms"}, "delay": {"500ms"}}, http.StatusBadRequest},
@@ -3246,6 +7585,48 @@ func TestSSE(t *testing.T) {
 		})
 	}
 
+	t.Run("retry directive", func(t *testing.T) {
+		t.Parallel()
+
+		for _, retryMs := range []string{"0", "1500"} {
+			req := newTestRequest(t, "GET", "/sse?count=2&retry_ms="+retryMs)
+			resp := must.DoReq(t, client, req)
+			assert.StatusCode(t, resp, http.StatusOK)
+			buf := bufio.NewReader(resp.Body)
+
+			// retry directive is sent first, as its own block
+			retryLine, err := buf.ReadString('\n')
+			assert.NilError(t, err)
+			assert.Equal(t, retryLine, "retry: "+retryMs+"\n", "unexpected retry line")
+			blankLine, err := buf.ReadString('\n')
+			assert.NilError(t, err)
+			assert.Equal(t, blankLine, "\n", "expected blank line after retry directive")
+
+			// stream is otherwise unchanged
+			var events []serverSentEvent
+			for {
+				event, err := parseServerSentEvent(t, buf)
+				if err == io.EOF {
+					break
+				}
+				assert.NilError(t, err)
+				events = append(events, event)
+			}
+			assert.Equal(t, len(events), 2, "unexpected number of events")
+			resp.Body.Close()
+		}
+	})
+
+	t.Run("no retry directive by default", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/sse?count=1")
+		resp := must.DoReq(t, client, req)
+		body := must.ReadAll(t, resp.Body)
+		if strings.Contains(body, "retry:") {
+			t.Fatalf("unexpected retry directive in stream: %q", body)
+		}
+	})
+
 	t.Run("writes are actually incremmental", func(t *testing.T) {
 		t.Parallel()
 
@@ -3395,6 +7776,88 @@ func TestSSE(t *testing.T) {
 	})
 }
 
+func TestSSEJSONPatch(t *testing.T) {
+	t.Parallel()
+
+	// applyPatch applies the subset of RFC 6902 operations needed to add and
+	// replace object members and append to arrays
+	applyPatch := func(t *testing.T, doc map[string]any, patch []jsonPatchOperation) {
+		t.Helper()
+		for _, op := range patch {
+			key, rest, _ := strings.Cut(strings.TrimPrefix(op.Path, "/"), "/")
+			switch {
+			case op.Op == "add" && rest == "-":
+				arr, ok := doc[key].([]any)
+				if !ok {
+					t.Fatalf("cannot append to non-array at %q", op.Path)
+				}
+				doc[key] = append(arr, op.Value)
+			case op.Op == "add" && rest == "":
+				doc[key] = op.Value
+			case op.Op == "replace" && rest == "":
+				if _, ok := doc[key]; !ok {
+					t.Fatalf("cannot replace missing member at %q", op.Path)
+				}
+				doc[key] = op.Value
+			default:
+				t.Fatalf("unsupported patch operation %q at %q", op.Op, op.Path)
+			}
+		}
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		t.Parallel()
+
+		count := 5
+		req := newTestRequest(t, "GET", fmt.Sprintf("/sse?mode=jsonpatch&count=%d&duration=5ms", count))
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusOK)
+		assert.ContentType(t, resp, sseContentType)
+
+		doc := map[string]any{}
+		events := 0
+		buf := bufio.NewReader(resp.Body)
+		for {
+			eventLine, err := buf.ReadString('\n')
+			if err == io.EOF {
+				break
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, eventLine, "event: patch\n", "unexpected event line")
+
+			dataLine, err := buf.ReadString('\n')
+			assert.NilError(t, err)
+			data, ok := strings.CutPrefix(dataLine, "data: ")
+			if !ok {
+				t.Fatalf("expected data line, got %q", dataLine)
+			}
+			applyPatch(t, doc, must.Unmarshal[[]jsonPatchOperation](t, strings.NewReader(data)))
+
+			blankLine, err := buf.ReadString('\n')
+			assert.NilError(t, err)
+			assert.Equal(t, blankLine, "\n", "expected blank line after event data")
+			events++
+		}
+		assert.Equal(t, events, count, "incorrect number of events")
+
+		// values decoded from JSON are float64s and []anys
+		want := map[string]any{
+			"counter": float64(count),
+			"ids":     []any{float64(0), float64(1), float64(2), float64(3), float64(4)},
+		}
+		assert.DeepEqual(t, doc, want, "incorrect final state")
+	})
+
+	t.Run("invalid mode", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, "GET", "/sse?mode=jsonmerge")
+		resp := must.DoReq(t, client, req)
+		defer consumeAndCloseBody(resp)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
 func TestWebSocketEcho(t *testing.T) {
 	// ========================================================================
 	// Note: Here we only test input validation for the websocket endpoint.
@@ -3431,6 +7894,38 @@ func TestWebSocketEcho(t *testing.T) {
 		assert.StatusCode(t, resp, http.StatusBadRequest)
 	})
 
+	t.Run("handshake delay", func(t *testing.T) {
+		t.Parallel()
+
+		delay := 100 * time.Millisecond
+		req := newTestRequest(t, http.MethodGet, fmt.Sprintf("/websocket/echo?handshake_delay=%s", delay))
+		for k, v := range handshakeHeaders {
+			req.Header.Set(k, v)
+		}
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		elapsed := time.Since(start)
+		assert.NilError(t, err)
+		assert.StatusCode(t, resp, http.StatusSwitchingProtocols)
+		assert.RoughlyEqual(t, elapsed, delay, 50*time.Millisecond)
+	})
+
+	t.Run("handshake delay canceled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 25*time.Millisecond)
+		defer cancel()
+
+		req := newTestRequest(t, http.MethodGet, "/websocket/echo?handshake_delay=500ms").WithContext(ctx)
+		for k, v := range handshakeHeaders {
+			req.Header.Set(k, v)
+		}
+		if _, err := client.Do(req); !os.IsTimeout(err) {
+			t.Fatalf("expected timeout error, got %s", err)
+		}
+	})
+
 	paramTests := []struct {
 		query      string
 		wantStatus int
@@ -3451,6 +7946,32 @@ func TestWebSocketEcho(t *testing.T) {
 		{"max_fragment_size=1&max_message_size=-1", http.StatusBadRequest},
 		{"max_fragment_size=1&max_message_size=bar", http.StatusBadRequest},
 		{fmt.Sprintf("max_fragment_size=1&max_message_size=%d", app.MaxBodySize+1), http.StatusBadRequest},
+
+		// max_total_bytes
+		{"max_total_bytes=1", http.StatusSwitchingProtocols},
+		{"max_total_bytes=0", http.StatusBadRequest},
+		{"max_total_bytes=-1", http.StatusBadRequest},
+		{"max_total_bytes=foo", http.StatusBadRequest},
+
+		// verify_fragments
+		{"verify_fragments=true", http.StatusSwitchingProtocols},
+		{"verify_fragments=foo", http.StatusBadRequest},
+
+		// only
+		{"only=text", http.StatusSwitchingProtocols},
+		{"only=binary", http.StatusSwitchingProtocols},
+		{"only=foo", http.StatusBadRequest},
+
+		// close_after
+		{"close_after=1", http.StatusSwitchingProtocols},
+		{"close_after=0", http.StatusBadRequest},
+		{"close_after=foo", http.StatusBadRequest},
+
+		// handshake_delay
+		{"handshake_delay=0", http.StatusSwitchingProtocols},
+		{"handshake_delay=-1s", http.StatusBadRequest},
+		{"handshake_delay=foo", http.StatusBadRequest},
+		{fmt.Sprintf("handshake_delay=%s", app.MaxDuration+time.Second), http.StatusBadRequest},
 	}
 	for _, tc := range paramTests {
 		tc := tc
@@ -3467,8 +7988,294 @@ func TestWebSocketEcho(t *testing.T) {
 	}
 }
 
+func TestWebSocketEchoCloseAfter(t *testing.T) {
+	t.Parallel()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	assert.NilError(t, err)
+	defer conn.Close()
+
+	reqParts := []string{
+		"GET /websocket/echo?close_after=3 HTTP/1.1",
+		"Host: test",
+		"Connection: upgrade",
+		"Upgrade: websocket",
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==",
+		"Sec-WebSocket-Version: 13",
+	}
+	_, err = conn.Write([]byte(strings.Join(reqParts, "\r\n") + "\r\n\r\n"))
+	assert.NilError(t, err)
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	assert.NilError(t, err)
+	assert.StatusCode(t, resp, http.StatusSwitchingProtocols)
+
+	for i := 1; i <= 3; i++ {
+		payload := []byte(fmt.Sprintf("msg %d", i))
+		frame := append([]byte{0x81, 0x80 | byte(len(payload)), 0, 0, 0, 0}, payload...)
+		_, err = conn.Write(frame)
+		assert.NilError(t, err)
+
+		echoed := make([]byte, 2+len(payload))
+		_, err = io.ReadFull(r, echoed)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, echoed, append([]byte{0x81, byte(len(payload))}, payload...), "incorrect echo of message %d", i)
+	}
+
+	// after the 3rd echo, the server sends a normal close frame
+	closeFrame := make([]byte, 4)
+	_, err = io.ReadFull(r, closeFrame)
+	assert.NilError(t, err)
+	assert.Equal(t, closeFrame[0], byte(0x88), "expected final close frame")
+	assert.Equal(t, binary.BigEndian.Uint16(closeFrame[2:]), uint16(1000), "expected normal closure status")
+}
+
+func TestWebSocketEchoOnly(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		only    string
+		opcode  byte
+		allowed bool
+	}{
+		{"text", 0x1, true},
+		{"text", 0x2, false},
+		{"binary", 0x2, true},
+		{"binary", 0x1, false},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("only=%s/opcode=%d", tc.only, tc.opcode), func(t *testing.T) {
+			t.Parallel()
+
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			assert.NilError(t, err)
+			defer conn.Close()
+
+			reqParts := []string{
+				"GET /websocket/echo?only=" + tc.only + " HTTP/1.1",
+				"Host: test",
+				"Connection: upgrade",
+				"Upgrade: websocket",
+				"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==",
+				"Sec-WebSocket-Version: 13",
+			}
+			_, err = conn.Write([]byte(strings.Join(reqParts, "\r\n") + "\r\n\r\n"))
+			assert.NilError(t, err)
+
+			r := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(r, nil)
+			assert.NilError(t, err)
+			assert.StatusCode(t, resp, http.StatusSwitchingProtocols)
+
+			payload := []byte("hello")
+			_, err = conn.Write(append([]byte{0x80 | tc.opcode, 0x80 | byte(len(payload)), 0, 0, 0, 0}, payload...))
+			assert.NilError(t, err)
+
+			header := make([]byte, 2)
+			_, err = io.ReadFull(r, header)
+			assert.NilError(t, err)
+			body := make([]byte, header[1])
+			_, err = io.ReadFull(r, body)
+			assert.NilError(t, err)
+
+			if tc.allowed {
+				assert.Equal(t, header[0], 0x80|tc.opcode, "expected message to be echoed")
+				assert.DeepEqual(t, body, payload, "incorrect echoed payload")
+				return
+			}
+			assert.Equal(t, header[0], byte(0x88), "expected close frame")
+			assert.Equal(t, binary.BigEndian.Uint16(body), uint16(1003), "expected unsupported data status")
+		})
+	}
+}
+
+func TestWebSocketFuzz(t *testing.T) {
+	t.Parallel()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	assert.NilError(t, err)
+	defer conn.Close()
+
+	reqParts := []string{
+		"GET /websocket/fuzz HTTP/1.1",
+		"Host: test",
+		"Connection: upgrade",
+		"Upgrade: websocket",
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==",
+		"Sec-WebSocket-Version: 13",
+	}
+	_, err = conn.Write([]byte(strings.Join(reqParts, "\r\n") + "\r\n\r\n"))
+	assert.NilError(t, err)
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	assert.NilError(t, err)
+	assert.StatusCode(t, resp, http.StatusSwitchingProtocols)
+
+	// an unfragmented message of the maximum body size, which would exceed
+	// the default max_fragment_size of /websocket/echo, is echoed back
+	payload := bytes.Repeat([]byte("x"), int(app.MaxBodySize))
+	frame := []byte{0x82, 0x80 | 126}
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	frame = append(frame, 0, 0, 0, 0)
+	_, err = conn.Write(append(frame, payload...))
+	assert.NilError(t, err)
+
+	header := make([]byte, 4)
+	_, err = io.ReadFull(r, header)
+	assert.NilError(t, err)
+	assert.Equal(t, header[0], byte(0x82), "expected final binary frame")
+	assert.Equal(t, int(binary.BigEndian.Uint16(header[2:])), len(payload), "incorrect echoed payload size")
+	echoed := make([]byte, len(payload))
+	_, err = io.ReadFull(r, echoed)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, echoed, payload, "incorrect echoed payload")
+}
+
+func TestWebSocketMaxConnections(t *testing.T) {
+	t.Parallel()
+
+	maxConns := 2
+	wsSrv, wsClient := newTestServer(New(WithMaxWebSocketConnections(maxConns)))
+	t.Cleanup(wsSrv.Close)
+
+	dialEcho := func(t *testing.T) (net.Conn, *http.Response) {
+		t.Helper()
+		conn, err := net.Dial("tcp", wsSrv.Listener.Addr().String())
+		assert.NilError(t, err)
+
+		reqParts := []string{
+			"GET /websocket/echo HTTP/1.1",
+			"Host: test",
+			"Connection: upgrade",
+			"Upgrade: websocket",
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==",
+			"Sec-WebSocket-Version: 13",
+		}
+		_, err = conn.Write([]byte(strings.Join(reqParts, "\r\n") + "\r\n\r\n"))
+		assert.NilError(t, err)
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		assert.NilError(t, err)
+		return conn, resp
+	}
+
+	conns := make([]net.Conn, 0, maxConns)
+	for i := 0; i < maxConns; i++ {
+		conn, resp := dialEcho(t)
+		conns = append(conns, conn)
+		assert.StatusCode(t, resp, http.StatusSwitchingProtocols)
+	}
+
+	conn, resp := dialEcho(t)
+	conn.Close()
+	assert.StatusCode(t, resp, http.StatusServiceUnavailable)
+
+	// closing a connection frees up a slot, once the server notices
+	conns[0].Close()
+	for i := 0; ; i++ {
+		req, err := http.NewRequest("GET", wsSrv.URL+"/websocket/echo", nil)
+		assert.NilError(t, err)
+		resp := must.DoReq(t, wsClient, req)
+		consumeAndCloseBody(resp)
+		// a slot is available once the handshake is attempted, which fails
+		// because the request is missing the required headers
+		if resp.StatusCode == http.StatusBadRequest {
+			break
+		}
+		if i >= 50 {
+			t.Fatalf("expected websocket slot to be released, got status %d", resp.StatusCode)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, conn := range conns[1:] {
+		conn.Close()
+	}
+}
+
+func TestWebSocketRelay(t *testing.T) {
+	t.Parallel()
+
+	// dialRelay opens a raw connection to the given relay room and completes
+	// the websocket handshake, returning the connection and a reader for its
+	// responses
+	dialRelay := func(t *testing.T, room string) (net.Conn, *bufio.Reader, *http.Response) {
+		t.Helper()
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		assert.NilError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		reqParts := []string{
+			"GET /websocket/relay/" + room + " HTTP/1.1",
+			"Host: test",
+			"Connection: upgrade",
+			"Upgrade: websocket",
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==",
+			"Sec-WebSocket-Version: 13",
+		}
+		_, err = conn.Write([]byte(strings.Join(reqParts, "\r\n") + "\r\n\r\n"))
+		assert.NilError(t, err)
+
+		r := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(r, nil)
+		assert.NilError(t, err)
+		return conn, r, resp
+	}
+
+	t.Run("relays messages to other connections", func(t *testing.T) {
+		t.Parallel()
+
+		connA, _, respA := dialRelay(t, "relay-test")
+		assert.StatusCode(t, respA, http.StatusSwitchingProtocols)
+		connB, rB, respB := dialRelay(t, "relay-test")
+		assert.StatusCode(t, respB, http.StatusSwitchingProtocols)
+
+		// write a single, final text frame with a zero mask, which leaves the
+		// payload unchanged
+		payload := "hello"
+		frame := append([]byte{0x81, 0x80 | byte(len(payload)), 0, 0, 0, 0}, payload...)
+		_, err := connA.Write(frame)
+		assert.NilError(t, err)
+
+		assert.NilError(t, connB.SetReadDeadline(time.Now().Add(time.Second)))
+		header := make([]byte, 2)
+		_, err = io.ReadFull(rB, header)
+		assert.NilError(t, err)
+		got := make([]byte, header[1]&0x7f)
+		_, err = io.ReadFull(rB, got)
+		assert.NilError(t, err)
+		assert.Equal(t, header[0], byte(0x81), "incorrect frame header")
+		assert.Equal(t, string(got), payload, "incorrect relayed message")
+	})
+
+	t.Run("room is full", func(t *testing.T) {
+		t.Parallel()
+
+		for i := 0; i < relayMaxConnsPerRoom; i++ {
+			_, _, resp := dialRelay(t, "relay-full-test")
+			assert.StatusCode(t, resp, http.StatusSwitchingProtocols)
+		}
+		_, _, resp := dialRelay(t, "relay-full-test")
+		assert.StatusCode(t, resp, http.StatusServiceUnavailable)
+	})
+
+	t.Run("handshake failed", func(t *testing.T) {
+		t.Parallel()
+		req := newTestRequest(t, http.MethodGet, "/websocket/relay/relay-bad-handshake")
+		resp, err := client.Do(req)
+		assert.NilError(t, err)
+		assert.StatusCode(t, resp, http.StatusBadRequest)
+	})
+}
+
 func newTestServer(handler http.Handler) (*httptest.Server, *http.Client) {
-	srv := httptest.NewServer(handler)
+	srv := httptest.NewUnstartedServer(handler)
+	if app, ok := handler.(*HTTPBin); ok {
+		srv.Config.ConnContext = app.ConnContext
+	}
+	srv.Start()
 	client := srv.Client()
 	client.Timeout = 5 * time.Second
 	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
@@ -2,22 +2,43 @@ package httpbin
 
 import (
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"container/list"
+	"context"
 	crypto_rand "crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
+	"math"
 	"math/rand"
+	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+
+	"github.com/mccutchen/go-httpbin/v2/httpbin/websocket"
 )
 
 // requestHeaders takes in incoming request and returns an http.Header map
@@ -66,20 +87,43 @@ func getClientIP(r *http.Request) string {
 	return r.RemoteAddr
 }
 
-func getURL(r *http.Request) *url.URL {
-	scheme := r.Header.Get("X-Forwarded-Proto")
-	if scheme == "" {
-		scheme = r.Header.Get("X-Forwarded-Protocol")
+// stripPort removes the port, if any, from the given address.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// takeIfNoneMatch removes and returns the request's If-None-Match header, so
+// that echoing the request's headers does not change the ETag of a response.
+func takeIfNoneMatch(r *http.Request) string {
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	r.Header.Del("If-None-Match")
+	return ifNoneMatch
+}
+
+// getScheme returns the scheme of the request as seen by the client, along
+// with the source it was determined from, which is the name of a lowercased
+// request header, "tls", or "default".
+func getScheme(r *http.Request) (scheme string, source string) {
+	if scheme := r.Header.Get("X-Forwarded-Proto"); scheme != "" {
+		return scheme, "x-forwarded-proto"
 	}
-	if scheme == "" && r.Header.Get("X-Forwarded-Ssl") == "on" {
-		scheme = "https"
+	if scheme := r.Header.Get("X-Forwarded-Protocol"); scheme != "" {
+		return scheme, "x-forwarded-protocol"
 	}
-	if scheme == "" && r.TLS != nil {
-		scheme = "https"
+	if r.Header.Get("X-Forwarded-Ssl") == "on" {
+		return "https", "x-forwarded-ssl"
 	}
-	if scheme == "" {
-		scheme = "http"
+	if r.TLS != nil {
+		return "https", "tls"
 	}
+	return "http", "default"
+}
+
+func getURL(r *http.Request) *url.URL {
+	scheme, _ := getScheme(r)
 
 	host := r.URL.Host
 	if host == "" {
@@ -99,6 +143,108 @@ func getURL(r *http.Request) *url.URL {
 	}
 }
 
+// getTLSServerName returns the server name the client requested via SNI, if
+// the request was made over TLS.
+func getTLSServerName(r *http.Request) string {
+	if r.TLS == nil {
+		return ""
+	}
+	return r.TLS.ServerName
+}
+
+// getRequestLine reconstructs the request line (e.g. "GET /path?q=1
+// HTTP/1.1") as it was sent on the wire.
+func getRequestLine(r *http.Request) string {
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	return fmt.Sprintf("%s %s %s", r.Method, uri, r.Proto)
+}
+
+// receivedAtFormat is RFC3339 with millisecond precision.
+const receivedAtFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// formatReceivedAt formats the time the server began handling a request.
+func formatReceivedAt(t time.Time) string {
+	return t.UTC().Format(receivedAtFormat)
+}
+
+// getConnectionInfo describes the connection the request was received on,
+// whose client IP is that of the immediate peer, ignoring any forwarding
+// headers.
+func getConnectionInfo(r *http.Request) *connectionResponse {
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+	info := &connectionResponse{
+		Proto:    r.Proto,
+		ClientIP: clientIP,
+	}
+	if r.TLS != nil {
+		info.TLSVersion = tls.VersionName(r.TLS.Version)
+		info.TLSCipherSuite = tls.CipherSuiteName(r.TLS.CipherSuite)
+	}
+	return info
+}
+
+// getClientCertChain describes the certificate chain presented by the client
+// over mTLS, which is empty for other connections.
+func getClientCertChain(r *http.Request) *clientCertChainResponse {
+	chain := &clientCertChainResponse{Certificates: []clientCertificate{}}
+	if r.TLS == nil {
+		return chain
+	}
+	for _, cert := range r.TLS.PeerCertificates {
+		chain.Certificates = append(chain.Certificates, clientCertificate{SubjectCN: cert.Subject.CommonName})
+	}
+	chain.Length = len(chain.Certificates)
+	return chain
+}
+
+// getConnectionReused reports whether the request arrived on a connection that
+// had already received another request, or nil if the server does not count
+// requests per connection.
+func getConnectionReused(r *http.Request) *bool {
+	n, ok := r.Context().Value(connRequestNumberKey{}).(int64)
+	if !ok {
+		return nil
+	}
+	reused := n > 1
+	return &reused
+}
+
+// getRawPath returns the request's path as it was sent on the wire, before
+// percent-decoding.
+func getRawPath(r *http.Request) string {
+	if r.URL.RawPath != "" {
+		return r.URL.RawPath
+	}
+	return r.URL.EscapedPath()
+}
+
+// checkStrictQuery returns an error if strict query parsing is enabled via the
+// ?strict_query param and the request's raw query string is malformed, in
+// which case r.URL.Query() would otherwise silently drop the bad params.
+func checkStrictQuery(r *http.Request) error {
+	rawStrict := r.URL.Query().Get("strict_query")
+	if rawStrict == "" {
+		return nil
+	}
+	strict, err := strconv.ParseBool(rawStrict)
+	if err != nil {
+		return fmt.Errorf("invalid strict_query: %w", err)
+	}
+	if !strict {
+		return nil
+	}
+	if _, err := url.ParseQuery(r.URL.RawQuery); err != nil {
+		return fmt.Errorf("invalid query string: %w", err)
+	}
+	return nil
+}
+
 func writeResponse(w http.ResponseWriter, status int, contentType string, body []byte) {
 	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(status)
@@ -106,9 +252,30 @@ func writeResponse(w http.ResponseWriter, status int, contentType string, body [
 }
 
 func mustMarshalJSON(w io.Writer, val interface{}) {
+	mustEncodeJSON(w, val, isCompactJSON(w))
+}
+
+// isCompactJSON reports whether JSON written to w should be compact, i.e.
+// whether w is or wraps a compactJSONResponseWriter.
+func isCompactJSON(w io.Writer) bool {
+	for {
+		switch ww := w.(type) {
+		case *compactJSONResponseWriter:
+			return true
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = ww.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+func mustEncodeJSON(w io.Writer, val interface{}, compact bool) {
 	encoder := json.NewEncoder(w)
 	encoder.SetEscapeHTML(false)
-	encoder.SetIndent("", "  ")
+	if !compact {
+		encoder.SetIndent("", "  ")
+	}
 	if err := encoder.Encode(val); err != nil {
 		panic(err.Error())
 	}
@@ -120,13 +287,73 @@ func writeJSON(status int, w http.ResponseWriter, val interface{}) {
 	mustMarshalJSON(w, val)
 }
 
+// writeJSONWithETag writes val as a JSON response with a strong ETag computed
+// over the encoded body, or responds with 304 Not Modified if a successful
+// response's ETag matches the given If-None-Match header.
+func writeJSONWithETag(status int, w http.ResponseWriter, ifNoneMatch string, val interface{}) {
+	var buf bytes.Buffer
+	mustEncodeJSON(&buf, val, isCompactJSON(w))
+
+	etag := fmt.Sprintf(`"%s"`, sha1hash(buf.String()))
+	w.Header().Set("ETag", etag)
+	if status >= 200 && status < 300 && etagMatches(ifNoneMatch, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeResponse(w, status, jsonContentType, buf.Bytes())
+}
+
+// etagMatches reports whether the given If-None-Match header matches etag,
+// using the weak comparison required by RFC 9110 section 13.1.2.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCompressedJSON writes val as a JSON response body compressed with the
+// given content coding, which must be gzip or deflate, regardless of the
+// request's Accept-Encoding header.
+func (h *HTTPBin) writeCompressedJSON(w http.ResponseWriter, status int, encoding string, level int, val interface{}) {
+	var (
+		buf bytes.Buffer
+		zw  io.WriteCloser
+		err error
+	)
+	switch encoding {
+	case "gzip":
+		zw, err = gzip.NewWriterLevel(&buf, level)
+	case "deflate":
+		zw, err = zlib.NewWriterLevel(&buf, level)
+	default:
+		err = fmt.Errorf("unsupported content encoding: %q", encoding)
+	}
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	mustEncodeJSON(zw, val, isCompactJSON(w))
+	zw.Close()
+
+	body := buf.Bytes()
+	w.Header().Set("Content-Encoding", encoding)
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
 func writeHTML(w http.ResponseWriter, body []byte, status int) {
 	writeResponse(w, status, htmlContentType, body)
 }
 
-func writeError(w http.ResponseWriter, code int, err error) {
+func (h *HTTPBin) writeError(w http.ResponseWriter, code int, err error) {
 	resp := errorRespnose{
-		Error:      http.StatusText(code),
+		Error:      h.statusText(code),
 		StatusCode: code,
 	}
 	if err != nil {
@@ -135,6 +362,15 @@ func writeError(w http.ResponseWriter, code int, err error) {
 	writeJSON(code, w, resp)
 }
 
+// statusText returns the text describing the given status code, preferring
+// any override set via WithStatusTextOverrides.
+func (h *HTTPBin) statusText(code int) string {
+	if text, ok := h.statusTextOverrides[code]; ok {
+		return text
+	}
+	return http.StatusText(code)
+}
+
 // parseFiles handles reading the contents of files in a multipart FileHeader
 // and returning a map that can be used as the Files attribute of a response
 func parseFiles(fileHeaders map[string][]*multipart.FileHeader) (map[string][]string, error) {
@@ -157,42 +393,97 @@ func parseFiles(fileHeaders map[string][]*multipart.FileHeader) (map[string][]st
 	return files, nil
 }
 
+// parseFilesMetadata describes the files uploaded in a multipart request
+// body, ordered by field name and then by their order in the body.
+func parseFilesMetadata(fileHeaders map[string][]*multipart.FileHeader) []fileMetadataResponse {
+	fields := make([]string, 0, len(fileHeaders))
+	for field := range fileHeaders {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+
+	var metadata []fileMetadataResponse
+	for _, field := range fields {
+		for _, f := range fileHeaders[field] {
+			metadata = append(metadata, fileMetadataResponse{
+				Field:       field,
+				Filename:    f.Filename,
+				Size:        f.Size,
+				ContentType: f.Header.Get("Content-Type"),
+			})
+		}
+	}
+	return metadata
+}
+
 // parseBody handles parsing a request body into our standard API response,
 // taking care to only consume the request body once based on the Content-Type
 // of the request. The given bodyResponse will be modified.
 //
+// If allowSemicolons is true, form bodies may use ; as well as & to separate
+// their values, per the legacy behavior of ParseForm.
+//
+// Bodies compressed with a Content-Encoding of gzip or deflate are
+// transparently decoded, and the decoded body is limited to maxBodySize.
+//
 // Note: this function expects callers to limit the the maximum size of the
 // request body. See, e.g., the limitRequestSize middleware.
-func parseBody(r *http.Request, resp *bodyResponse) error {
+func parseBody(r *http.Request, resp *bodyResponse, allowSemicolons bool, maxBodySize int64) error {
 	defer r.Body.Close()
 
 	// Always set resp.Data to the incoming request body, in case we don't know
 	// how to handle the content type
-	body, err := io.ReadAll(r.Body)
+	resp.bodyReadStart = time.Now()
+	bodyReader, err := decodeRequestBody(r.Body, r.Header.Get("Content-Encoding"), maxBodySize)
 	if err != nil {
 		return err
 	}
+	body, err := io.ReadAll(bodyReader)
+	resp.bodyReadEnd = time.Now()
+	if err != nil {
+		return err
+	}
+	resp.rawBody = body
 
 	// After reading the body to populate resp.Data, we need to re-wrap it in
 	// an io.Reader for further processing below
 	r.Body = io.NopCloser(bytes.NewBuffer(body))
 
+	// Surface any content type parameters (e.g. charset, boundary), which
+	// are otherwise discarded below
+	if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && len(params) > 0 {
+		resp.ContentTypeParams = params
+	}
+
 	// if we read an empty body, there's no need to do anything further
 	if len(body) == 0 {
 		return nil
 	}
 
-	// Always store the "raw" incoming request body
-	resp.Data = string(body)
-
 	contentType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
 
+	// Always store the "raw" incoming request body, unless it is not valid
+	// UTF-8 and would be corrupted by JSON encoding, in which case it is
+	// encoded like a binary body instead
+	resp.Data = string(body)
+	if !utf8.Valid(body) {
+		resp.Data = encodeData(body, contentType)
+	}
+
 	switch contentType {
 	case "text/html", "text/plain":
 		// no need for extra parsing, string body is already set above
 		return nil
 
 	case "application/x-www-form-urlencoded":
+		if allowSemicolons {
+			form, err := parseSemicolonValues(string(body))
+			if err != nil {
+				return err
+			}
+			resp.Form = form
+			return nil
+		}
 		// r.ParseForm() does not populate r.PostForm for DELETE or GET
 		// requests, but we need it to for compatibility with the httpbin
 		// implementation, so we trick it with this ugly hack.
@@ -219,6 +510,7 @@ func parseBody(r *http.Request, resp *bodyResponse) error {
 			return err
 		}
 		resp.Files = files
+		resp.FilesMetadata = parseFilesMetadata(r.MultipartForm.File)
 
 	case "application/json":
 		if err := json.NewDecoder(r.Body).Decode(&resp.JSON); err != nil {
@@ -234,6 +526,96 @@ func parseBody(r *http.Request, resp *bodyResponse) error {
 	return nil
 }
 
+// decodeRequestBody wraps a request body compressed with the given
+// Content-Encoding in the appropriate decompressor, limiting the decompressed
+// data to maxSize bytes to guard against decompression bombs. Bodies with
+// other encodings are returned as-is.
+func decodeRequestBody(body io.ReadCloser, encoding string, maxSize int64) (io.Reader, error) {
+	var (
+		decoder io.ReadCloser
+		err     error
+	)
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		decoder, err = gzip.NewReader(body)
+	case "deflate":
+		decoder, err = zlib.NewReader(body)
+	default:
+		return body, nil
+	}
+	if err == io.EOF {
+		// an empty body has nothing to decode
+		return body, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s request body: %w", encoding, err)
+	}
+	return http.MaxBytesReader(nil, decoder, maxSize), nil
+}
+
+// truncatedBodyReader reads at most n bytes of a request body, recording
+// whether any data beyond that limit was discarded.
+type truncatedBodyReader struct {
+	r         io.ReadCloser
+	n         int64
+	truncated bool
+}
+
+func (tr *truncatedBodyReader) Read(p []byte) (int, error) {
+	if tr.n <= 0 {
+		// probe for data beyond the limit, where an error from an
+		// http.MaxBytesReader also indicates that the limit was exceeded
+		var b [1]byte
+		n, err := io.ReadFull(tr.r, b[:])
+		var maxBytesErr *http.MaxBytesError
+		tr.truncated = n > 0 || errors.As(err, &maxBytesErr)
+		return 0, io.EOF
+	}
+	if int64(len(p)) > tr.n {
+		p = p[:tr.n]
+	}
+	n, err := tr.r.Read(p)
+	tr.n -= int64(n)
+	return n, err
+}
+
+func (tr *truncatedBodyReader) Close() error {
+	return tr.r.Close()
+}
+
+// truncateUTF8 truncates s to at most n bytes, without splitting a multi-byte
+// UTF-8 sequence.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// shannonEntropy returns the Shannon entropy of data in bits per byte, ranging
+// from 0 for empty or constant data to 8 for uniformly random data.
+func shannonEntropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	var entropy float64
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(len(data))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
 // return provided string as base64 encoded data url, with the given content type
 func encodeData(body []byte, contentType string) string {
 	// If no content type is provided, default to application/octet-stream
@@ -244,6 +626,16 @@ func encodeData(body []byte, contentType string) string {
 	return string("data:" + contentType + ";base64," + data)
 }
 
+// truncateBodyData encodes the first n bytes of body the same way parseBody
+// encodes a whole body, so that a truncated binary body is still a valid
+// base64 data URL and a truncated text body is still valid UTF-8.
+func truncateBodyData(body []byte, contentType string, n int) string {
+	if !utf8.Valid(body) {
+		return encodeData(body[:n], contentType)
+	}
+	return truncateUTF8(string(body), n)
+}
+
 func parseStatusCode(input string) (int, error) {
 	return parseBoundedStatusCode(input, 100, 599)
 }
@@ -259,6 +651,317 @@ func parseBoundedStatusCode(input string, min, max int) (int, error) {
 	return code, nil
 }
 
+// parseConditionalStatus returns the status code chosen by the ?if_header,
+// ?then_status, and ?else_status query params, where then_status is used if
+// the named request header is present and else_status otherwise. Both
+// default to 200 OK.
+func parseConditionalStatus(r *http.Request) (int, error) {
+	q := r.URL.Query()
+	header := q.Get("if_header")
+	thenStatus, elseStatus := q.Get("then_status"), q.Get("else_status")
+	if header == "" {
+		if thenStatus != "" || elseStatus != "" {
+			return 0, errors.New("then_status and else_status require if_header")
+		}
+		return http.StatusOK, nil
+	}
+
+	rawStatus := elseStatus
+	if _, ok := getRequestHeaders(r, nil)[http.CanonicalHeaderKey(header)]; ok {
+		rawStatus = thenStatus
+	}
+	// validate both, so that errors do not depend on the request headers
+	for _, raw := range []string{thenStatus, elseStatus} {
+		if raw == "" {
+			continue
+		}
+		code, err := parseBoundedStatusCode(raw, 200, 599)
+		if err != nil {
+			return 0, err
+		}
+		// the conditional status replaces the status of a response that
+		// always has a body
+		if !bodyAllowedForStatus(code) {
+			return 0, fmt.Errorf("invalid status code: %d cannot have a response body", code)
+		}
+	}
+	if rawStatus == "" {
+		return http.StatusOK, nil
+	}
+	return strconv.Atoi(rawStatus)
+}
+
+// bodyAllowedForStatus reports whether a response with the given status code
+// may include a body, per RFC 9110.
+func bodyAllowedForStatus(code int) bool {
+	switch {
+	case code >= 100 && code <= 199:
+		return false
+	case code == http.StatusNoContent, code == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// parseCompressionLevel parses a compression level from user input, which must
+// be -1 (default compression) or in the range [1, 9].
+func parseCompressionLevel(input string) (int, error) {
+	level, err := strconv.Atoi(input)
+	if err != nil {
+		return 0, fmt.Errorf("invalid level: %w", err)
+	}
+	if level != gzip.DefaultCompression && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+		return 0, fmt.Errorf("invalid level: %d must be -1 or in range [%d, %d]", level, gzip.BestSpeed, gzip.BestCompression)
+	}
+	return level, nil
+}
+
+// errUnsupportedMediaType is returned when a request's content type does not
+// match the one required via the ?require_content_type query param
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// bodyErrorStatus returns the appropriate HTTP status code for an error
+// encountered while reading a request body.
+func bodyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errBodyReadTimeout):
+		return http.StatusRequestTimeout
+	case errors.Is(err, errUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// endpointTimeout returns the timeout configured for the longest key in
+// timeouts that is equal to path or a parent of it.
+func endpointTimeout(timeouts map[string]time.Duration, path string) (time.Duration, bool) {
+	var (
+		timeout time.Duration
+		longest = -1
+	)
+	for key, d := range timeouts {
+		if len(key) <= longest {
+			continue
+		}
+		if path == key || strings.HasPrefix(path, strings.TrimSuffix(key, "/")+"/") {
+			timeout, longest = d, len(key)
+		}
+	}
+	return timeout, longest >= 0
+}
+
+// writeContextDone responds to a request whose context ended before a
+// response was written: 503 if its endpoint timeout expired, otherwise 499
+// because the client went away.
+func (h *HTTPBin) writeContextDone(w http.ResponseWriter, r *http.Request) {
+	if err := context.Cause(r.Context()); errors.Is(err, errEndpointTimeout) {
+		h.writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	w.WriteHeader(499) // "Client Closed Request" https://httpstatuses.com/499
+}
+
+// checkRequiredContentType returns an error wrapping errUnsupportedMediaType
+// if the ?require_content_type query param is given and the request's media
+// type does not match it. Media type parameters (e.g. charset) are ignored.
+func checkRequiredContentType(r *http.Request) error {
+	required := r.URL.Query().Get("require_content_type")
+	if required == "" {
+		return nil
+	}
+	requiredType, _, err := mime.ParseMediaType(required)
+	if err != nil {
+		return fmt.Errorf("invalid require_content_type: %w", err)
+	}
+	givenType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if givenType != requiredType {
+		return fmt.Errorf("%w: %q, expected %q", errUnsupportedMediaType, givenType, requiredType)
+	}
+	return nil
+}
+
+// newTimingResponse returns a breakdown of the time spent handling a request
+// that started at start, finished at end, and read its body in between
+// bodyReadStart and bodyReadEnd.
+func newTimingResponse(start, bodyReadStart, bodyReadEnd, end time.Time) *timingResponse {
+	toMS := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	bodyRead := bodyReadEnd.Sub(bodyReadStart)
+	total := end.Sub(start)
+	return &timingResponse{
+		BodyReadMS:   toMS(bodyRead),
+		ProcessingMS: toMS(total - bodyRead),
+		TotalMS:      toMS(total),
+	}
+}
+
+// defaultBasicAuthRealm is the realm used in basic auth challenges when no
+// custom realm is requested
+const defaultBasicAuthRealm = "Fake Realm"
+
+// basicAuthChallenge returns a WWW-Authenticate header value challenging the
+// client for basic auth credentials in the given realm, which is escaped as
+// an RFC 9110 quoted-string.
+func basicAuthChallenge(realm string) string {
+	var b strings.Builder
+	b.WriteString(`Basic realm="`)
+	for _, c := range realm {
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// newHARResponse serializes the request that started at the given time, along
+// with its already-parsed body response, as a single-entry HAR log.
+func newHARResponse(r *http.Request, resp *bodyResponse, started time.Time) *harResponse {
+	// sort names so that the output is stable
+	toNameValues := func(values map[string][]string) []harNameValue {
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		result := []harNameValue{}
+		for _, name := range names {
+			for _, value := range values[name] {
+				result = append(result, harNameValue{Name: name, Value: value})
+			}
+		}
+		return result
+	}
+
+	cookies := []harNameValue{}
+	for _, c := range r.Cookies() {
+		cookies = append(cookies, harNameValue{Name: c.Name, Value: c.Value})
+	}
+
+	req := harRequest{
+		Method:      resp.Method,
+		URL:         resp.URL,
+		HTTPVersion: r.Proto,
+		Cookies:     cookies,
+		Headers:     toNameValues(resp.Headers),
+		QueryString: toNameValues(resp.Args),
+		HeadersSize: -1,
+		BodySize:    len(resp.rawBody),
+	}
+	if resp.Data != "" {
+		req.PostData = &harPostData{
+			MimeType: r.Header.Get("Content-Type"),
+			Text:     resp.Data,
+		}
+	}
+
+	return &harResponse{
+		Log: harLog{
+			Version: "1.2",
+			Creator: harCreator{Name: "go-httpbin", Version: "2"},
+			Entries: []harEntry{
+				{
+					StartedDateTime: started.UTC().Format(time.RFC3339Nano),
+					Request:         req,
+				},
+			},
+		},
+	}
+}
+
+// supportedContentEncodings are the content codings the server can produce, in
+// order of preference
+var supportedContentEncodings = []string{"gzip", "deflate", "br", "zstd", "identity"}
+
+// parseAcceptEncoding parses an Accept-Encoding header value into its codings
+// and their q-values, skipping any malformed elements.
+func parseAcceptEncoding(header string) []acceptedEncoding {
+	accepted := []acceptedEncoding{}
+	for _, element := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(element, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		if params != "" {
+			name, value, _ := strings.Cut(strings.TrimSpace(params), "=")
+			if !strings.EqualFold(strings.TrimSpace(name), "q") {
+				continue
+			}
+			var err error
+			q, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		accepted = append(accepted, acceptedEncoding{Coding: coding, Q: q})
+	}
+	return accepted
+}
+
+// negotiateContentEncoding returns the supported content coding most
+// preferred by the given parsed Accept-Encoding values, per RFC 9110 section
+// 12.5.3, or an empty string if none is acceptable.
+func negotiateContentEncoding(accepted []acceptedEncoding) string {
+	// absent an Accept-Encoding header, any coding is acceptable, but we
+	// prefer not to encode
+	if len(accepted) == 0 {
+		return "identity"
+	}
+
+	qvalues := make(map[string]float64, len(accepted))
+	for _, a := range accepted {
+		qvalues[a.Coding] = a.Q
+	}
+
+	chosen := ""
+	chosenQ := 0.0
+	for _, coding := range supportedContentEncodings {
+		q, ok := qvalues[coding]
+		if !ok {
+			q, ok = qvalues["*"]
+		}
+		if ok && q > chosenQ {
+			chosen, chosenQ = coding, q
+		}
+	}
+
+	// identity is acceptable unless explicitly excluded, but is only used as a
+	// fallback when the client did not ask for it
+	if chosen == "" {
+		if _, ok := qvalues["identity"]; !ok {
+			if _, ok := qvalues["*"]; !ok {
+				chosen = "identity"
+			}
+		}
+	}
+	return chosen
+}
+
+// parseSemicolonValues parses URL-encoded values separated by either & or ;,
+// which Go's url.ParseQuery no longer accepts as a separator since Go 1.17.
+func parseSemicolonValues(s string) (url.Values, error) {
+	return url.ParseQuery(strings.ReplaceAll(s, ";", "&"))
+}
+
+// parseBoolParam parses an optional boolean query param, which is false if
+// not given.
+func parseBoolParam(q url.Values, name string) (bool, error) {
+	raw := q.Get(name)
+	if raw == "" {
+		return false, nil
+	}
+	val, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return val, nil
+}
+
 // parseDuration takes a user's input as a string and attempts to convert it
 // into a time.Duration. If not given as a go-style duration string, the input
 // is assumed to be seconds as a float.
@@ -290,6 +993,23 @@ func parseBoundedDuration(input string, min, max time.Duration) (time.Duration,
 	return d, err
 }
 
+// parseDropRate parses the optional ?drop_rate query param, the fraction of
+// streamed chunks to randomly skip writing, which must be in the range [0, 1].
+func parseDropRate(q url.Values) (float64, error) {
+	rawDropRate := q.Get("drop_rate")
+	if rawDropRate == "" {
+		return 0, nil
+	}
+	dropRate, err := strconv.ParseFloat(rawDropRate, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid drop_rate: %w", err)
+	}
+	if dropRate < 0 || dropRate > 1 {
+		return 0, fmt.Errorf("invalid drop_rate: %v not in range [0, 1]", dropRate)
+	}
+	return dropRate, nil
+}
+
 // Returns a new rand.Rand from the given seed string.
 func parseSeed(rawSeed string) (*rand.Rand, error) {
 	var seed int64
@@ -373,9 +1093,112 @@ func (s *syntheticByteStream) Seek(offset int64, whence int) (int64, error) {
 	return s.offset, nil
 }
 
+// newRangeResponse describes how the given Range header would be parsed
+// against a resource of the given size, instead of serving the range.
+func newRangeResponse(header string, size int64) *rangeResponse {
+	resp := &rangeResponse{
+		Header:      header,
+		Size:        size,
+		Satisfiable: true,
+		Ranges:      []byteRangeResponse{},
+	}
+	if header == "" {
+		return resp
+	}
+	ranges, err := parseRangeHeader(header, size)
+	if err != nil {
+		resp.Satisfiable = false
+		resp.Error = err.Error()
+		return resp
+	}
+	resp.Ranges = ranges
+	resp.Satisfiable = slices.ContainsFunc(ranges, func(br byteRangeResponse) bool {
+		return br.Satisfiable
+	})
+	return resp
+}
+
+// parseRangeHeader parses a Range request header of the form
+// "bytes=0-99,200-,-50" against a resource of the given size, following the
+// same rules as http.ServeContent. Syntactically invalid headers return an
+// error, while ranges that do not overlap the resource are reported as
+// unsatisfiable, per RFC 9110 section 14.1.1:
+// https://www.rfc-editor.org/rfc/rfc9110#section-14.1.1
+func parseRangeHeader(header string, size int64) ([]byteRangeResponse, error) {
+	specs, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, errors.New("invalid range: unit must be bytes")
+	}
+	var ranges []byteRangeResponse
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid range %q: missing hyphen", spec)
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+		br := byteRangeResponse{Spec: spec}
+
+		if first == "" {
+			// suffix-range: the final N bytes of the resource
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 || strings.HasPrefix(last, "-") {
+				return nil, fmt.Errorf("invalid range %q: invalid suffix length", spec)
+			}
+			br.Suffix = true
+			if n > 0 && size > 0 {
+				start, end := max(size-n, 0), size-1
+				br.Satisfiable, br.Start, br.End = true, &start, &end
+			}
+			ranges = append(ranges, br)
+			continue
+		}
+
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid range %q: invalid first byte position", spec)
+		}
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid range %q: invalid last byte position", spec)
+			}
+			end = min(end, size-1)
+		}
+		if start < size {
+			br.Satisfiable, br.Start, br.End = true, &start, &end
+		}
+		ranges = append(ranges, br)
+	}
+	if len(ranges) == 0 {
+		return nil, errors.New("invalid range: no range-specs")
+	}
+	return ranges, nil
+}
+
 func sha1hash(input string) string {
-	h := sha1.New()
-	return fmt.Sprintf("%x", h.Sum([]byte(input)))
+	return fmt.Sprintf("%x", sha1.Sum([]byte(input)))
+}
+
+// The hash algorithms supported for the Content-Digest header, keyed by their
+// names in the IANA Hash Algorithms for HTTP Digest Fields registry
+var contentDigestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+const defaultContentDigestAlgorithm = "sha-256"
+
+// encodeContentDigest returns an RFC 9530 Content-Digest header value for the
+// given body using the given algorithm, e.g. sha-256=:<base64 digest>:
+func encodeContentDigest(algorithm string, body []byte) string {
+	h := contentDigestAlgorithms[algorithm]()
+	h.Write(body)
+	return fmt.Sprintf("%s=:%s:", algorithm, base64.StdEncoding.EncodeToString(h.Sum(nil)))
 }
 
 func uuidv4() string {
@@ -485,6 +1308,21 @@ func createExcludeHeadersProcessor(excludeRegex *regexp.Regexp) headersProcessor
 	}
 }
 
+// createIncludeHeadersPrefixProcessor returns a headersProcessorFunc that
+// keeps only headers whose names start with the given case-insensitive prefix
+func createIncludeHeadersPrefixProcessor(prefix string) headersProcessorFunc {
+	prefix = strings.ToLower(prefix)
+	return func(headers http.Header) http.Header {
+		result := make(http.Header)
+		for k, v := range headers {
+			if strings.HasPrefix(strings.ToLower(k), prefix) {
+				result[k] = v
+			}
+		}
+		return result
+	}
+}
+
 func createFullExcludeRegex(excludeHeaders string) *regexp.Regexp {
 	// comma separated list of headers to exclude from response
 	tmp := strings.Split(excludeHeaders, ",")
@@ -564,6 +1402,617 @@ func weightedRandomChoice[T any](choices []weightedChoice[T]) T {
 	panic("failed to select a weighted random choice")
 }
 
+// hashHeaders returns the hex-encoded SHA-256 hash of a canonical form of the
+// given headers: one "name:values" line per header, where names are
+// lowercased and sorted, values are joined with "," in their original order,
+// and each line ends with "\n".
+func hashHeaders(headers http.Header) string {
+	canonical := make(map[string]string, len(headers))
+	names := make([]string, 0, len(headers))
+	for name, values := range headers {
+		name = strings.ToLower(name)
+		canonical[name] = strings.Join(values, ",")
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s:%s\n", name, canonical[name])
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// newAuthSchemeResponse describes the scheme of the given Authorization header
+// value and whether it carries a credential, without revealing the credential
+// itself. It returns nil if the header is empty.
+func newAuthSchemeResponse(authorization string) *authSchemeResponse {
+	authorization = strings.TrimSpace(authorization)
+	if authorization == "" {
+		return nil
+	}
+	scheme, credential, _ := strings.Cut(authorization, " ")
+	return &authSchemeResponse{
+		Scheme:            scheme,
+		CredentialPresent: strings.TrimSpace(credential) != "",
+	}
+}
+
+// decodeBearerJWT decodes, but does not verify, the header and claims of a JWT
+// given as a bearer token in an Authorization header value.
+func decodeBearerJWT(authorization string) (*jwtResponse, error) {
+	fields := strings.Fields(authorization)
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "Bearer") {
+		return nil, errors.New("missing bearer token")
+	}
+	segments := strings.Split(fields[1], ".")
+	if len(segments) != 3 {
+		return nil, fmt.Errorf("malformed JWT: expected 3 segments, got %d", len(segments))
+	}
+	header, err := decodeJWTSegment(segments[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed JWT header: %w", err)
+	}
+	claims, err := decodeJWTSegment(segments[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed JWT claims: %w", err)
+	}
+	return &jwtResponse{Header: header, Claims: claims}, nil
+}
+
+// decodeJWTSegment decodes a single base64url-encoded JSON object segment of a
+// JWT.
+func decodeJWTSegment(segment string) (map[string]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// jsonSchemaURL identifies user-supplied JSON Schemas, which must not be
+// resolved against the local filesystem
+const jsonSchemaURL = "urn:go-httpbin:schema"
+
+// compileJSONSchema compiles the given JSON Schema document. Any $ref to an
+// external resource is rejected rather than loaded from the filesystem or
+// network.
+func compileJSONSchema(rawSchema []byte) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(rawSchema))
+	if err != nil {
+		return nil, err
+	}
+	c := jsonschema.NewCompiler()
+	c.UseLoader(jsonschema.SchemeURLLoader{})
+	if err := c.AddResource(jsonSchemaURL, doc); err != nil {
+		return nil, err
+	}
+	return c.Compile(jsonSchemaURL)
+}
+
+// jsonValidationErrors flattens the error returned when validating a
+// document against a JSON Schema into a list of errors, in the JSON Schema
+// "basic" output format.
+func jsonValidationErrors(err error) []jsonValidationError {
+	errs := []jsonValidationError{}
+	var validationErr *jsonschema.ValidationError
+	if errors.As(err, &validationErr) {
+		for _, unit := range validationErr.BasicOutput().Errors {
+			if unit.Error != nil {
+				errs = append(errs, jsonValidationError{Path: unit.InstanceLocation, Message: unit.Error.String()})
+			}
+		}
+	} else if err != nil {
+		errs = append(errs, jsonValidationError{Message: err.Error()})
+	}
+	return errs
+}
+
+// boundedStore is a concurrency-safe map of values keyed by string, bounded
+// both by its number of keys and by the total size of its values. Once either
+// bound would be exceeded, the least recently updated keys are evicted.
+type boundedStore[V any] struct {
+	mu       sync.Mutex
+	maxKeys  int
+	maxBytes int64 // zero means the store is bounded by its keys alone
+	sizeOf   func(V) int64
+	size     int64
+	entries  map[string]*list.Element
+	order    *list.List // of *boundedStoreEntry[V], least recently updated first
+}
+
+type boundedStoreEntry[V any] struct {
+	key   string
+	value V
+	size  int64
+}
+
+// newBoundedStore creates a boundedStore holding at most maxKeys keys and, if
+// maxBytes is positive, values whose sizes as reported by sizeOf total at
+// most maxBytes.
+func newBoundedStore[V any](maxKeys int, maxBytes int64, sizeOf func(V) int64) *boundedStore[V] {
+	return &boundedStore[V]{
+		maxKeys:  maxKeys,
+		maxBytes: maxBytes,
+		sizeOf:   sizeOf,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the value stored for the given key, if any.
+func (s *boundedStore[V]) Get(key string) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		return el.Value.(*boundedStoreEntry[V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Set stores the value for the given key.
+func (s *boundedStore[V]) Set(key string, value V) {
+	s.Update(key, func(V, bool) (V, bool) {
+		return value, true
+	})
+}
+
+// Update atomically replaces the value stored for the given key with the
+// result of fn, which is given the current value and whether there is one.
+// If fn returns false, the key is removed instead.
+//
+// The key becomes the most recently updated, evicting others as necessary to
+// stay within the store's bounds. A value too large to ever fit within the
+// size bound is not stored.
+func (s *boundedStore[V]) Update(key string, fn func(value V, ok bool) (V, bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var current V
+	el, ok := s.entries[key]
+	if ok {
+		current = el.Value.(*boundedStoreEntry[V]).value
+		s.remove(el)
+	}
+	value, keep := fn(current, ok)
+	if !keep {
+		return
+	}
+
+	var size int64
+	if s.sizeOf != nil {
+		size = s.sizeOf(value)
+	}
+	if s.maxBytes > 0 && size > s.maxBytes {
+		return
+	}
+	for s.order.Len() > 0 && (s.order.Len() >= s.maxKeys || (s.maxBytes > 0 && s.size+size > s.maxBytes)) {
+		s.remove(s.order.Front())
+	}
+	s.entries[key] = s.order.PushBack(&boundedStoreEntry[V]{key: key, value: value, size: size})
+	s.size += size
+}
+
+// Len returns the number of keys stored.
+func (s *boundedStore[V]) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *boundedStore[V]) remove(el *list.Element) {
+	entry := s.order.Remove(el).(*boundedStoreEntry[V])
+	delete(s.entries, entry.key)
+	s.size -= entry.size
+}
+
+// Bounds on the responses retained by an idempotencyCache
+const (
+	idempotencyCacheMaxEntries       = 1024
+	idempotencyCacheMaxBytes   int64 = 16 * 1024 * 1024
+)
+
+// idempotencyCache stores responses keyed by idempotency key for a limited
+// time, so that repeated requests may be answered with the original response.
+type idempotencyCache struct {
+	ttl   time.Duration
+	store *boundedStore[*idempotencyCacheEntry]
+}
+
+type idempotencyCacheEntry struct {
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// size returns the approximate number of bytes retained by the entry.
+func (e *idempotencyCacheEntry) size() int64 {
+	return int64(len(e.body)) + headerSize(e.header)
+}
+
+// headerSize returns the total length of the given header's names and values.
+func headerSize(h http.Header) int64 {
+	var n int64
+	for k, vs := range h {
+		n += int64(len(k))
+		for _, v := range vs {
+			n += int64(len(v))
+		}
+	}
+	return n
+}
+
+func newIdempotencyCache(ttl time.Duration, maxEntries int, maxBytes int64) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:   ttl,
+		store: newBoundedStore(maxEntries, maxBytes, (*idempotencyCacheEntry).size),
+	}
+}
+
+// Get returns the unexpired entry for the given key, if any.
+func (c *idempotencyCache) Get(key string) (*idempotencyCacheEntry, bool) {
+	entry, ok := c.store.Get(key)
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// Set stores an entry for the given key, evicting the oldest entries if the
+// cache is full.
+func (c *idempotencyCache) Set(key string, entry *idempotencyCacheEntry) {
+	entry.expires = time.Now().Add(c.ttl)
+	c.store.Set(key, entry)
+}
+
+// Bounds on the state retained by the keyedCounters backing the /flaky and
+// /status/sequence endpoints
+const (
+	keyedCounterTTL     = 5 * time.Minute
+	keyedCounterMaxKeys = 1024
+)
+
+// keyedCounter counts requests per key, e.g. so that the /flaky endpoint can
+// fail deterministically until a given number of attempts have been made, or
+// so that the /status/sequence endpoint can step through its codes. A key's
+// count resets once it has gone unused for the TTL.
+type keyedCounter struct {
+	ttl   time.Duration
+	store *boundedStore[keyedCounterEntry]
+}
+
+type keyedCounterEntry struct {
+	count   int
+	updated time.Time
+}
+
+func newKeyedCounter(ttl time.Duration, maxKeys int) *keyedCounter {
+	return &keyedCounter{
+		ttl:   ttl,
+		store: newBoundedStore[keyedCounterEntry](maxKeys, 0, nil),
+	}
+}
+
+// Increment records an attempt for the given key at the given time, returning
+// the 1-based number of attempts made within the TTL.
+func (c *keyedCounter) Increment(key string, now time.Time) int {
+	var count int
+	c.store.Update(key, func(entry keyedCounterEntry, ok bool) (keyedCounterEntry, bool) {
+		if !ok || entry.updated.Before(now.Add(-c.ttl)) {
+			entry = keyedCounterEntry{}
+		}
+		entry.count++
+		entry.updated = now
+		count = entry.count
+		return entry, true
+	})
+	return count
+}
+
+// Bounds on the requests retained by the /diff endpoint's diffStore
+const (
+	diffStoreTTL            = 5 * time.Minute
+	diffStoreMaxKeys        = 1024
+	diffStoreMaxBytes int64 = 16 * 1024 * 1024
+)
+
+// requestSignature captures the parts of a request compared by /diff.
+type requestSignature struct {
+	method  string
+	url     string
+	headers http.Header
+	body    string
+}
+
+// size returns the approximate number of bytes retained by the signature.
+func (sig *requestSignature) size() int64 {
+	return int64(len(sig.method)+len(sig.url)+len(sig.body)) + headerSize(sig.headers)
+}
+
+// diff reports the differences between two request signatures.
+func (first *requestSignature) diff(second *requestSignature) *diffResponse {
+	resp := &diffResponse{}
+	if first.method != second.method {
+		resp.Method = &diffValue[string]{first.method, second.method}
+	}
+	if first.url != second.url {
+		resp.URL = &diffValue[string]{first.url, second.url}
+	}
+	if first.body != second.body {
+		resp.Body = &diffValue[string]{first.body, second.body}
+	}
+	for name := range first.headers {
+		if !slices.Equal(first.headers[name], second.headers[name]) {
+			if resp.Headers == nil {
+				resp.Headers = make(map[string]diffValue[[]string])
+			}
+			resp.Headers[name] = diffValue[[]string]{first.headers[name], second.headers[name]}
+		}
+	}
+	for name := range second.headers {
+		if _, ok := first.headers[name]; !ok {
+			if resp.Headers == nil {
+				resp.Headers = make(map[string]diffValue[[]string])
+			}
+			resp.Headers[name] = diffValue[[]string]{nil, second.headers[name]}
+		}
+	}
+	resp.Identical = resp.Method == nil && resp.URL == nil && resp.Body == nil && resp.Headers == nil
+	return resp
+}
+
+// diffStore holds the first request signature per key for the /diff
+// endpoint, until the second request with the same key arrives or the TTL
+// expires.
+type diffStore struct {
+	ttl   time.Duration
+	store *boundedStore[diffEntry]
+}
+
+type diffEntry struct {
+	sig     *requestSignature
+	expires time.Time
+}
+
+func newDiffStore(ttl time.Duration, maxKeys int, maxBytes int64) *diffStore {
+	return &diffStore{
+		ttl: ttl,
+		store: newBoundedStore(maxKeys, maxBytes, func(entry diffEntry) int64 {
+			return entry.sig.size()
+		}),
+	}
+}
+
+// Swap returns and removes the unexpired signature stored for the given key,
+// if any. Otherwise, it stores the given signature for the key.
+func (s *diffStore) Swap(key string, sig *requestSignature, now time.Time) (*requestSignature, bool) {
+	var first *requestSignature
+	s.store.Update(key, func(entry diffEntry, ok bool) (diffEntry, bool) {
+		if ok && now.Before(entry.expires) {
+			first = entry.sig
+			return entry, false
+		}
+		return diffEntry{sig: sig, expires: now.Add(s.ttl)}, true
+	})
+	return first, first != nil
+}
+
+// Bounds on the connections tracked by the /websocket/relay endpoint's
+// relayRooms
+const (
+	relayMaxRooms        = 1024
+	relayMaxConnsPerRoom = 8
+
+	// messages beyond this many queued for a single peer are dropped, so
+	// that a slow peer cannot stall the rest of its room
+	relayPeerQueueSize = 16
+)
+
+var (
+	errRelayRoomFull = errors.New("relay room is full")
+	errRelayTooMany  = errors.New("too many relay rooms")
+)
+
+// relayPeer is a connection joined to a relay room. Messages broadcast to it
+// are queued and delivered by its own goroutine, via deliver.
+type relayPeer struct {
+	ws    *websocket.WebSocket
+	queue chan *websocket.Message
+
+	// set once the peer's handshake has completed, before which nothing is
+	// queued for it
+	ready atomic.Bool
+}
+
+// deliver sends each queued message to the peer until it leaves its room.
+// Failed sends are ignored, since the failing connection will leave the room
+// on its own.
+func (p *relayPeer) deliver() {
+	for msg := range p.queue {
+		_ = p.ws.Send(msg)
+	}
+}
+
+// relayRooms tracks the websocket connections joined to each named room of
+// the /websocket/relay endpoint, so that messages from one connection can be
+// broadcast to the others. Empty rooms are removed.
+type relayRooms struct {
+	mu       sync.Mutex
+	maxRooms int
+	maxConns int
+	rooms    map[string]map[*websocket.WebSocket]*relayPeer
+}
+
+func newRelayRooms(maxRooms, maxConns int) *relayRooms {
+	return &relayRooms{
+		maxRooms: maxRooms,
+		maxConns: maxConns,
+		rooms:    make(map[string]map[*websocket.WebSocket]*relayPeer),
+	}
+}
+
+// Join adds a connection to the given room, returning an error if the room
+// is full or no more rooms may be created. The returned peer receives no
+// messages until it is marked ready.
+func (rr *relayRooms) Join(room string, ws *websocket.WebSocket) (*relayPeer, error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	peers, ok := rr.rooms[room]
+	if !ok {
+		if len(rr.rooms) >= rr.maxRooms {
+			return nil, errRelayTooMany
+		}
+		peers = make(map[*websocket.WebSocket]*relayPeer)
+		rr.rooms[room] = peers
+	}
+	if len(peers) >= rr.maxConns {
+		return nil, errRelayRoomFull
+	}
+	peer := &relayPeer{ws: ws, queue: make(chan *websocket.Message, relayPeerQueueSize)}
+	peers[ws] = peer
+	return peer, nil
+}
+
+// Leave removes a connection from the given room, stopping delivery to it
+// and removing the room itself once it is empty.
+func (rr *relayRooms) Leave(room string, ws *websocket.WebSocket) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if peer, ok := rr.rooms[room][ws]; ok {
+		close(peer.queue)
+		delete(rr.rooms[room], ws)
+	}
+	if len(rr.rooms[room]) == 0 {
+		delete(rr.rooms, room)
+	}
+}
+
+// Broadcast queues a message for every ready connection in the given room
+// other than the sender, without waiting for it to be delivered. Peers whose
+// queues are full miss the message.
+func (rr *relayRooms) Broadcast(room string, from *websocket.WebSocket, msg *websocket.Message) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	for ws, peer := range rr.rooms[room] {
+		if ws == from || !peer.ready.Load() {
+			continue
+		}
+		select {
+		case peer.queue <- msg:
+		default:
+		}
+	}
+}
+
+// Bounds on the state retained by the /burst endpoint's burstTracker
+const (
+	burstTrackerTTL           = time.Minute
+	burstTrackerMaxKeys       = 1024
+	burstTrackerMaxTimestamps = 100
+)
+
+// memStatsInterval is the minimum time between reads of runtime memory stats
+// for the /loadinfo endpoint, since runtime.ReadMemStats briefly stops the
+// world.
+const memStatsInterval = time.Second
+
+// loadTracker tracks in-flight and total requests served, along with
+// periodically refreshed runtime memory stats, for the /loadinfo endpoint.
+type loadTracker struct {
+	inFlight atomic.Int64
+	total    atomic.Int64
+
+	mu           sync.Mutex
+	memStats     runtime.MemStats
+	memStatsRead time.Time
+}
+
+// HeapAlloc returns the heap allocation in bytes as of the most recent read
+// of runtime memory stats, refreshing them if they are older than
+// memStatsInterval.
+func (t *loadTracker) HeapAlloc(now time.Time) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.memStatsRead) >= memStatsInterval {
+		runtime.ReadMemStats(&t.memStats)
+		t.memStatsRead = now
+	}
+	return t.memStats.HeapAlloc
+}
+
+// burstTracker records recent request arrival times per key, so that the
+// gaps between requests in a burst may be reported.
+type burstTracker struct {
+	ttl           time.Duration
+	maxTimestamps int
+	store         *boundedStore[[]time.Time]
+}
+
+func newBurstTracker(ttl time.Duration, maxKeys int, maxTimestamps int) *burstTracker {
+	return &burstTracker{
+		ttl:           ttl,
+		maxTimestamps: maxTimestamps,
+		store:         newBoundedStore[[]time.Time](maxKeys, 0, nil),
+	}
+}
+
+// Record records an arrival for the given key at the given time, returning a
+// copy of the key's arrival times within the TTL, oldest first.
+func (t *burstTracker) Record(key string, now time.Time) []time.Time {
+	var result []time.Time
+	t.store.Update(key, func(timestamps []time.Time, _ bool) ([]time.Time, bool) {
+		cutoff := now.Add(-t.ttl)
+		timestamps = slices.DeleteFunc(timestamps, func(ts time.Time) bool {
+			return ts.Before(cutoff)
+		})
+		timestamps = append(timestamps, now)
+		if len(timestamps) > t.maxTimestamps {
+			timestamps = timestamps[len(timestamps)-t.maxTimestamps:]
+		}
+		result = slices.Clone(timestamps)
+		return timestamps, true
+	})
+	return result
+}
+
+// newBurstResponse summarizes the gaps between the given arrival times.
+func newBurstResponse(key string, timestamps []time.Time) *burstResponse {
+	resp := &burstResponse{
+		Key:   key,
+		Count: len(timestamps),
+	}
+	if len(timestamps) < 2 {
+		return resp
+	}
+
+	toMS := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	var total, minGap, maxGap time.Duration
+	for i := 1; i < len(timestamps); i++ {
+		gap := timestamps[i].Sub(timestamps[i-1])
+		if i == 1 || gap < minGap {
+			minGap = gap
+		}
+		if gap > maxGap {
+			maxGap = gap
+		}
+		total += gap
+	}
+	resp.MinGapMS = toMS(minGap)
+	resp.MaxGapMS = toMS(maxGap)
+	resp.MeanGapMS = toMS(total / time.Duration(len(timestamps)-1))
+	return resp
+}
+
 // Server-Timing header/trailer helpers. See MDN docs for reference:
 // https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Server-Timing
 type serverTiming struct {
@@ -580,3 +2029,113 @@ func encodeServerTimings(timings []serverTiming) string {
 	}
 	return strings.Join(entries, ", ")
 }
+
+// parseRawCookies parses the given Cookie header values, preserving the
+// $-prefixed attributes that legacy RFC 2965 clients send after each cookie
+// (e.g. $Path, $Domain, $Port), and the $Version that precedes them all.
+//
+// https://datatracker.ietf.org/doc/html/rfc2965#section-3.3.4
+func parseRawCookies(headers []string) rawCookiesResponse {
+	resp := rawCookiesResponse{Cookies: []rawCookie{}}
+	for _, header := range headers {
+		for _, part := range strings.Split(header, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			name = strings.TrimSpace(name)
+			value = strings.TrimSpace(value)
+			if name == "" {
+				continue
+			}
+			if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+				value = value[1 : len(value)-1]
+			}
+
+			if attr, ok := strings.CutPrefix(name, "$"); ok {
+				switch {
+				case strings.EqualFold(attr, "Version") && len(resp.Cookies) == 0:
+					resp.Version = value
+				case len(resp.Cookies) > 0:
+					cookie := &resp.Cookies[len(resp.Cookies)-1]
+					if cookie.Attributes == nil {
+						cookie.Attributes = make(map[string]string)
+					}
+					cookie.Attributes[attr] = value
+				}
+				continue
+			}
+			resp.Cookies = append(resp.Cookies, rawCookie{Name: name, Value: value})
+		}
+	}
+	return resp
+}
+
+// padImage pads an image of the given kind to approximately size bytes, using
+// metadata ignored by decoders: a tEXt chunk for PNG, COM segments for JPEG,
+// and a trailing comment for SVG. Other kinds of images, and images already at
+// least size bytes, are returned unchanged.
+func padImage(kind string, img []byte, size int) []byte {
+	padding := size - len(img)
+	switch kind {
+	case "png":
+		// a tEXt chunk has a 4 byte length, 4 byte type, 4 byte CRC, and a
+		// null-terminated keyword preceding its text
+		const keyword = "Comment\x00"
+		padding = nearestPadding(padding, 12+len(keyword))
+		if padding == 0 {
+			return img
+		}
+		data := append([]byte("tEXt"+keyword), bytes.Repeat([]byte("x"), padding-12-len(keyword))...)
+		chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)-4))
+		chunk = append(chunk, data...)
+		chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(data))
+
+		// insert the chunk before the final IEND chunk
+		iend := len(img) - 12
+		return slices.Concat(img[:iend], chunk, img[iend:])
+	case "jpeg":
+		// a COM segment has a 2 byte marker and a 2 byte length, and its
+		// length may not exceed 65535 bytes, so large paddings are split
+		// across multiple segments
+		const overhead, maxSegment = 4, 65535 + 2
+		padding = nearestPadding(padding, overhead)
+		if padding == 0 {
+			return img
+		}
+		var segments []byte
+		for padding > 0 {
+			n := min(padding, maxSegment)
+			if rem := padding - n; rem > 0 && rem < overhead {
+				n -= overhead
+			}
+			segments = append(segments, 0xFF, 0xFE)
+			segments = binary.BigEndian.AppendUint16(segments, uint16(n-2))
+			segments = append(segments, bytes.Repeat([]byte("x"), n-overhead)...)
+			padding -= n
+		}
+
+		// insert the segments after the SOI marker
+		return slices.Concat(img[:2], segments, img[2:])
+	case "svg":
+		const prefix, suffix = "<!--", "-->"
+		padding = nearestPadding(padding, len(prefix)+len(suffix))
+		if padding == 0 {
+			return img
+		}
+		comment := prefix + strings.Repeat("x", padding-len(prefix)-len(suffix)) + suffix
+		return slices.Concat(img, []byte(comment))
+	default:
+		return img
+	}
+}
+
+// nearestPadding returns the achievable padding closest to the desired
+// padding, given that any padding must be at least minimum bytes.
+func nearestPadding(desired int, minimum int) int {
+	switch {
+	case desired >= minimum:
+		return desired
+	case desired*2 >= minimum:
+		return minimum
+	default:
+		return 0
+	}
+}
@@ -1,6 +1,7 @@
 package httpbin
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"io/fs"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"regexp"
 	"strconv"
@@ -15,6 +17,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/mccutchen/go-httpbin/v2/httpbin/websocket"
 	"github.com/mccutchen/go-httpbin/v2/internal/testing/assert"
 )
 
@@ -493,6 +496,87 @@ func TestParseWeightedChoices(t *testing.T) {
 	}
 }
 
+func TestNegotiateContentEncoding(t *testing.T) {
+	testCases := []struct {
+		given        string
+		wantAccepted []acceptedEncoding
+		wantChosen   string
+	}{
+		{
+			given:        "",
+			wantAccepted: []acceptedEncoding{},
+			wantChosen:   "identity",
+		},
+		{
+			given:        "gzip",
+			wantAccepted: []acceptedEncoding{{"gzip", 1}},
+			wantChosen:   "gzip",
+		},
+		{
+			given:        "deflate, gzip;q=0.5",
+			wantAccepted: []acceptedEncoding{{"deflate", 1}, {"gzip", 0.5}},
+			wantChosen:   "deflate",
+		},
+		{
+			// ties are broken by server preference
+			given:        "deflate;q=0.8, GZIP;q=0.8",
+			wantAccepted: []acceptedEncoding{{"deflate", 0.8}, {"gzip", 0.8}},
+			wantChosen:   "gzip",
+		},
+		{
+			given:        "br",
+			wantAccepted: []acceptedEncoding{{"br", 1}},
+			wantChosen:   "br",
+		},
+		{
+			given:        "zstd, br;q=0.5",
+			wantAccepted: []acceptedEncoding{{"zstd", 1}, {"br", 0.5}},
+			wantChosen:   "zstd",
+		},
+		{
+			given:        "compress",
+			wantAccepted: []acceptedEncoding{{"compress", 1}},
+			wantChosen:   "identity",
+		},
+		{
+			given:        "*",
+			wantAccepted: []acceptedEncoding{{"*", 1}},
+			wantChosen:   "gzip",
+		},
+		{
+			given:        "gzip;q=0, *;q=0.5",
+			wantAccepted: []acceptedEncoding{{"gzip", 0}, {"*", 0.5}},
+			wantChosen:   "deflate",
+		},
+		{
+			given:        "identity;q=0",
+			wantAccepted: []acceptedEncoding{{"identity", 0}},
+			wantChosen:   "",
+		},
+		{
+			given:        "compress, *;q=0",
+			wantAccepted: []acceptedEncoding{{"compress", 1}, {"*", 0}},
+			wantChosen:   "",
+		},
+		{
+			// malformed elements are skipped
+			given:        "gzip;q=foo, deflate;q=2, br;level=1, identity;q=0.1",
+			wantAccepted: []acceptedEncoding{{"identity", 0.1}},
+			wantChosen:   "identity",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.given, func(t *testing.T) {
+			t.Parallel()
+			accepted := parseAcceptEncoding(tc.given)
+			assert.DeepEqual(t, accepted, tc.wantAccepted, "incorrect parsed Accept-Encoding")
+			assert.Equal(t, negotiateContentEncoding(accepted), tc.wantChosen, "incorrect chosen encoding")
+		})
+	}
+}
+
 func TestWeightedRandomChoice(t *testing.T) {
 	iters := 1_000
 	testCases := []string{
@@ -572,3 +656,321 @@ func decodeServerTimings(headerVal string) map[string]serverTiming {
 	}
 	return timings
 }
+
+func TestIdempotencyCache(t *testing.T) {
+	t.Run("evicts oldest entries", func(t *testing.T) {
+		t.Parallel()
+		c := newIdempotencyCache(time.Minute, 2, 0)
+		c.Set("a", &idempotencyCacheEntry{status: 200})
+		c.Set("b", &idempotencyCacheEntry{status: 201})
+		c.Set("a", &idempotencyCacheEntry{status: 202}) // refreshes "a"
+		c.Set("c", &idempotencyCacheEntry{status: 203}) // evicts "b"
+
+		_, ok := c.Get("b")
+		assert.Equal(t, ok, false, "expected b to be evicted")
+		entry, ok := c.Get("a")
+		assert.Equal(t, ok, true, "expected a to be cached")
+		assert.Equal(t, entry.status, 202, "expected a to be updated")
+		_, ok = c.Get("c")
+		assert.Equal(t, ok, true, "expected c to be cached")
+		assert.Equal(t, c.store.Len(), 2, "expected cache to be bounded")
+	})
+
+	t.Run("evicts oldest entries beyond byte budget", func(t *testing.T) {
+		t.Parallel()
+		c := newIdempotencyCache(time.Minute, 10, 100)
+		c.Set("a", &idempotencyCacheEntry{status: 200, body: make([]byte, 40)})
+		c.Set("b", &idempotencyCacheEntry{status: 200, body: make([]byte, 40)})
+		c.Set("c", &idempotencyCacheEntry{status: 200, body: make([]byte, 40)})  // evicts "a"
+		c.Set("d", &idempotencyCacheEntry{status: 200, body: make([]byte, 101)}) // too large to cache
+
+		_, ok := c.Get("a")
+		assert.Equal(t, ok, false, "expected a to be evicted")
+		_, ok = c.Get("d")
+		assert.Equal(t, ok, false, "expected d not to be cached")
+		assert.Equal(t, c.store.Len(), 2, "expected b and c to be cached")
+	})
+
+	t.Run("entries expire", func(t *testing.T) {
+		t.Parallel()
+		c := newIdempotencyCache(time.Millisecond, 2, 0)
+		c.Set("a", &idempotencyCacheEntry{status: 200})
+		time.Sleep(5 * time.Millisecond)
+		_, ok := c.Get("a")
+		assert.Equal(t, ok, false, "expected a to be expired")
+	})
+}
+
+func TestBoundedStore(t *testing.T) {
+	t.Parallel()
+
+	sizeOf := func(s string) int64 { return int64(len(s)) }
+
+	t.Run("evicts least recently updated keys", func(t *testing.T) {
+		t.Parallel()
+		s := newBoundedStore(2, 0, sizeOf)
+		s.Set("a", "1")
+		s.Set("b", "2")
+		s.Set("a", "3") // refreshes "a"
+		s.Set("c", "4") // evicts "b"
+
+		_, ok := s.Get("b")
+		assert.Equal(t, ok, false, "expected b to be evicted")
+		v, ok := s.Get("a")
+		assert.Equal(t, ok, true, "expected a to be stored")
+		assert.Equal(t, v, "3", "expected a to be updated")
+		assert.Equal(t, s.Len(), 2, "expected store to be bounded")
+	})
+
+	t.Run("evicts keys beyond byte budget", func(t *testing.T) {
+		t.Parallel()
+		s := newBoundedStore(10, 10, sizeOf)
+		s.Set("a", "aaaa")
+		s.Set("b", "bbbb")
+		s.Set("c", "cccc") // evicts "a"
+		s.Set("d", "ddddddddddd")
+
+		_, ok := s.Get("a")
+		assert.Equal(t, ok, false, "expected a to be evicted")
+		_, ok = s.Get("d")
+		assert.Equal(t, ok, false, "expected d to be too large to store")
+		assert.Equal(t, s.Len(), 2, "expected b and c to be stored")
+		assert.Equal(t, s.size, int64(8), "incorrect total size")
+	})
+
+	t.Run("update may remove keys", func(t *testing.T) {
+		t.Parallel()
+		s := newBoundedStore(10, 10, sizeOf)
+		s.Set("a", "aaaa")
+		s.Update("a", func(v string, ok bool) (string, bool) {
+			assert.Equal(t, ok, true, "expected a to be stored")
+			assert.Equal(t, v, "aaaa", "incorrect current value")
+			return "", false
+		})
+		_, ok := s.Get("a")
+		assert.Equal(t, ok, false, "expected a to be removed")
+		assert.Equal(t, s.size, int64(0), "incorrect total size")
+	})
+}
+
+func TestBurstTracker(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	t.Run("expires old timestamps", func(t *testing.T) {
+		t.Parallel()
+		tracker := newBurstTracker(100*time.Millisecond, 10, 10)
+		tracker.Record("a", at(0))
+		tracker.Record("a", at(50))
+		got := tracker.Record("a", at(120))
+		assert.DeepEqual(t, got, []time.Time{at(50), at(120)}, "incorrect timestamps")
+	})
+
+	t.Run("bounds timestamps per key", func(t *testing.T) {
+		t.Parallel()
+		tracker := newBurstTracker(time.Minute, 10, 2)
+		tracker.Record("a", at(0))
+		tracker.Record("a", at(1))
+		got := tracker.Record("a", at(2))
+		assert.DeepEqual(t, got, []time.Time{at(1), at(2)}, "incorrect timestamps")
+	})
+
+	t.Run("evicts least recently updated keys", func(t *testing.T) {
+		t.Parallel()
+		tracker := newBurstTracker(time.Minute, 2, 10)
+		tracker.Record("a", at(0))
+		tracker.Record("b", at(1))
+		tracker.Record("a", at(2)) // refreshes "a"
+		tracker.Record("c", at(3)) // evicts "b"
+		assert.Equal(t, tracker.store.Len(), 2, "expected tracker to be bounded")
+		assert.DeepEqual(t, tracker.Record("b", at(4)), []time.Time{at(4)}, "expected b to have been evicted")
+	})
+}
+
+func TestNewBurstResponse(t *testing.T) {
+	start := time.Now()
+	timestamps := []time.Time{
+		start,
+		start.Add(10 * time.Millisecond),
+		start.Add(40 * time.Millisecond),
+		start.Add(60 * time.Millisecond),
+	}
+	assert.DeepEqual(t, newBurstResponse("k", timestamps), &burstResponse{
+		Key:       "k",
+		Count:     4,
+		MinGapMS:  10,
+		MaxGapMS:  30,
+		MeanGapMS: 20,
+	}, "incorrect burst stats")
+	assert.DeepEqual(t, newBurstResponse("k", timestamps[:1]), &burstResponse{Key: "k", Count: 1}, "incorrect single request stats")
+}
+
+func TestEndpointTimeout(t *testing.T) {
+	t.Parallel()
+	timeouts := map[string]time.Duration{
+		"/delay":   1 * time.Second,
+		"/delay/5": 5 * time.Second,
+		"/sse":     2 * time.Second,
+	}
+	testCases := []struct {
+		path   string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"/delay", 1 * time.Second, true},
+		{"/delay/1", 1 * time.Second, true},
+		{"/delay/5", 5 * time.Second, true},
+		{"/delay/5/foo", 5 * time.Second, true},
+		{"/sse", 2 * time.Second, true},
+		{"/delayed", 0, false},
+		{"/get", 0, false},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.path, func(t *testing.T) {
+			t.Parallel()
+			got, ok := endpointTimeout(timeouts, tc.path)
+			assert.Equal(t, ok, tc.wantOK, "incorrect match result")
+			assert.Equal(t, got, tc.want, "incorrect timeout")
+		})
+	}
+}
+
+func TestHashHeaders(t *testing.T) {
+	t.Parallel()
+	headers := http.Header{
+		"X-Foo": {"a", "b"},
+		"Host":  {"example.com"},
+		"X-Bar": {"c"},
+	}
+	want := sha256.Sum256([]byte("host:example.com\nx-bar:c\nx-foo:a,b\n"))
+	assert.Equal(t, hashHeaders(headers), fmt.Sprintf("%x", want), "incorrect headers hash")
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		input string
+		n     int
+		want  string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 3, "hel"},
+		{"héllo", 2, "h"},
+		{"héllo", 3, "hé"},
+		{"héllo", 0, ""},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("%s/%d", tc.input, tc.n), func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, truncateUTF8(tc.input, tc.n), tc.want, "incorrect truncation")
+		})
+	}
+}
+
+func TestLoadTrackerHeapAlloc(t *testing.T) {
+	t.Parallel()
+	tracker := &loadTracker{}
+	now := time.Now()
+
+	first := tracker.HeapAlloc(now)
+	if first == 0 {
+		t.Fatalf("expected non-zero heap alloc")
+	}
+
+	// memory stats are not re-read within the interval
+	_ = make([]byte, 1<<20)
+	assert.Equal(t, tracker.HeapAlloc(now.Add(memStatsInterval/2)), first, "expected cached heap alloc")
+	assert.Equal(t, tracker.memStatsRead, now, "expected memory stats not to be re-read")
+
+	tracker.HeapAlloc(now.Add(memStatsInterval))
+	assert.Equal(t, tracker.memStatsRead, now.Add(memStatsInterval), "expected memory stats to be re-read")
+}
+
+func TestRelayRooms(t *testing.T) {
+	t.Parallel()
+
+	newWebSocket := func() *websocket.WebSocket {
+		return websocket.New(httptest.NewRecorder(), httptest.NewRequest("GET", "/websocket/relay/room", nil), websocket.Limits{})
+	}
+
+	rr := newRelayRooms(1, 3)
+	sender, ready, pending := newWebSocket(), newWebSocket(), newWebSocket()
+	senderPeer, err := rr.Join("room", sender)
+	assert.NilError(t, err)
+	readyPeer, err := rr.Join("room", ready)
+	assert.NilError(t, err)
+	pendingPeer, err := rr.Join("room", pending)
+	assert.NilError(t, err)
+	senderPeer.ready.Store(true)
+	readyPeer.ready.Store(true)
+
+	_, err = rr.Join("room", newWebSocket())
+	assert.Error(t, err, errRelayRoomFull)
+	_, err = rr.Join("other room", newWebSocket())
+	assert.Error(t, err, errRelayTooMany)
+
+	// with no goroutine delivering messages, broadcasts beyond the queue
+	// size must be dropped rather than block the sender
+	for i := 0; i < relayPeerQueueSize+1; i++ {
+		rr.Broadcast("room", sender, &websocket.Message{Payload: []byte(strconv.Itoa(i))})
+	}
+	assert.Equal(t, len(readyPeer.queue), relayPeerQueueSize, "incorrect ready peer queue length")
+	assert.Equal(t, len(pendingPeer.queue), 0, "expected nothing queued for peer before handshake")
+	assert.Equal(t, len(senderPeer.queue), 0, "expected nothing queued for sender")
+	assert.Equal(t, string((<-readyPeer.queue).Payload), "0", "incorrect first queued message")
+
+	// a peer that has left, whose queue is closed, is no longer broadcast to
+	rr.Leave("room", ready)
+	rr.Broadcast("room", sender, &websocket.Message{Payload: []byte("after leave")})
+	rr.Leave("room", sender)
+	rr.Leave("room", pending)
+	assert.Equal(t, len(rr.rooms), 0, "expected empty room to be removed")
+}
+
+func TestKeyedCounter(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+
+	t.Run("counts attempts per key", func(t *testing.T) {
+		t.Parallel()
+		counter := newKeyedCounter(time.Minute, 10)
+		assert.Equal(t, counter.Increment("a", at(0)), 1, "incorrect count")
+		assert.Equal(t, counter.Increment("a", at(1)), 2, "incorrect count")
+		assert.Equal(t, counter.Increment("b", at(2)), 1, "incorrect count")
+	})
+
+	t.Run("resets after ttl", func(t *testing.T) {
+		t.Parallel()
+		counter := newKeyedCounter(time.Minute, 10)
+		counter.Increment("a", at(0))
+		counter.Increment("a", at(30))
+		assert.Equal(t, counter.Increment("a", at(120)), 1, "expected count to reset")
+	})
+
+	t.Run("evicts least recently updated keys", func(t *testing.T) {
+		t.Parallel()
+		counter := newKeyedCounter(time.Minute, 2)
+		counter.Increment("a", at(0))
+		counter.Increment("b", at(1))
+		counter.Increment("a", at(2)) // refreshes "a"
+		counter.Increment("c", at(3)) // evicts "b"
+		assert.Equal(t, counter.store.Len(), 2, "expected counter to be bounded")
+		assert.Equal(t, counter.Increment("b", at(4)), 1, "expected b to have been evicted")
+		assert.Equal(t, counter.Increment("c", at(5)), 2, "expected c to be retained")
+	})
+}
+
+func TestSHA1Hash(t *testing.T) {
+	t.Parallel()
+	// SHA-1 of "abc", per RFC 3174
+	assert.Equal(t, sha1hash("abc"), "a9993e364706816aba3e25717850c26c9cd0d89d", "incorrect sha1 hash")
+}
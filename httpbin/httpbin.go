@@ -2,15 +2,25 @@ package httpbin
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/fs"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // Default configuration values
 const (
-	DefaultMaxBodySize int64 = 1024 * 1024
-	DefaultMaxDuration       = 10 * time.Second
-	DefaultHostname          = "go-httpbin"
+	DefaultMaxBodySize     int64 = 1024 * 1024
+	DefaultMaxDuration           = 10 * time.Second
+	DefaultHostname              = "go-httpbin"
+	DefaultRequestIDHeader       = "X-Request-Id"
 )
 
 // DefaultParams defines default parameter values
@@ -37,15 +47,27 @@ var DefaultDefaultParams = DefaultParams{
 	SSEDelay:     0,
 }
 
+// ServerTimeouts describes the timeouts configured on the http.Server serving
+// an HTTPBin instance, which are reported by the /config endpoint. Zero values
+// are treated as unconfigured.
+type ServerTimeouts struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	IdleTimeout       time.Duration
+}
+
 type headersProcessorFunc func(h http.Header) http.Header
 
 // HTTPBin contains the business logic
 type HTTPBin struct {
-	// Max size of an incoming request or generated response body, in bytes
+	// Max size of an incoming request or generated response body, in bytes.
+	// This is the initial value, which may be changed at runtime via the
+	// /admin/reload endpoint.
 	MaxBodySize int64
 
 	// Max duration of a request, for those requests that allow user control
-	// over timing (e.g. /delay)
+	// over timing (e.g. /delay). This is the initial value, which may be
+	// changed at runtime via the /admin/reload endpoint.
 	MaxDuration time.Duration
 
 	// Observer called with the result of each handled request
@@ -54,23 +76,25 @@ type HTTPBin struct {
 	// Default parameter values
 	DefaultParams DefaultParams
 
-	// Set of hosts to which the /redirect-to endpoint will allow redirects
+	// Set of hosts to which the /redirect-to endpoint will allow redirects.
+	// This is the initial value, which may be changed at runtime via the
+	// /admin/reload endpoint.
 	AllowedRedirectDomains map[string]struct{}
 
 	// The operator-controlled environment variables filtered from
 	// the process environment, based on named HTTPBIN_ prefix.
 	env map[string]string
 
-	// Pre-computed error message for the /redirect-to endpoint, based on
-	// -allowed-redirect-domains/ALLOWED_REDIRECT_DOMAINS
-	forbiddenRedirectError string
-
 	// The hostname to expose via /hostname.
 	hostname string
 
 	// The app's http handler
 	handler http.Handler
 
+	// The app's router, used to report the route pattern matching a request
+	// and to list all routes in the index
+	mux *routeMux
+
 	// Optional prefix under which the app will be served
 	prefix string
 
@@ -85,9 +109,108 @@ type HTTPBin struct {
 	// /headers response
 	excludeHeadersProcessor headersProcessorFunc
 
-	// Max number of SSE events to send, based on rough estimate of single
-	// event's size
-	maxSSECount int64
+	// Default compression level for the /gzip and /deflate endpoints, which
+	// may be overridden per-request via the ?level query param
+	compressionLevel int
+
+	// Whether JSON responses are compact rather than pretty-printed by
+	// default, which may be overridden per-request via the ?pretty query param
+	compactJSON bool
+
+	// Whether to add a Content-Digest header to non-streaming responses by
+	// default, which may also be requested per-request via the ?digest query
+	// param
+	contentDigest bool
+
+	// Max number of distinct query params allowed per request, where zero
+	// means unlimited
+	maxQueryParams int
+
+	// Max time to wait for each read of a request body, where zero defers to
+	// the server's own timeouts
+	bodyReadTimeout time.Duration
+
+	// Timeouts of the server serving this instance, to report via /config
+	serverTimeouts ServerTimeouts
+
+	// Optional cache of responses keyed by Idempotency-Key request header
+	idempotencyCache *idempotencyCache
+
+	// Recent request arrival times per key, for the /burst endpoint
+	burstTracker *burstTracker
+
+	// Attempts per key, for the /flaky endpoint
+	flakyCounter *keyedCounter
+
+	// Requests per key, for the /status/sequence endpoint
+	statusSequenceCounter *keyedCounter
+
+	// First request per key, for the /diff endpoint
+	diffStore *diffStore
+
+	// Connections per room, for the /websocket/relay endpoint
+	relayRooms *relayRooms
+
+	// Max number of concurrent websocket connections, where zero means
+	// unlimited, and the number currently open
+	maxWebSocketConns int64
+	webSocketConns    atomic.Int64
+
+	// Request counts and runtime stats, for the /loadinfo endpoint
+	loadTracker *loadTracker
+
+	// Min duration of requests passed to the Observer, where zero observes
+	// every request
+	slowRequestThreshold time.Duration
+
+	// Optional header used to echo or generate a per-request ID
+	requestIDHeader string
+
+	// Max number of request body bytes echoed back in responses, where zero
+	// defaults to the current max body size
+	maxResponseBodySize int64
+
+	// Limits currently in effect, which are initialized from the exported
+	// fields above and swapped atomically by the /admin/reload endpoint
+	limits atomic.Pointer[runtimeLimits]
+
+	// Optional token required to access the /admin endpoints, which are
+	// disabled when empty
+	adminToken string
+
+	// Optional per-path overrides of MaxDuration, enforced as request deadlines
+	endpointTimeouts map[string]time.Duration
+
+	// Optional user-provided filesystem to serve under the given path prefix
+	staticFS     fs.FS
+	staticPrefix string
+
+	// Optional user-provided handlers, keyed by ServeMux pattern
+	routes map[string]http.Handler
+
+	// Optional callback to mutate each request before it is routed
+	requestHook func(*http.Request)
+
+	// Whether /get and /anything responses describe the connection the
+	// request was received on
+	includeConnectionInfo bool
+
+	// Optional status code forced on every response, where zero means
+	// responses are unchanged
+	forcedStatus int
+
+	// Optional text describing status codes in error responses, overriding
+	// http.StatusText
+	statusTextOverrides map[int]string
+
+	// Content type of the /deny endpoint's response
+	denyContentType string
+
+	// Whether panics in handlers are recovered and turned into 500 errors
+	panicRecovery bool
+
+	// Whether debugging endpoints like /panic are enabled
+	debugEndpoints bool
 }
 
 // New creates a new HTTPBin instance
@@ -97,24 +220,44 @@ func New(opts ...OptionFunc) *HTTPBin {
 		MaxDuration:   DefaultMaxDuration,
 		DefaultParams: DefaultDefaultParams,
 		hostname:      DefaultHostname,
+
+		panicRecovery:    true,
+		compressionLevel: gzip.DefaultCompression,
+		burstTracker:     newBurstTracker(burstTrackerTTL, burstTrackerMaxKeys, burstTrackerMaxTimestamps),
+		flakyCounter:     newKeyedCounter(keyedCounterTTL, keyedCounterMaxKeys),
+		loadTracker:      &loadTracker{},
+		relayRooms:       newRelayRooms(relayMaxRooms, relayMaxConnsPerRoom),
+
+		statusSequenceCounter: newKeyedCounter(keyedCounterTTL, keyedCounterMaxKeys),
+		diffStore:             newDiffStore(diffStoreTTL, diffStoreMaxKeys, diffStoreMaxBytes),
 	}
 	for _, opt := range opts {
 		opt(h)
 	}
 
-	// pre-compute some configuration values and pre-render templates
-	tmplData := struct{ Prefix string }{Prefix: h.prefix}
-	h.indexHTML = mustRenderTemplate("index.html.tmpl", tmplData)
-	h.formsPostHTML = mustRenderTemplate("forms-post.html.tmpl", tmplData)
+	// pre-compute some configuration values
 	h.statusSpecialCases = createSpecialCases(h.prefix)
 
-	// compute max Server-Sent Event count based on max request size and rough
-	// estimate of a single event's size on the wire
-	var buf bytes.Buffer
-	writeServerSentEvent(&buf, 999, time.Now())
-	h.maxSSECount = h.MaxBodySize / int64(buf.Len())
+	h.limits.Store(h.newRuntimeLimits(h.MaxBodySize, h.MaxDuration, h.AllowedRedirectDomains))
+
+	if h.denyContentType == "" {
+		h.denyContentType = textContentType
+	}
 
 	h.handler = h.Handler()
+
+	// pre-render templates, once the router has been built so that the
+	// index can list its routes
+	tmplData := struct {
+		Prefix string
+		Routes []indexRoute
+	}{
+		Prefix: h.prefix,
+		Routes: newIndexRoutes(h.mux.patterns, h.prefix),
+	}
+	h.indexHTML = mustRenderTemplate("index.html.tmpl", tmplData)
+	h.formsPostHTML = mustRenderTemplate("forms-post.html.tmpl", tmplData)
+
 	return h
 }
 
@@ -123,12 +266,19 @@ func (h *HTTPBin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.handler.ServeHTTP(w, r)
 }
 
+// ConnContext may be used as an http.Server's ConnContext hook, to count the
+// requests received on each connection so that the /anything endpoint can
+// report whether the connection a request arrived on was reused.
+func (h *HTTPBin) ConnContext(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, connRequestsKey{}, new(atomic.Int64))
+}
+
 // Assert that HTTPBin implements http.Handler interface
 var _ http.Handler = &HTTPBin{}
 
 // Handler returns an http.Handler that exposes all HTTPBin endpoints
 func (h *HTTPBin) Handler() http.Handler {
-	mux := http.NewServeMux()
+	mux := &routeMux{ServeMux: http.NewServeMux()}
 
 	// Endpoints restricted to specific methods
 	mux.HandleFunc("DELETE /delete", h.RequestWithBody)
@@ -137,9 +287,15 @@ func (h *HTTPBin) Handler() http.Handler {
 	mux.HandleFunc("GET /forms/post", h.FormsPost)
 	mux.HandleFunc("GET /get", h.Get)
 	mux.HandleFunc("GET /websocket/echo", h.WebSocketEcho)
+	mux.HandleFunc("GET /websocket/fuzz", h.WebSocketFuzz)
+	mux.HandleFunc("GET /websocket/relay/{room}", h.WebSocketRelay)
 	mux.HandleFunc("HEAD /head", h.Get)
 	mux.HandleFunc("PATCH /patch", h.RequestWithBody)
+	mux.HandleFunc("POST /base64/decode", h.Base64Body)
+	mux.HandleFunc("POST /base64/encode", h.Base64Body)
 	mux.HandleFunc("POST /post", h.RequestWithBody)
+	mux.HandleFunc("POST /validate-json", h.ValidateJSON)
+	mux.HandleFunc("POST /webhook/verify", h.WebhookVerify)
 	mux.HandleFunc("PUT /put", h.RequestWithBody)
 
 	// Endpoints that accept any methods
@@ -150,21 +306,29 @@ func (h *HTTPBin) Handler() http.Handler {
 	mux.HandleFunc("/base64/{operation}/{data}", h.Base64)
 	mux.HandleFunc("/basic-auth/{user}/{password}", h.BasicAuth)
 	mux.HandleFunc("/bearer", h.Bearer)
+	mux.HandleFunc("/brotli", h.Brotli)
+	mux.HandleFunc("/burst", h.Burst)
 	mux.HandleFunc("/bytes/{numBytes}", h.Bytes)
 	mux.HandleFunc("/cache", h.Cache)
 	mux.HandleFunc("/cache/{numSeconds}", h.CacheControl)
+	mux.HandleFunc("/cache/no-store", h.CacheNoStore)
+	mux.HandleFunc("/config", h.Config)
 	mux.HandleFunc("/cookies", h.Cookies)
 	mux.HandleFunc("/cookies/delete", h.DeleteCookies)
+	mux.HandleFunc("/cookies/raw", h.RawCookies)
 	mux.HandleFunc("/cookies/set", h.SetCookies)
+	mux.HandleFunc("/cors-preflight-debug", h.CORSPreflightDebug)
 	mux.HandleFunc("/deflate", h.Deflate)
 	mux.HandleFunc("/delay/{duration}", h.Delay)
 	mux.HandleFunc("/deny", h.Deny)
+	mux.HandleFunc("/diff", h.Diff)
 	mux.HandleFunc("/digest-auth/{qop}/{user}/{password}", h.DigestAuth)
 	mux.HandleFunc("/digest-auth/{qop}/{user}/{password}/{algorithm}", h.DigestAuth)
 	mux.HandleFunc("/drip", h.Drip)
 	mux.HandleFunc("/dump/request", h.DumpRequest)
 	mux.HandleFunc("/env", h.Env)
 	mux.HandleFunc("/etag/{etag}", h.ETag)
+	mux.HandleFunc("/flaky", h.Flaky)
 	mux.HandleFunc("/gzip", h.Gzip)
 	mux.HandleFunc("/headers", h.Headers)
 	mux.HandleFunc("/hidden-basic-auth/{user}/{password}", h.HiddenBasicAuth)
@@ -176,43 +340,287 @@ func (h *HTTPBin) Handler() http.Handler {
 	mux.HandleFunc("/json", h.JSON)
 	mux.HandleFunc("/links/{numLinks}", h.Links)
 	mux.HandleFunc("/links/{numLinks}/{offset}", h.Links)
+	mux.HandleFunc("/loadinfo", h.LoadInfo)
 	mux.HandleFunc("/range/{numBytes}", h.Range)
 	mux.HandleFunc("/redirect-to", h.RedirectTo)
 	mux.HandleFunc("/redirect/{numRedirects}", h.Redirect)
 	mux.HandleFunc("/relative-redirect/{numRedirects}", h.RelativeRedirect)
 	mux.HandleFunc("/response-headers", h.ResponseHeaders)
+	mux.HandleFunc("/reverse", h.Reverse)
 	mux.HandleFunc("/robots.txt", h.Robots)
 	mux.HandleFunc("/sse", h.SSE)
 	mux.HandleFunc("/status/{code}", h.Status)
+	mux.HandleFunc("/status/sequence/{codes}", h.StatusSequence)
 	mux.HandleFunc("/stream-bytes/{numBytes}", h.StreamBytes)
 	mux.HandleFunc("/stream/{numLines}", h.Stream)
 	mux.HandleFunc("/trailers", h.Trailers)
 	mux.HandleFunc("/unstable", h.Unstable)
 	mux.HandleFunc("/user-agent", h.UserAgent)
+	mux.HandleFunc("/user-agent/parse", h.UserAgentParse)
 	mux.HandleFunc("/uuid", h.UUID)
 	mux.HandleFunc("/xml", h.XML)
+	mux.HandleFunc("/zstd", h.Zstd)
 
-	// existing httpbin endpoints that we do not support
-	mux.HandleFunc("/brotli", notImplementedHandler)
+	// Optional debugging endpoints
+	if h.debugEndpoints {
+		mux.HandleFunc("/panic", h.Panic)
+	}
+
+	// Optional admin endpoints
+	if h.adminToken != "" {
+		mux.HandleFunc("POST /admin/reload", h.AdminReload)
+	}
+
+	// Optional user-provided static files
+	if h.staticFS != nil {
+		mux.Handle("GET "+h.staticPrefix+"/", http.StripPrefix(h.staticPrefix, h.staticFiles(h.staticFS)))
+	}
+
+	// Optional user-provided routes, which may not clobber any of the above
+	patterns := make([]string, 0, len(h.routes))
+	for pattern := range h.routes {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if existing := conflictingPattern(mux.ServeMux, pattern); existing != "" {
+			panic(fmt.Sprintf("httpbin: custom route %q conflicts with built-in route %q", pattern, existing))
+		}
+		mux.Handle(pattern, h.routes[pattern])
+	}
+
+	h.mux = mux
 
 	// Apply global middleware
 	var handler http.Handler
 	handler = mux
-	handler = limitRequestSize(h.MaxBodySize, handler)
-	handler = preflight(handler)
+	handler = jsonFormat(h.compactJSON, handler)
+	handler = contentDigest(h.contentDigest, handler)
+	handler = limitRequestSize(h.maxBodySize, h.writeError, handler)
+	if h.bodyReadTimeout > 0 {
+		handler = limitBodyReadTime(h.bodyReadTimeout, handler)
+	}
+	if h.maxQueryParams > 0 {
+		handler = limitQueryParams(h.maxQueryParams, h.writeError, handler)
+	}
+	if len(h.endpointTimeouts) > 0 {
+		handler = limitEndpointTime(h.endpointTimeouts, handler)
+	}
+	handler = preflight(h.allowedMethods, handler)
+	handler = idempotency(h.idempotencyCache, h.maxBodySize, handler)
 	handler = autohead(handler)
+	if h.requestIDHeader != "" {
+		handler = requestID(h.requestIDHeader, handler)
+	}
+	if h.forcedStatus != 0 {
+		handler = forceStatus(h.forcedStatus, handler)
+	}
+	handler = trackLoad(h.loadTracker, handler)
+	handler = countConnRequests(handler)
 
 	if h.prefix != "" {
 		handler = http.StripPrefix(h.prefix, handler)
 	}
 
+	if h.requestHook != nil {
+		handler = requestHook(h.requestHook, handler)
+	}
+
+	if h.panicRecovery {
+		handler = recoverPanics(h.writeError, handler)
+	}
+
 	if h.Observer != nil {
-		handler = observe(h.Observer, handler)
+		handler = observe(h.Observer, h.slowRequestThreshold, time.Now, handler)
 	}
 
 	return handler
 }
 
+// maxDuration returns the maximum duration allowed for the given request,
+// honoring any per-endpoint override of MaxDuration.
+func (h *HTTPBin) maxDuration(r *http.Request) time.Duration {
+	if d, ok := endpointTimeout(h.endpointTimeouts, r.URL.Path); ok {
+		return d
+	}
+	return h.limits.Load().maxDuration
+}
+
+// maxBodySize returns the maximum size of a request or generated response
+// body currently in effect.
+func (h *HTTPBin) maxBodySize() int64 {
+	return h.limits.Load().maxBodySize
+}
+
+// requestMaxBodySize returns the maximum size of the given request's body,
+// which the client may have lowered via the X-Max-Body-Size header.
+func (h *HTTPBin) requestMaxBodySize(r *http.Request) int64 {
+	if limit, ok := r.Context().Value(maxBodySizeKey{}).(int64); ok {
+		return limit
+	}
+	return h.maxBodySize()
+}
+
+// runtimeLimits holds the limits that may be adjusted while the app is
+// running, along with the values derived from them.
+type runtimeLimits struct {
+	maxBodySize            int64
+	maxDuration            time.Duration
+	allowedRedirectDomains map[string]struct{}
+
+	// Pre-computed error message for the /redirect-to endpoint, based on
+	// allowedRedirectDomains
+	forbiddenRedirectError string
+
+	// Max number of request body bytes echoed back in responses
+	maxResponseBodySize int64
+
+	// Max number of SSE events to send, based on rough estimate of single
+	// event's size
+	maxSSECount int64
+}
+
+// newRuntimeLimits computes a new set of runtime limits from the given
+// values.
+func (h *HTTPBin) newRuntimeLimits(maxBodySize int64, maxDuration time.Duration, allowedRedirectDomains map[string]struct{}) *runtimeLimits {
+	l := &runtimeLimits{
+		maxBodySize:            maxBodySize,
+		maxDuration:            maxDuration,
+		allowedRedirectDomains: allowedRedirectDomains,
+		maxResponseBodySize:    h.maxResponseBodySize,
+	}
+	if l.maxResponseBodySize <= 0 {
+		l.maxResponseBodySize = maxBodySize
+	}
+
+	if len(allowedRedirectDomains) > 0 {
+		formattedListItems := make([]string, 0, len(allowedRedirectDomains))
+		for host := range allowedRedirectDomains {
+			formattedListItems = append(formattedListItems, fmt.Sprintf("- %s", host))
+		}
+		sort.Strings(formattedListItems)
+		l.forbiddenRedirectError = fmt.Sprintf(`Forbidden redirect URL. Please be careful with this link.
+
+Allowed redirect destinations:
+%s`, strings.Join(formattedListItems, "\n"))
+	}
+
+	// compute max Server-Sent Event count based on max request size and rough
+	// estimate of a single event's size on the wire
+	var buf bytes.Buffer
+	writeServerSentEvent(&buf, 999, time.Now())
+	l.maxSSECount = maxBodySize / int64(buf.Len())
+
+	return l
+}
+
+// routeMux is an http.ServeMux that records the patterns registered on it.
+type routeMux struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func (m *routeMux) Handle(pattern string, handler http.Handler) {
+	m.ServeMux.Handle(pattern, handler)
+	m.patterns = append(m.patterns, pattern)
+}
+
+func (m *routeMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// indexRoute describes a single route for display in the index page.
+type indexRoute struct {
+	// Route is the route's pattern as registered, which names the template
+	// describing it, if any
+	Route string
+	// Pattern is the route's pattern, with any prefix applied
+	Pattern string
+	// Link is the route's URL, if it can be visited with a plain GET
+	// request, and is otherwise empty
+	Link string
+}
+
+// newIndexRoutes returns the given route patterns, with the given prefix
+// applied, ordered by path and then method.
+func newIndexRoutes(patterns []string, prefix string) []indexRoute {
+	type parsed struct{ pattern, method, path string }
+	parsedPatterns := make([]parsed, 0, len(patterns))
+	for _, pattern := range patterns {
+		method, path, found := strings.Cut(pattern, " ")
+		if !found {
+			method, path = "", pattern
+		}
+		parsedPatterns = append(parsedPatterns, parsed{pattern, method, strings.TrimSpace(path)})
+	}
+	sort.Slice(parsedPatterns, func(i, j int) bool {
+		a, b := parsedPatterns[i], parsedPatterns[j]
+		if a.path != b.path {
+			return a.path < b.path
+		}
+		return a.method < b.method
+	})
+
+	routes := make([]indexRoute, 0, len(parsedPatterns))
+	for _, p := range parsedPatterns {
+		// a trailing {$} only anchors the pattern, so omit it from display
+		path := strings.TrimSuffix(p.path, "{$}")
+		route := indexRoute{Route: p.pattern, Pattern: prefix + path}
+		if p.method != "" {
+			route.Pattern = p.method + " " + route.Pattern
+		}
+		if (p.method == "" || p.method == http.MethodGet) && !strings.Contains(path, "{") {
+			route.Link = prefix + path
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// conflictingPattern returns the pattern of any route already registered on
+// mux that would handle requests matching the given pattern, or an empty
+// string if there is none. Patterns without a method are checked against
+// every method.
+func conflictingPattern(mux *http.ServeMux, pattern string) string {
+	methods := []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPost,
+		http.MethodPut,
+		http.MethodPatch,
+		http.MethodDelete,
+		http.MethodOptions,
+	}
+	path := pattern
+	if method, rest, found := strings.Cut(pattern, " "); found {
+		methods, path = []string{method}, strings.TrimSpace(rest)
+	}
+	for _, method := range methods {
+		req := &http.Request{Method: method, URL: &url.URL{Path: path}}
+		if _, existing := mux.Handler(req); existing != "" {
+			return existing
+		}
+	}
+	return ""
+}
+
+// allowedMethods returns the comma-separated list of methods allowed by the
+// route matching the given request's path, suitable for an Allow or
+// Access-Control-Allow-Methods header.
+func (h *HTTPBin) allowedMethods(r *http.Request) string {
+	var allowed []string
+	for _, method := range strings.Split(corsAllowedMethods, ", ") {
+		if method != http.MethodOptions {
+			probe := &http.Request{Method: method, Host: r.Host, URL: r.URL}
+			if _, pattern := h.mux.Handler(probe); pattern == "" {
+				continue
+			}
+		}
+		allowed = append(allowed, method)
+	}
+	return strings.Join(allowed, ", ")
+}
+
 func (h *HTTPBin) setExcludeHeaders(excludeHeaders string) {
 	regex := createFullExcludeRegex(excludeHeaders)
 	if regex != nil {
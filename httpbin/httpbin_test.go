@@ -1,9 +1,12 @@
 package httpbin
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -67,3 +70,237 @@ func TestNewObserver(t *testing.T) {
 		t.Fatalf("observer never called")
 	}
 }
+
+func TestSlowRequestThreshold(t *testing.T) {
+	t.Parallel()
+
+	threshold := 50 * time.Millisecond
+	if h := New(WithSlowRequestThreshold(threshold)); h.slowRequestThreshold != threshold {
+		t.Fatalf("expected slowRequestThreshold == %s, got %s", threshold, h.slowRequestThreshold)
+	}
+
+	// use a fake clock that only advances while handling slow requests, so
+	// that request durations are exact
+	var results []Result
+	now := time.Now()
+	handler := observe(
+		func(r Result) { results = append(results, r) },
+		threshold,
+		func() time.Time { return now },
+		http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				now = now.Add(threshold)
+			}
+		}),
+	)
+	for _, path := range []string{"/fast", "/slow"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if len(results) != 1 || results[0].URI != "/slow" || results[0].Duration != threshold {
+		t.Fatalf("expected only slow request to be observed, got %#v", results)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	t.Parallel()
+
+	var results []Result
+	h := New(
+		WithDebugEndpoints(true),
+		WithObserver(func(r Result) { results = append(results, r) }),
+	)
+
+	r := httptest.NewRequest("GET", "/panic", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != jsonContentType {
+		t.Fatalf("expected content type %q, got %q", jsonContentType, ct)
+	}
+	if !strings.Contains(w.Body.String(), "deliberate panic") {
+		t.Fatalf("expected error body to describe panic, got %q", w.Body.String())
+	}
+	if len(results) != 1 || results[0].Status != http.StatusInternalServerError || results[0].Panic == "" {
+		t.Fatalf("expected observer to record recovered panic, got %#v", results)
+	}
+
+	// normal endpoints are unaffected
+	r = httptest.NewRequest("GET", "/get", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if len(results) != 2 || results[1].Panic != "" {
+		t.Fatalf("expected observer to record no panic, got %#v", results)
+	}
+
+	// debug endpoints are disabled by default
+	r = httptest.NewRequest("GET", "/panic", nil)
+	w = httptest.NewRecorder()
+	New().ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestPanicObserved(t *testing.T) {
+	t.Parallel()
+
+	t.Run("recovered panics ignore slow request threshold", func(t *testing.T) {
+		t.Parallel()
+		var results []Result
+		h := New(
+			WithDebugEndpoints(true),
+			WithObserver(func(r Result) { results = append(results, r) }),
+			WithSlowRequestThreshold(time.Hour),
+		)
+
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/get", nil))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+		if len(results) != 1 || results[0].URI != "/panic" || results[0].Panic == "" {
+			t.Fatalf("expected observer to record only the panic, got %#v", results)
+		}
+	})
+
+	t.Run("re-raised panics are observed", func(t *testing.T) {
+		t.Parallel()
+		var results []Result
+		handler := observe(
+			func(r Result) { results = append(results, r) },
+			time.Hour,
+			time.Now,
+			recoverPanics(New().writeError, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				panic("panic after response started")
+			})),
+		)
+
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("expected panic to be re-raised")
+			}
+			if len(results) != 1 || results[0].Status != http.StatusAccepted || results[0].Panic != "panic after response started" {
+				t.Fatalf("expected observer to record re-raised panic, got %#v", results)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	})
+}
+
+func TestStatusTextOverrides(t *testing.T) {
+	t.Parallel()
+
+	var h *HTTPBin
+	h = New(
+		WithStatusTextOverrides(map[int]string{
+			599: "Network Connect Timeout Error",
+			418: "Short And Stout",
+		}),
+		WithRoutes(map[string]http.Handler{
+			"GET /custom/{code}": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				code, _ := strconv.Atoi(r.PathValue("code"))
+				h.writeError(w, code, nil)
+			}),
+		}),
+	)
+
+	testCases := map[string]string{
+		"/custom/599":              "Network Connect Timeout Error",
+		"/custom/418?pretty=false": "Short And Stout",
+		"/custom/404":              "Not Found", // falls back to http.StatusText
+	}
+	for path, want := range testCases {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		var resp errorRespnose
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to parse error response for %s: %s", path, err)
+		}
+		if resp.Error != want {
+			t.Fatalf("expected error text %q for %s, got %q", want, path, resp.Error)
+		}
+	}
+
+	// overrides apply to errors written by middleware
+	r := httptest.NewRequest("GET", "/status/599", nil)
+	r.Header.Set("X-Max-Body-Size", "foo")
+	w := httptest.NewRecorder()
+	New(WithStatusTextOverrides(map[int]string{400: "Bad Bad Request"})).ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `"Bad Bad Request"`) {
+		t.Fatalf("expected overridden error text in body, got %q", w.Body.String())
+	}
+}
+
+func TestWithRoutes(t *testing.T) {
+	t.Parallel()
+
+	t.Run("custom route is served with middleware", func(t *testing.T) {
+		t.Parallel()
+
+		observed := false
+		h := New(
+			WithPrefix("/prefix"),
+			WithObserver(func(r Result) { observed = true }),
+			WithRoutes(map[string]http.Handler{
+				"GET /custom/{id}": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					fmt.Fprintf(w, "custom %s", r.PathValue("id"))
+				}),
+			}),
+		)
+
+		r, _ := http.NewRequest("GET", "/prefix/custom/42", nil)
+		r.Header.Set("Origin", "https://example.com")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+		if body := w.Body.String(); body != "custom 42" {
+			t.Fatalf("expected body %q, got %q", "custom 42", body)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://example.com" {
+			t.Fatalf("expected CORS headers to be applied, got Access-Control-Allow-Origin %q", got)
+		}
+		if !observed {
+			t.Fatalf("observer never called")
+		}
+
+		// built-in routes are still served
+		r, _ = http.NewRequest("GET", "/prefix/get", nil)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for built-in route, got %d", w.Code)
+		}
+	})
+
+	conflictTests := map[string]string{
+		"/get":              "GET /get",
+		"/post":             "POST /post",
+		"POST /anything/x":  "/anything/",
+		"GET /status/{foo}": "/status/{code}",
+	}
+	for pattern, wantExisting := range conflictTests {
+		pattern, wantExisting := pattern, wantExisting
+		t.Run("conflict/"+pattern, func(t *testing.T) {
+			t.Parallel()
+
+			defer func() {
+				want := fmt.Sprintf("httpbin: custom route %q conflicts with built-in route %q", pattern, wantExisting)
+				if got := recover(); got != want {
+					t.Fatalf("expected panic %q, got %#v", want, got)
+				}
+			}()
+			New(WithRoutes(map[string]http.Handler{
+				pattern: http.NotFoundHandler(),
+			}))
+		})
+	}
+}
@@ -2,47 +2,467 @@ package httpbin
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
-func preflight(h http.Handler) http.Handler {
+// corsAllowedMethods is the full set of methods that may be reported to CORS
+// preflight requests
+const corsAllowedMethods = "GET, POST, HEAD, PUT, DELETE, PATCH, OPTIONS"
+
+// corsHeaders returns the CORS headers applied to a response for a request
+// from the given origin.
+func corsHeaders(origin string) http.Header {
+	if origin == "" {
+		origin = "*"
+	}
+	headers := http.Header{}
+	headers.Set("Access-Control-Allow-Origin", origin)
+	headers.Set("Access-Control-Allow-Credentials", "true")
+	return headers
+}
+
+// corsPreflightHeaders returns the CORS headers applied to a response to a
+// preflight request from the given origin, allowing the given methods and any
+// of the given request headers.
+func corsPreflightHeaders(origin string, requestHeaders string, allowedMethods string) http.Header {
+	headers := corsHeaders(origin)
+	headers.Set("Access-Control-Allow-Methods", allowedMethods)
+	headers.Set("Access-Control-Max-Age", "3600")
+	if requestHeaders != "" {
+		headers.Set("Access-Control-Allow-Headers", requestHeaders)
+	}
+	return headers
+}
+
+// preflight adds CORS headers to every response and answers OPTIONS requests
+// directly, reporting the methods allowed for the request's path as given by
+// allowedMethods.
+func preflight(allowedMethods func(*http.Request) string, h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
-		if origin == "" {
-			origin = "*"
-		}
 		respHeader := w.Header()
-		respHeader.Set("Access-Control-Allow-Origin", origin)
-		respHeader.Set("Access-Control-Allow-Credentials", "true")
 
 		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, HEAD, PUT, DELETE, PATCH, OPTIONS")
-			w.Header().Set("Access-Control-Max-Age", "3600")
-			if r.Header.Get("Access-Control-Request-Headers") != "" {
-				w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+			methods := allowedMethods(r)
+			for k, v := range corsPreflightHeaders(origin, r.Header.Get("Access-Control-Request-Headers"), methods) {
+				respHeader[k] = v
 			}
+			respHeader.Set("Allow", methods)
 			w.WriteHeader(200)
 			return
 		}
 
+		for k, v := range corsHeaders(origin) {
+			respHeader[k] = v
+		}
 		h.ServeHTTP(w, r)
 	})
 }
 
-func limitRequestSize(maxSize int64, h http.Handler) http.Handler {
+// maxBodySizeHeader is the request header a client may use to lower the
+// maximum request body size for a single request, to test its behavior
+// against varying limits.
+const maxBodySizeHeader = "X-Max-Body-Size"
+
+// maxBodySizeKey is the context key for the maximum request body size in
+// effect for a request, stashed by limitRequestSize.
+type maxBodySizeKey struct{}
+
+// errorWriter writes an error response, like HTTPBin.writeError, for use by
+// middleware that rejects requests before they reach a handler.
+type errorWriter func(w http.ResponseWriter, code int, err error)
+
+// limitRequestSize limits request bodies to the size currently returned by
+// maxSize, or to the smaller limit given in the X-Max-Body-Size request header.
+func limitRequestSize(maxSize func() int64, writeError errorWriter, h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := maxSize()
+		if userLimit := r.Header.Get(maxBodySizeHeader); userLimit != "" {
+			n, err := strconv.ParseInt(userLimit, 10, 64)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s header: %q must be a non-negative integer", maxBodySizeHeader, userLimit))
+				return
+			}
+			limit = min(n, limit)
+		}
 		if r.Body != nil {
-			r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), maxBodySizeKey{}, limit))
+		h.ServeHTTP(w, r)
+	})
+}
+
+// errBodyReadTimeout is returned when a client fails to send the next chunk
+// of a request body within the configured body read timeout
+var errBodyReadTimeout = errors.New("timed out reading request body")
+
+// errEndpointTimeout is the cause of a request context canceled because its
+// endpoint-specific timeout elapsed
+var errEndpointTimeout = errors.New("endpoint timeout exceeded")
+
+// timeoutBodyReader extends the connection's read deadline before each read
+// of the request body
+type timeoutBodyReader struct {
+	io.ReadCloser
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (r *timeoutBodyReader) Read(p []byte) (int, error) {
+	if err := r.rc.SetReadDeadline(time.Now().Add(r.timeout)); err != nil {
+		return r.ReadCloser.Read(p)
+	}
+	n, err := r.ReadCloser.Read(p)
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		// Leave the expired deadline in place, so the server gives up on
+		// the rest of the body rather than waiting to discard it
+		err = errBodyReadTimeout
+	case err != nil:
+		// Once the body is done, clear the deadline so that it cannot
+		// interfere with the server's own background reads
+		_ = r.rc.SetReadDeadline(time.Time{})
+	}
+	return n, err
+}
+
+// limitBodyReadTime aborts request body reads that wait longer than the given
+// timeout for data from the client
+func limitBodyReadTime(timeout time.Duration, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = &timeoutBodyReader{
+				ReadCloser: r.Body,
+				rc:         http.NewResponseController(w),
+				timeout:    timeout,
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// requestHook calls fn to mutate each request before handing it off to h
+func requestHook(fn func(*http.Request), h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fn(r)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// requestID echoes the request's ID in the given header on the response,
+// generating a new ID (which is also visible to handlers) if the request did
+// not include one
+func requestID(header string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(header)
+		if id == "" {
+			id = uuidv4()
+			r.Header.Set(header, id)
+		}
+		w.Header().Set(header, id)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// limitQueryParams rejects requests carrying more than maxParams distinct
+// query parameters
+func limitQueryParams(maxParams int, writeError errorWriter, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := len(r.URL.Query()); n > maxParams {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("too many query params: %d > %d", n, maxParams))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// limitEndpointTime applies a deadline to requests whose path matches one of
+// the given per-endpoint timeouts
+func limitEndpointTime(timeouts map[string]time.Duration, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d, ok := endpointTimeout(timeouts, r.URL.Path); ok {
+			ctx, cancel := context.WithTimeoutCause(r.Context(), d, errEndpointTimeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// trackLoad counts in-flight and total requests for the /loadinfo endpoint
+func trackLoad(t *loadTracker, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.total.Add(1)
+		t.inFlight.Add(1)
+		defer t.inFlight.Add(-1)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// idempotencyResponseWriter implements http.ResponseWriter in order to record
+// a response for later replay. Responses that are flushed, hijacked, or larger
+// than maxSize are not recorded.
+type idempotencyResponseWriter struct {
+	*metaResponseWriter
+	buf       bytes.Buffer
+	maxSize   int64
+	cacheable bool
+}
+
+func (iw *idempotencyResponseWriter) Write(b []byte) (int, error) {
+	if iw.cacheable {
+		if int64(iw.buf.Len()+len(b)) > iw.maxSize {
+			iw.cacheable = false
+			iw.buf.Reset()
+		} else {
+			iw.buf.Write(b)
+		}
+	}
+	return iw.metaResponseWriter.Write(b)
+}
+
+func (iw *idempotencyResponseWriter) Flush() {
+	iw.cacheable = false
+	iw.metaResponseWriter.Flush()
+}
+
+func (iw *idempotencyResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	iw.cacheable = false
+	return iw.metaResponseWriter.Hijack()
+}
+
+// idempotency echoes any Idempotency-Key request header in the response and,
+// if a cache is given, replays the recorded response to a previous request
+// with the same method, URL, and key.
+func idempotency(cache *idempotencyCache, maxSize func() int64, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Idempotency-Key", key)
+		if cache == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		cacheKey := r.Method + " " + r.URL.RequestURI() + " " + key
+		if entry, ok := cache.Get(cacheKey); ok {
+			for k, v := range entry.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(entry.status)
+			w.Write(entry.body)
+			return
+		}
+
+		iw := &idempotencyResponseWriter{
+			metaResponseWriter: &metaResponseWriter{w: w},
+			maxSize:            maxSize(),
+			cacheable:          true,
+		}
+		h.ServeHTTP(iw, r)
+		if iw.cacheable {
+			cache.Set(cacheKey, &idempotencyCacheEntry{
+				status: iw.Status(),
+				header: iw.Header().Clone(),
+				body:   iw.buf.Bytes(),
+			})
+		}
+	})
+}
+
+// compactJSONResponseWriter implements http.ResponseWriter in order to signal
+// to mustMarshalJSON that JSON should be written without indentation
+type compactJSONResponseWriter struct {
+	*metaResponseWriter
+}
+
+// jsonFormat controls whether JSON responses are pretty-printed, based on the
+// server's default and an optional ?pretty query param that overrides it.
+//
+// Since every endpoint sees the param, values that are not booleans are
+// ignored rather than rejected, so that endpoints which echo their query
+// params may still be given arbitrary ones.
+func jsonFormat(compactDefault bool, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compact := compactDefault
+		if pretty, err := strconv.ParseBool(r.URL.Query().Get("pretty")); err == nil {
+			compact = !pretty
+		}
+		if compact {
+			w = &compactJSONResponseWriter{&metaResponseWriter{w: w}}
 		}
 		h.ServeHTTP(w, r)
 	})
 }
 
+// contentDigestResponseWriter implements http.ResponseWriter in order to
+// buffer a response body and compute its Content-Digest header.
+//
+// Responses that are flushed or hijacked (i.e. streaming endpoints and
+// websockets) are exempt: any buffered data is written through as-is and no
+// digest is computed.
+type contentDigestResponseWriter struct {
+	*metaResponseWriter
+	algorithm string
+	buf       bytes.Buffer
+	code      int
+	streaming bool
+}
+
+func (dw *contentDigestResponseWriter) WriteHeader(code int) {
+	if dw.streaming {
+		dw.metaResponseWriter.WriteHeader(code)
+		return
+	}
+	if dw.code == 0 {
+		dw.code = code
+	}
+}
+
+func (dw *contentDigestResponseWriter) Write(b []byte) (int, error) {
+	if dw.streaming {
+		return dw.metaResponseWriter.Write(b)
+	}
+	return dw.buf.Write(b)
+}
+
+func (dw *contentDigestResponseWriter) Flush() {
+	dw.writeBuffered()
+	dw.metaResponseWriter.Flush()
+}
+
+func (dw *contentDigestResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	dw.streaming = true
+	return dw.metaResponseWriter.Hijack()
+}
+
+// writeBuffered writes any buffered status and body to the underlying
+// response writer, after which all writes are passed straight through.
+func (dw *contentDigestResponseWriter) writeBuffered() {
+	if dw.streaming {
+		return
+	}
+	dw.streaming = true
+	if dw.code == 0 {
+		dw.code = http.StatusOK
+	}
+	dw.metaResponseWriter.WriteHeader(dw.code)
+	dw.metaResponseWriter.Write(dw.buf.Bytes())
+}
+
+// finish computes the Content-Digest header for a buffered, non-empty
+// response body and writes the response.
+func (dw *contentDigestResponseWriter) finish() {
+	if dw.streaming {
+		return
+	}
+	if dw.buf.Len() > 0 {
+		dw.Header().Set("Content-Digest", encodeContentDigest(dw.algorithm, dw.buf.Bytes()))
+	}
+	dw.writeBuffered()
+}
+
+// contentDigest adds an RFC 9530 Content-Digest header to responses, if
+// enabled by default or requested via the ?digest query param. See
+// contentDigestResponseWriter for the exemptions.
+//
+// Since every endpoint sees the param, values that are not supported
+// algorithms are ignored rather than rejected, so that endpoints which echo
+// their query params may still be given arbitrary ones.
+func contentDigest(enabled bool, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var algorithm string
+		if enabled {
+			algorithm = defaultContentDigestAlgorithm
+		}
+		if userAlgorithm := strings.ToLower(r.URL.Query().Get("digest")); userAlgorithm != "" {
+			if _, ok := contentDigestAlgorithms[userAlgorithm]; ok {
+				algorithm = userAlgorithm
+			}
+		}
+		if algorithm == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		dw := &contentDigestResponseWriter{
+			metaResponseWriter: &metaResponseWriter{w: w},
+			algorithm:          algorithm,
+		}
+		h.ServeHTTP(dw, r)
+		dw.finish()
+	})
+}
+
+// forcedStatusResponseWriter implements http.ResponseWriter in order to
+// replace the status code of every response, discarding the body if the
+// forced status does not allow one.
+type forcedStatusResponseWriter struct {
+	*metaResponseWriter
+	code     int
+	hijacked bool
+}
+
+func (fw *forcedStatusResponseWriter) WriteHeader(code int) {
+	if fw.status != 0 {
+		return
+	}
+	if !bodyAllowedForStatus(fw.code) {
+		fw.Header().Del("Content-Length")
+	}
+	fw.metaResponseWriter.WriteHeader(fw.code)
+}
+
+func (fw *forcedStatusResponseWriter) Write(b []byte) (int, error) {
+	fw.WriteHeader(fw.code)
+	if !bodyAllowedForStatus(fw.code) {
+		return len(b), nil
+	}
+	return fw.metaResponseWriter.Write(b)
+}
+
+func (fw *forcedStatusResponseWriter) Flush() {
+	fw.WriteHeader(fw.code)
+	fw.metaResponseWriter.Flush()
+}
+
+func (fw *forcedStatusResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	fw.hijacked = true
+	return fw.metaResponseWriter.Hijack()
+}
+
+// forceStatus makes every response use the given status code, regardless of
+// the status written by the handler
+func forceStatus(code int, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fw := &forcedStatusResponseWriter{
+			metaResponseWriter: &metaResponseWriter{w: w},
+			code:               code,
+		}
+		h.ServeHTTP(fw, r)
+		// handlers that write nothing would otherwise get an implicit 200 OK
+		if !fw.hijacked {
+			fw.WriteHeader(code)
+		}
+	})
+}
+
 // headResponseWriter implements http.ResponseWriter in order to discard the
 // body of the response
 type headResponseWriter struct {
@@ -63,6 +483,27 @@ func autohead(h http.Handler) http.Handler {
 	})
 }
 
+// connRequestsKey is the context key for a connection's request counter,
+// stashed by HTTPBin.ConnContext.
+type connRequestsKey struct{}
+
+// connRequestNumberKey is the context key for a request's position among the
+// requests received on its connection, starting at 1.
+type connRequestNumberKey struct{}
+
+// countConnRequests records each request's position among the requests
+// received on its connection, if the server was configured to count them via
+// HTTPBin.ConnContext.
+func countConnRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if counter, ok := r.Context().Value(connRequestsKey{}).(*atomic.Int64); ok {
+			n := counter.Add(1)
+			r = r.WithContext(context.WithValue(r.Context(), connRequestNumberKey{}, n))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
 // testMode enables additional safety checks to be enabled in the test suite.
 var testMode = false
 
@@ -112,20 +553,78 @@ func (mw *metaResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return mw.w.(http.Hijacker).Hijack()
 }
 
-func observe(o Observer, h http.Handler) http.Handler {
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (mw *metaResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.w
+}
+
+// recoveredPanicKey is the context key for a description of any panic
+// recovered while handling a request, stashed by observe so that
+// recoverPanics can fill it in.
+type recoveredPanicKey struct{}
+
+// observe calls the Observer with the result of each request that takes at
+// least the given threshold to handle, as measured by the given clock, or
+// that panics.
+func observe(o Observer, threshold time.Duration, now func() time.Time, h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		mw := &metaResponseWriter{w: w}
-		t := time.Now()
+		var recovered string
+		r = r.WithContext(context.WithValue(r.Context(), recoveredPanicKey{}, &recovered))
+		start := now()
+		defer func() {
+			// observe panics that were not recovered, too, before letting
+			// them continue on to the server
+			v := recover()
+			panicked := recovered
+			if v != nil {
+				panicked = fmt.Sprint(v)
+			}
+			duration := now().Sub(start)
+			if duration >= threshold || panicked != "" {
+				o(Result{
+					Status:    mw.Status(),
+					Method:    r.Method,
+					URI:       r.URL.RequestURI(),
+					Size:      mw.Size(),
+					Duration:  duration,
+					UserAgent: r.Header.Get("User-Agent"),
+					ClientIP:  getClientIP(r),
+					Panic:     panicked,
+				})
+			}
+			if v != nil {
+				panic(v)
+			}
+		}()
+		h.ServeHTTP(mw, r)
+	})
+}
+
+// recoverPanics recovers from any panic in a handler, responding with a 500
+// error instead of dropping the connection. If the request is wrapped by
+// observe, the panic is recorded so that it will be reported to the Observer.
+//
+// Panics with http.ErrAbortHandler, or after the response has been started,
+// are re-raised, since in those cases aborting the connection is the only
+// way to signal the failure to the client.
+func recoverPanics(writeError errorWriter, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mw := &metaResponseWriter{w: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler || mw.status != 0 || mw.size > 0 { //nolint:errorlint
+				panic(v)
+			}
+			if recovered, ok := r.Context().Value(recoveredPanicKey{}).(*string); ok {
+				*recovered = fmt.Sprint(v)
+			}
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("recovered from panic: %v", v))
+		}()
 		h.ServeHTTP(mw, r)
-		o(Result{
-			Status:    mw.Status(),
-			Method:    r.Method,
-			URI:       r.URL.RequestURI(),
-			Size:      mw.Size(),
-			Duration:  time.Since(t),
-			UserAgent: r.Header.Get("User-Agent"),
-			ClientIP:  getClientIP(r),
-		})
 	})
 }
 
@@ -138,6 +637,9 @@ type Result struct {
 	Duration  time.Duration
 	UserAgent string
 	ClientIP  string
+
+	// Panic describes any panic recovered while handling the request
+	Panic string
 }
 
 // Observer is a function that will be called with the details of a handled
@@ -154,10 +656,7 @@ func StdLogObserver(l *slog.Logger) Observer {
 		} else if result.Status >= 400 && result.Status < 500 {
 			logLevel = slog.LevelWarn
 		}
-		l.LogAttrs(
-			context.Background(),
-			logLevel,
-			fmt.Sprintf("%d %s %s %.1fms", result.Status, result.Method, result.URI, result.Duration.Seconds()*1e3),
+		attrs := []slog.Attr{
 			slog.Int("status", result.Status),
 			slog.String("method", result.Method),
 			slog.String("uri", result.URI),
@@ -165,6 +664,15 @@ func StdLogObserver(l *slog.Logger) Observer {
 			slog.Float64("duration_ms", result.Duration.Seconds()*1e3),
 			slog.String("user_agent", result.UserAgent),
 			slog.String("client_ip", result.ClientIP),
+		}
+		if result.Panic != "" {
+			attrs = append(attrs, slog.String("panic", result.Panic))
+		}
+		l.LogAttrs(
+			context.Background(),
+			logLevel,
+			fmt.Sprintf("%d %s %s %.1fms", result.Status, result.Method, result.URI, result.Duration.Seconds()*1e3),
+			attrs...,
 		)
 	}
 }
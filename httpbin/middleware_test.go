@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/mccutchen/go-httpbin/v2/internal/testing/assert"
 )
@@ -18,7 +19,7 @@ func TestTestMode(t *testing.T) {
 	// early after writing an error response, and has helped identify and fix
 	// some subtly broken error handling.
 	observer := func(r Result) {}
-	handler := observe(observer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := observe(observer, 0, time.Now, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		w.WriteHeader(http.StatusOK)
 	}))
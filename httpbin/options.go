@@ -2,7 +2,9 @@ package httpbin
 
 import (
 	"fmt"
-	"sort"
+	"io/fs"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -32,6 +34,89 @@ func WithMaxDuration(d time.Duration) OptionFunc {
 	}
 }
 
+// WithMaxResponseBodySize sets the maximum number of request body bytes
+// echoed back in responses, before any base64 encoding. Larger bodies are
+// truncated and flagged as such, and their parsed JSON is omitted. Zero means
+// the limit defaults to MaxBodySize, which never truncates.
+func WithMaxResponseBodySize(n int64) OptionFunc {
+	return func(h *HTTPBin) {
+		h.maxResponseBodySize = n
+	}
+}
+
+// WithMaxQueryParams sets the maximum number of distinct query params allowed
+// per request. Requests exceeding the limit are rejected with a 400 Bad
+// Request. Zero means unlimited.
+func WithMaxQueryParams(n int) OptionFunc {
+	return func(h *HTTPBin) {
+		h.maxQueryParams = n
+	}
+}
+
+// WithBodyReadTimeout sets the maximum time to wait for each read of a
+// request body, so that slow uploads are aborted with a 408 Request Timeout
+// independent of the server's ReadTimeout. Zero defers to the server's own
+// timeouts.
+func WithBodyReadTimeout(d time.Duration) OptionFunc {
+	return func(h *HTTPBin) {
+		h.bodyReadTimeout = d
+	}
+}
+
+// WithServerTimeouts sets the timeouts of the http.Server serving the
+// instance, which are reported alongside its own timeouts by the /config
+// endpoint.
+func WithServerTimeouts(t ServerTimeouts) OptionFunc {
+	return func(h *HTTPBin) {
+		h.serverTimeouts = t
+	}
+}
+
+// WithRequestIDHeader enables per-request IDs under the given header name,
+// which defaults to DefaultRequestIDHeader if empty. An ID given in the
+// request header is echoed on the response, otherwise a new one is generated.
+func WithRequestIDHeader(name string) OptionFunc {
+	return func(h *HTTPBin) {
+		if name == "" {
+			name = DefaultRequestIDHeader
+		}
+		h.requestIDHeader = http.CanonicalHeaderKey(name)
+	}
+}
+
+// WithEndpointTimeouts overrides MaxDuration for specific endpoints, keyed by
+// path (e.g. "/delay"). A key matches its exact path and any path beneath it,
+// with the longest matching key winning. Matching requests are bounded by the
+// override and given a deadline, after which they fail with 503 Service
+// Unavailable.
+func WithEndpointTimeouts(timeouts map[string]time.Duration) OptionFunc {
+	return func(h *HTTPBin) {
+		h.endpointTimeouts = make(map[string]time.Duration, len(timeouts))
+		for path, d := range timeouts {
+			h.endpointTimeouts["/"+strings.Trim(path, "/")] = d
+		}
+	}
+}
+
+// WithMaxWebSocketConnections limits the number of concurrent websocket
+// connections across the server. Connection attempts beyond the limit are
+// rejected with a 503 Service Unavailable before the handshake. Zero means
+// unlimited.
+func WithMaxWebSocketConnections(n int) OptionFunc {
+	return func(h *HTTPBin) {
+		h.maxWebSocketConns = int64(n)
+	}
+}
+
+// WithIdempotencyCache enables replaying the original response to requests
+// that repeat an Idempotency-Key header (along with the same method and URL)
+// within the given TTL. Streaming responses are never replayed.
+func WithIdempotencyCache(ttl time.Duration) OptionFunc {
+	return func(h *HTTPBin) {
+		h.idempotencyCache = newIdempotencyCache(ttl, idempotencyCacheMaxEntries, idempotencyCacheMaxBytes)
+	}
+}
+
 // WithHostname sets the hostname to return via the /hostname endpoint.
 func WithHostname(s string) OptionFunc {
 	return func(h *HTTPBin) {
@@ -39,6 +124,59 @@ func WithHostname(s string) OptionFunc {
 	}
 }
 
+// WithIncludeConnectionInfo sets whether /get and /anything responses include
+// a connection object describing the protocol, client IP, and any TLS version
+// and cipher suite of the connection the request was received on.
+func WithIncludeConnectionInfo(include bool) OptionFunc {
+	return func(h *HTTPBin) {
+		h.includeConnectionInfo = include
+	}
+}
+
+// WithForcedStatus makes every endpoint respond with the given status code,
+// along with its normal body if the status allows one, for testing clients
+// against a uniformly misbehaving server. Zero, the default, disables this.
+//
+// Panics if the code is neither zero nor in the range [200, 599], since
+// informational responses cannot stand in for a final response.
+func WithForcedStatus(code int) OptionFunc {
+	return func(h *HTTPBin) {
+		if code != 0 && (code < 200 || code > 599) {
+			panic(fmt.Sprintf("httpbin: invalid forced status code: %d not in range [200, 599]", code))
+		}
+		h.forcedStatus = code
+	}
+}
+
+// WithStatusTextOverrides sets the human-readable text used to describe the
+// given status codes in error responses, which otherwise default to the text
+// returned by http.StatusText (which is empty for non-standard codes).
+func WithStatusTextOverrides(overrides map[int]string) OptionFunc {
+	return func(h *HTTPBin) {
+		h.statusTextOverrides = make(map[int]string, len(overrides))
+		for code, text := range overrides {
+			h.statusTextOverrides[code] = text
+		}
+	}
+}
+
+// WithDenyContentType sets the content type of the /deny endpoint's response,
+// which defaults to plain text.
+func WithDenyContentType(contentType string) OptionFunc {
+	return func(h *HTTPBin) {
+		h.denyContentType = contentType
+	}
+}
+
+// WithRequestHook sets a callback that may mutate each incoming request (e.g.
+// to normalize its path or inject a header) before it is routed. The hook
+// sees the request before any prefix set via WithPrefix is stripped.
+func WithRequestHook(fn func(*http.Request)) OptionFunc {
+	return func(h *HTTPBin) {
+		h.requestHook = fn
+	}
+}
+
 // WithObserver sets the request observer callback
 func WithObserver(o Observer) OptionFunc {
 	return func(h *HTTPBin) {
@@ -46,6 +184,43 @@ func WithObserver(o Observer) OptionFunc {
 	}
 }
 
+// WithSlowRequestThreshold makes the Observer only be called for requests
+// that take at least the given duration to handle, to reduce log volume while
+// still surfacing latency outliers. Requests that panic are always observed.
+// Zero, the default, observes every request.
+func WithSlowRequestThreshold(d time.Duration) OptionFunc {
+	return func(h *HTTPBin) {
+		h.slowRequestThreshold = d
+	}
+}
+
+// WithPanicRecovery controls whether panics in handlers are recovered and
+// turned into 500 errors, rather than aborting the client's connection.
+// Recovery is enabled by default.
+func WithPanicRecovery(enabled bool) OptionFunc {
+	return func(h *HTTPBin) {
+		h.panicRecovery = enabled
+	}
+}
+
+// WithAdminToken enables the /admin/reload endpoint, which allows operators
+// to adjust MaxBodySize, MaxDuration, and AllowedRedirectDomains without a
+// restart. Requests must present the token as a bearer token in the
+// Authorization header. An empty token disables the admin endpoints.
+func WithAdminToken(token string) OptionFunc {
+	return func(h *HTTPBin) {
+		h.adminToken = token
+	}
+}
+
+// WithDebugEndpoints enables endpoints intended only for debugging a
+// deployment, like /panic, which are disabled by default.
+func WithDebugEndpoints(enabled bool) OptionFunc {
+	return func(h *HTTPBin) {
+		h.debugEndpoints = enabled
+	}
+}
+
 // WithEnv sets the HTTPBIN_-prefixed environment variables reported
 // by the /env endpoint.
 func WithEnv(env map[string]string) OptionFunc {
@@ -62,6 +237,58 @@ func WithExcludeHeaders(excludeHeaders string) OptionFunc {
 	}
 }
 
+// WithDefaultCompressionLevel sets the default compression level used by the
+// /gzip and /deflate endpoints. The level must be -1 (default compression) or
+// in the range [1, 9], and New panics otherwise.
+func WithDefaultCompressionLevel(level int) OptionFunc {
+	return func(h *HTTPBin) {
+		if _, err := parseCompressionLevel(strconv.Itoa(level)); err != nil {
+			panic(fmt.Sprintf("httpbin: invalid default compression level: %s", err))
+		}
+		h.compressionLevel = level
+	}
+}
+
+// WithCompactJSON sets whether JSON responses are compact rather than
+// pretty-printed by default. Clients may override this per-request via the
+// ?pretty query param.
+func WithCompactJSON(compact bool) OptionFunc {
+	return func(h *HTTPBin) {
+		h.compactJSON = compact
+	}
+}
+
+// WithContentDigest sets whether a SHA-256 Content-Digest header is added to
+// responses by default. Streaming responses are exempt.
+func WithContentDigest(enabled bool) OptionFunc {
+	return func(h *HTTPBin) {
+		h.contentDigest = enabled
+	}
+}
+
+// WithStaticDir serves the files in the given filesystem under the given path
+// prefix (e.g. "/static"), which is relative to any prefix set via WithPrefix.
+// The static prefix must not conflict with any existing endpoint.
+func WithStaticDir(fsys fs.FS, prefix string) OptionFunc {
+	return func(h *HTTPBin) {
+		h.staticFS = fsys
+		h.staticPrefix = "/" + strings.Trim(prefix, "/")
+	}
+}
+
+// WithRoutes registers additional handlers, keyed by http.ServeMux pattern
+// (e.g. "GET /custom/{id}"), which are served alongside the built-in endpoints
+// and wrapped by the same middleware. Patterns are relative to any prefix set
+// via WithPrefix. New panics if a pattern conflicts with a built-in endpoint.
+func WithRoutes(routes map[string]http.Handler) OptionFunc {
+	return func(h *HTTPBin) {
+		h.routes = make(map[string]http.Handler, len(routes))
+		for pattern, handler := range routes {
+			h.routes[pattern] = handler
+		}
+	}
+}
+
 // WithPrefix sets the path prefix
 func WithPrefix(p string) OptionFunc {
 	return func(h *HTTPBin) {
@@ -74,17 +301,9 @@ func WithPrefix(p string) OptionFunc {
 func WithAllowedRedirectDomains(hosts []string) OptionFunc {
 	return func(h *HTTPBin) {
 		hostSet := make(map[string]struct{}, len(hosts))
-		formattedListItems := make([]string, 0, len(hosts))
 		for _, host := range hosts {
 			hostSet[host] = struct{}{}
-			formattedListItems = append(formattedListItems, fmt.Sprintf("- %s", host))
 		}
 		h.AllowedRedirectDomains = hostSet
-
-		sort.Strings(formattedListItems)
-		h.forbiddenRedirectError = fmt.Sprintf(`Forbidden redirect URL. Please be careful with this link.
-
-Allowed redirect destinations:
-%s`, strings.Join(formattedListItems, "\n"))
 	}
 }
@@ -3,6 +3,7 @@ package httpbin
 import (
 	"net/http"
 	"net/url"
+	"time"
 )
 
 const (
@@ -19,6 +20,10 @@ type envResponse struct {
 
 type headersResponse struct {
 	Headers http.Header `json:"headers"`
+
+	// number of values received for each header, to verify that repeated
+	// headers survive intermediaries
+	Counts map[string]int `json:"counts,omitempty"`
 }
 
 type ipResponse struct {
@@ -29,6 +34,14 @@ type userAgentResponse struct {
 	UserAgent string `json:"user-agent"`
 }
 
+type userAgentParseResponse struct {
+	Raw       string `json:"raw"`
+	Family    string `json:"family"`
+	Version   string `json:"version"`
+	OS        string `json:"os"`
+	OSVersion string `json:"os_version"`
+}
+
 // A generic response for any incoming request that should not contain a body
 // (GET, HEAD, OPTIONS, etc).
 type noBodyResponse struct {
@@ -37,9 +50,28 @@ type noBodyResponse struct {
 	Method  string      `json:"method"`
 	Origin  string      `json:"origin"`
 	URL     string      `json:"url"`
+	Route   string      `json:"route,omitempty"`
+
+	Path    string `json:"path,omitempty"`
+	RawPath string `json:"raw_path,omitempty"`
+
+	TLSServerName string `json:"tls_server_name,omitempty"`
+
+	// the number of distinct query params and headers reported above, which
+	// only the /get and /anything endpoints include
+	QueryParamCount *int `json:"query_param_count,omitempty"`
+	HeaderCount     *int `json:"header_count,omitempty"`
+
+	Connection *connectionResponse `json:"connection,omitempty"`
 
+	// when the server began handling the request, to help clients correlate
+	// client- and server-side timing
+	ReceivedAt string `json:"received_at,omitempty"`
+
+	Brotli   bool `json:"brotli,omitempty"`
 	Deflated bool `json:"deflated,omitempty"`
 	Gzipped  bool `json:"gzipped,omitempty"`
+	Zstd     bool `json:"zstd,omitempty"`
 }
 
 // A generic response for any incoming request that might contain a body (POST,
@@ -50,15 +82,259 @@ type bodyResponse struct {
 	Method  string      `json:"method"`
 	Origin  string      `json:"origin"`
 	URL     string      `json:"url"`
+	Route   string      `json:"route,omitempty"`
+
+	Path    string `json:"path,omitempty"`
+	RawPath string `json:"raw_path,omitempty"`
+
+	TLSServerName string `json:"tls_server_name,omitempty"`
+
+	// the TLS client certificate chain presented via mTLS, which is empty
+	// for other connections and only included by the /anything endpoint
+	ClientCertChain *clientCertChainResponse `json:"client_cert_chain,omitempty"`
+
+	// the number of distinct query params and headers reported above, which
+	// only the /get and /anything endpoints include
+	QueryParamCount *int `json:"query_param_count,omitempty"`
+	HeaderCount     *int `json:"header_count,omitempty"`
+
+	Connection *connectionResponse `json:"connection,omitempty"`
+
+	// when the server began handling the request, to help clients correlate
+	// client- and server-side timing
+	ReceivedAt string `json:"received_at,omitempty"`
 
 	Data  string      `json:"data"`
 	Files url.Values  `json:"files"`
 	Form  url.Values  `json:"form"`
 	JSON  interface{} `json:"json"`
+
+	FilesMetadata []fileMetadataResponse `json:"files_metadata,omitempty"`
+
+	Truncated bool `json:"truncated,omitempty"`
+
+	// whether the parsed JSON body was omitted because the body exceeded
+	// the max response body size
+	JSONTruncated bool `json:"json_truncated,omitempty"`
+
+	RequestLine string `json:"request_line,omitempty"`
+
+	// the request serialized in HTTP/1.1 wire format, like /dump/request
+	Dump string `json:"dump,omitempty"`
+
+	// the Shannon entropy of the request body, in bits per byte
+	Entropy *float64 `json:"entropy,omitempty"`
+
+	// the numeric HTTP version of the request, which only the /anything
+	// endpoint includes
+	ProtoMajor *int `json:"proto_major,omitempty"`
+	ProtoMinor *int `json:"proto_minor,omitempty"`
+
+	// where the scheme of the reported URL came from, to help debug TLS
+	// termination by reverse proxies
+	SchemeSource string `json:"scheme_source,omitempty"`
+
+	ExpectContinue bool `json:"expect_continue,omitempty"`
+
+	// whether the connection was reused for this request, only reported if
+	// the server counts requests per connection via HTTPBin.ConnContext
+	ConnectionReused *bool `json:"connection_reused,omitempty"`
+
+	TransferEncoding []string `json:"transfer_encoding"`
+
+	ContentTypeParams map[string]string `json:"content_type_params,omitempty"`
+
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// the scheme of the Authorization header, which never includes the
+	// credential itself
+	AuthScheme *authSchemeResponse `json:"auth_scheme,omitempty"`
+
+	JWT      *jwtResponse `json:"jwt,omitempty"`
+	JWTError string       `json:"jwt_error,omitempty"`
+
+	HeadersHash string `json:"headers_hash,omitempty"`
+
+	Timing *timingResponse `json:"timing,omitempty"`
+
+	// measured duration of the /delay endpoint's delay, which may exceed the
+	// requested duration due to timer imprecision
+	ActualDelayMS float64 `json:"actual_delay_ms,omitempty"`
+
+	EncodingNegotiation *encodingNegotiationResponse `json:"encoding_negotiation,omitempty"`
+
+	// when the request body started and finished being read, recorded by
+	// parseBody for the timing breakdown
+	bodyReadStart time.Time
+	bodyReadEnd   time.Time
+
+	// the raw request body read by parseBody, for alternate encodings
+	rawBody []byte
+}
+
+// connectionResponse describes the connection a request was received on
+type connectionResponse struct {
+	Proto          string `json:"proto"`
+	ClientIP       string `json:"client_ip"`
+	TLSVersion     string `json:"tls_version,omitempty"`
+	TLSCipherSuite string `json:"tls_cipher_suite,omitempty"`
+}
+
+type clientCertChainResponse struct {
+	Length       int                 `json:"length"`
+	Certificates []clientCertificate `json:"certificates"`
+}
+
+type clientCertificate struct {
+	SubjectCN string `json:"subject_cn"`
+}
+
+// timingResponse is a breakdown, in milliseconds, of the time the server
+// spent handling a request
+type timingResponse struct {
+	BodyReadMS   float64 `json:"body_read_ms"`
+	ProcessingMS float64 `json:"processing_ms"`
+	TotalMS      float64 `json:"total_ms"`
+}
+
+// encodingNegotiationResponse describes the parsed Accept-Encoding header and
+// the Content-Encoding the server would choose in response to it
+type encodingNegotiationResponse struct {
+	Accepted []acceptedEncoding `json:"accepted"`
+	Chosen   string             `json:"chosen"`
+}
+
+type acceptedEncoding struct {
+	Coding string  `json:"coding"`
+	Q      float64 `json:"q"`
+}
+
+// harResponse is an HTTP Archive (HAR) log containing a single entry, which
+// describes only the incoming request.
+//
+// See http://www.softwareishard.com/blog/har-12-spec/
+type harResponse struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string     `json:"startedDateTime"`
+	Request         harRequest `json:"request"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// burstResponse summarizes the gaps between recent requests sharing a key
+type burstResponse struct {
+	Key       string  `json:"key"`
+	Count     int     `json:"count"`
+	MinGapMS  float64 `json:"min_gap_ms"`
+	MaxGapMS  float64 `json:"max_gap_ms"`
+	MeanGapMS float64 `json:"mean_gap_ms"`
+}
+
+// unstableResponse describes the outcome of a simulated /unstable failure
+type unstableResponse struct {
+	Status      int     `json:"status"`
+	Failed      bool    `json:"failed"`
+	FailureRate float64 `json:"failure_rate"`
+	Roll        float64 `json:"roll"`
+}
+
+type flakyResponse struct {
+	Key          string `json:"key"`
+	Status       int    `json:"status"`
+	Attempt      int    `json:"attempt"`
+	SuccessAfter int    `json:"success_after"`
+}
+
+type validateJSONResponse struct {
+	Valid  bool                  `json:"valid"`
+	Errors []jsonValidationError `json:"errors"`
+}
+
+type jsonValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+type webhookVerifyResponse struct {
+	Valid             bool   `json:"valid"`
+	Signature         string `json:"signature"`
+	ExpectedSignature string `json:"expected_signature"`
+}
+
+// adminReloadRequest describes the runtime limits to change, where omitted
+// fields are left unchanged.
+type adminReloadRequest struct {
+	MaxBodySize            *int64    `json:"max_body_size"`
+	MaxDuration            *string   `json:"max_duration"`
+	AllowedRedirectDomains *[]string `json:"allowed_redirect_domains"`
+}
+
+type adminReloadResponse struct {
+	MaxBodySize            int64    `json:"max_body_size"`
+	MaxDuration            string   `json:"max_duration"`
+	AllowedRedirectDomains []string `json:"allowed_redirect_domains"`
+}
+
+type authSchemeResponse struct {
+	Scheme            string `json:"scheme"`
+	CredentialPresent bool   `json:"credential_present"`
+}
+
+// The decoded, unverified contents of a JWT
+type jwtResponse struct {
+	Header map[string]interface{} `json:"header"`
+	Claims map[string]interface{} `json:"claims"`
 }
 
 type cookiesResponse map[string]string
 
+// rawCookiesResponse describes the cookies parsed from the raw Cookie header,
+// including any $-prefixed attributes sent by legacy RFC 2965 clients.
+type rawCookiesResponse struct {
+	Version string      `json:"version,omitempty"`
+	Cookies []rawCookie `json:"cookies"`
+}
+
+type rawCookie struct {
+	Name       string            `json:"name"`
+	Value      string            `json:"value"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
 type authResponse struct {
 	Authorized bool   `json:"authorized"`
 	User       string `json:"user"`
@@ -74,6 +350,15 @@ type streamResponse struct {
 	URL     string      `json:"url"`
 }
 
+type corsPreflightDebugResponse struct {
+	Origin         string      `json:"origin"`
+	RequestMethod  string      `json:"request_method"`
+	RequestHeaders string      `json:"request_headers"`
+	Path           string      `json:"path,omitempty"`
+	Allowed        bool        `json:"allowed"`
+	Headers        http.Header `json:"headers"`
+}
+
 type uuidResponse struct {
 	UUID string `json:"uuid"`
 }
@@ -83,6 +368,20 @@ type bearerResponse struct {
 	Token         string `json:"token"`
 }
 
+type configResponse struct {
+	Timeouts timeoutsResponse `json:"timeouts"`
+}
+
+// timeoutsResponse reports timeouts as duration strings, omitting any that
+// are not configured
+type timeoutsResponse struct {
+	MaxDuration          string `json:"max_duration"`
+	BodyReadTimeout      string `json:"body_read_timeout,omitempty"`
+	SrvReadTimeout       string `json:"srv_read_timeout,omitempty"`
+	SrvReadHeaderTimeout string `json:"srv_read_header_timeout,omitempty"`
+	SrvIdleTimeout       string `json:"srv_idle_timeout,omitempty"`
+}
+
 type hostnameResponse struct {
 	Hostname string `json:"hostname"`
 }
@@ -97,3 +396,68 @@ type serverSentEvent struct {
 	ID        int   `json:"id"`
 	Timestamp int64 `json:"timestamp"`
 }
+
+// jsonPatchOperation is a single RFC 6902 JSON Patch operation.
+type jsonPatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+type loadInfoResponse struct {
+	InFlightRequests int64  `json:"in_flight_requests"`
+	TotalRequests    int64  `json:"total_requests"`
+	Goroutines       int    `json:"goroutines"`
+	HeapAllocBytes   uint64 `json:"heap_alloc_bytes"`
+}
+
+// rangeResponse describes how a Range request header was parsed against a
+// resource of the given size, for /range/:n?echo_range=true.
+type rangeResponse struct {
+	Header      string              `json:"header"`
+	Size        int64               `json:"size"`
+	Satisfiable bool                `json:"satisfiable"`
+	Ranges      []byteRangeResponse `json:"ranges"`
+	Error       string              `json:"error,omitempty"`
+}
+
+// byteRangeResponse describes a single range-spec from a Range header. Start
+// and End are the inclusive byte positions a satisfiable range resolves to,
+// and are omitted for unsatisfiable ranges.
+type byteRangeResponse struct {
+	Spec        string `json:"spec"`
+	Suffix      bool   `json:"suffix"`
+	Satisfiable bool   `json:"satisfiable"`
+	Start       *int64 `json:"start,omitempty"`
+	End         *int64 `json:"end,omitempty"`
+}
+
+// diffStoredResponse is returned by /diff for the first request with a key.
+type diffStoredResponse struct {
+	Key    string `json:"key"`
+	Stored bool   `json:"stored"`
+}
+
+// diffResponse is returned by /diff for the second request with a key,
+// reporting only those parts of the two requests that differ.
+type diffResponse struct {
+	Key       string                         `json:"key"`
+	Identical bool                           `json:"identical"`
+	Method    *diffValue[string]             `json:"method,omitempty"`
+	URL       *diffValue[string]             `json:"url,omitempty"`
+	Headers   map[string]diffValue[[]string] `json:"headers,omitempty"`
+	Body      *diffValue[string]             `json:"body,omitempty"`
+}
+
+type diffValue[T any] struct {
+	First  T `json:"first"`
+	Second T `json:"second"`
+}
+
+// fileMetadataResponse describes a file uploaded in a multipart request body.
+type fileMetadataResponse struct {
+	Field       string `json:"field"`
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
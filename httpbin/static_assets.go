@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"embed"
 	"path"
+	"strings"
 	"text/template"
 )
 
@@ -25,7 +26,21 @@ func mustStaticAsset(name string) []byte {
 }
 
 func mustRenderTemplate(name string, data any) []byte {
-	t := template.Must(template.New(name).Parse(string(mustStaticAsset(name)))).Option("missingkey=error")
+	t := template.New(name)
+	t.Funcs(template.FuncMap{
+		// include renders the named template with the given data, or nothing
+		// if there is no such template
+		"include": func(name string, data any) (string, error) {
+			included := t.Lookup(name)
+			if included == nil {
+				return "", nil
+			}
+			var buf strings.Builder
+			err := included.Execute(&buf, data)
+			return buf.String(), err
+		},
+	})
+	t = template.Must(t.Parse(string(mustStaticAsset(name)))).Option("missingkey=error")
 	var buf bytes.Buffer
 	if err := t.Execute(&buf, data); err != nil {
 		panic(err)
@@ -11,7 +11,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 )
@@ -66,24 +68,92 @@ type Frame struct {
 type Message struct {
 	Binary  bool
 	Payload []byte
+	// FragmentCount is the number of frames the message was reassembled
+	// from, including the initial frame
+	FragmentCount int
 }
 
 // Handler handles a single websocket message. If the returned message is
 // non-nil, it will be sent to the client. If an error is returned, the
-// connection will be closed.
+// connection will be closed: normally if the error is ErrNormalClosure, with
+// the given status code if it is a *CloseError, and with a server error
+// otherwise.
 type Handler func(ctx context.Context, msg *Message) (*Message, error)
 
+// ErrNormalClosure may be returned by a Handler to close the connection
+// normally after sending the returned message, if any.
+var ErrNormalClosure = errors.New("websocket: normal closure")
+
+// CloseError may be returned by a Handler to close the connection with a
+// specific status code, using Err as the reason.
+type CloseError struct {
+	Code StatusCode
+	Err  error
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d: %s", e.Code, e.Err)
+}
+
+func (e *CloseError) Unwrap() error {
+	return e.Err
+}
+
+// RequireMessageType wraps a Handler to close the connection with
+// StatusUnsupported if a message is not of the required type.
+func RequireMessageType(binary bool, handler Handler) Handler {
+	return func(ctx context.Context, msg *Message) (*Message, error) {
+		if msg.Binary != binary {
+			kind := "text"
+			if msg.Binary {
+				kind = "binary"
+			}
+			return nil, &CloseError{Code: StatusUnsupported, Err: fmt.Errorf("%s messages are not allowed", kind)}
+		}
+		return handler(ctx, msg)
+	}
+}
+
+// CloseAfter wraps a Handler to close the connection normally once it has
+// handled n messages.
+func CloseAfter(n int, handler Handler) Handler {
+	var count int
+	return func(ctx context.Context, msg *Message) (*Message, error) {
+		resp, err := handler(ctx, msg)
+		count++
+		if err == nil && count >= n {
+			err = ErrNormalClosure
+		}
+		return resp, err
+	}
+}
+
 // EchoHandler is a Handler that echoes each incoming message back to the
 // client.
 var EchoHandler Handler = func(ctx context.Context, msg *Message) (*Message, error) {
 	return msg, nil
 }
 
+// FragmentCountEchoHandler is a Handler that echoes each incoming message back
+// to the client, prefixed with the number of frames it was reassembled from
+// and a single space (e.g. "3 hello").
+var FragmentCountEchoHandler Handler = func(ctx context.Context, msg *Message) (*Message, error) {
+	prefix := strconv.Itoa(msg.FragmentCount) + " "
+	return &Message{
+		Binary:        msg.Binary,
+		Payload:       append([]byte(prefix), msg.Payload...),
+		FragmentCount: msg.FragmentCount,
+	}, nil
+}
+
 // Limits define the limits imposed on a websocket connection.
 type Limits struct {
 	MaxDuration     time.Duration
 	MaxFragmentSize int
 	MaxMessageSize  int
+	// MaxTotalBytes limits the cumulative size of all message payloads
+	// received over the lifetime of the connection. Zero means unlimited.
+	MaxTotalBytes int
 }
 
 // WebSocket is a websocket connection.
@@ -93,9 +163,21 @@ type WebSocket struct {
 	maxDuration     time.Duration
 	maxFragmentSize int
 	maxMessageSize  int
+	maxTotalBytes   int
 	handshook       bool
+
+	// ready is closed once Serve has hijacked the connection, after which
+	// buf may be written to while holding mu
+	ready  chan struct{}
+	mu     sync.Mutex
+	buf    *bufio.ReadWriter
+	closed bool
 }
 
+// ErrClosed is returned by Send when the connection was never established
+// or has been closed.
+var ErrClosed = errors.New("websocket: connection closed")
+
 // New creates a new websocket.
 func New(w http.ResponseWriter, r *http.Request, limits Limits) *WebSocket {
 	return &WebSocket{
@@ -104,6 +186,8 @@ func New(w http.ResponseWriter, r *http.Request, limits Limits) *WebSocket {
 		maxDuration:     limits.MaxDuration,
 		maxFragmentSize: limits.MaxFragmentSize,
 		maxMessageSize:  limits.MaxMessageSize,
+		maxTotalBytes:   limits.MaxTotalBytes,
+		ready:           make(chan struct{}),
 	}
 }
 
@@ -153,6 +237,16 @@ func (s *WebSocket) Serve(handler Handler) {
 	}
 	defer conn.Close()
 
+	s.mu.Lock()
+	s.buf = buf
+	s.mu.Unlock()
+	close(s.ready)
+	defer func() {
+		s.mu.Lock()
+		s.closed = true
+		s.mu.Unlock()
+	}()
+
 	// best effort attempt to ensure that our websocket conenctions do not
 	// exceed the maximum request duration
 	conn.SetDeadline(time.Now().Add(s.maxDuration))
@@ -163,8 +257,55 @@ func (s *WebSocket) Serve(handler Handler) {
 	_ = s.serveLoop(s.r.Context(), buf, handler)
 }
 
+// Send writes a message to the client from outside of the connection's
+// Handler, waiting for Serve to take over the connection if necessary. It is
+// safe to call concurrently with Serve.
+func (s *WebSocket) Send(msg *Message) error {
+	select {
+	case <-s.ready:
+	case <-s.r.Context().Done():
+		return ErrClosed
+	}
+	return s.writeMessage(msg)
+}
+
+// writeMessage writes all of a message's frames to the wire, without
+// interleaving them with any concurrent writes.
+func (s *WebSocket) writeMessage(msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	for _, frame := range frameResponse(msg, s.maxFragmentSize) {
+		if err := writeFrame(s.buf, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFrame writes a single frame to the wire, without interleaving it with
+// any concurrent writes.
+func (s *WebSocket) writeFrame(frame *Frame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeFrame(s.buf, frame)
+}
+
+// writeCloseFrame writes a close frame to the wire, without interleaving it
+// with any concurrent writes.
+func (s *WebSocket) writeCloseFrame(code StatusCode, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeCloseFrame(s.buf, code, err)
+}
+
 func (s *WebSocket) serveLoop(ctx context.Context, buf *bufio.ReadWriter, handler Handler) error {
-	var currentMsg *Message
+	var (
+		currentMsg *Message
+		totalBytes int
+	)
 
 	for {
 		select {
@@ -173,71 +314,106 @@ func (s *WebSocket) serveLoop(ctx context.Context, buf *bufio.ReadWriter, handle
 		default:
 		}
 
-		frame, err := nextFrame(buf)
-		if err != nil {
-			return writeCloseFrame(buf, StatusServerError, err)
+		frame, err := nextFrame(buf, s.maxFragmentSize)
+		switch {
+		case errors.Is(err, errFrameTooLarge):
+			return s.writeCloseFrame(StatusTooLarge, err)
+		case errors.Is(err, errProtocol):
+			return s.writeCloseFrame(StatusProtocolError, err)
+		case err != nil:
+			return s.writeCloseFrame(StatusServerError, err)
 		}
 
-		if err := validateFrame(frame, s.maxFragmentSize); err != nil {
-			return writeCloseFrame(buf, StatusProtocolError, err)
+		if err := validateFrame(frame); err != nil {
+			return s.writeCloseFrame(StatusProtocolError, err)
 		}
 
 		switch frame.Opcode {
 		case OpcodeBinary, OpcodeText:
 			if currentMsg != nil {
-				return writeCloseFrame(buf, StatusProtocolError, errors.New("expected continuation frame"))
+				return s.writeCloseFrame(StatusProtocolError, errors.New("expected continuation frame"))
 			}
 			if frame.Opcode == OpcodeText && !utf8.Valid(frame.Payload) {
-				return writeCloseFrame(buf, StatusUnsupportedPayload, errors.New("invalid UTF-8"))
+				return s.writeCloseFrame(StatusUnsupportedPayload, errors.New("invalid UTF-8"))
 			}
 			currentMsg = &Message{
-				Binary:  frame.Opcode == OpcodeBinary,
-				Payload: frame.Payload,
+				Binary:        frame.Opcode == OpcodeBinary,
+				Payload:       frame.Payload,
+				FragmentCount: 1,
 			}
 		case OpcodeContinuation:
 			if currentMsg == nil {
-				return writeCloseFrame(buf, StatusProtocolError, errors.New("unexpected continuation frame"))
+				return s.writeCloseFrame(StatusProtocolError, errors.New("unexpected continuation frame"))
 			}
 			if !currentMsg.Binary && !utf8.Valid(frame.Payload) {
-				return writeCloseFrame(buf, StatusUnsupportedPayload, errors.New("invalid UTF-8"))
+				return s.writeCloseFrame(StatusUnsupportedPayload, errors.New("invalid UTF-8"))
 			}
 			currentMsg.Payload = append(currentMsg.Payload, frame.Payload...)
-			if len(currentMsg.Payload) > s.maxMessageSize {
-				return writeCloseFrame(buf, StatusTooLarge, fmt.Errorf("message size %d exceeds maximum of %d bytes", len(currentMsg.Payload), s.maxMessageSize))
-			}
+			currentMsg.FragmentCount++
 		case OpcodeClose:
-			return writeCloseFrame(buf, StatusNormalClosure, nil)
+			return s.writeCloseFrame(StatusNormalClosure, nil)
 		case OpcodePing:
 			frame.Opcode = OpcodePong
-			if err := writeFrame(buf, frame); err != nil {
+			if err := s.writeFrame(frame); err != nil {
 				return err
 			}
 			continue
 		case OpcodePong:
 			continue
 		default:
-			return writeCloseFrame(buf, StatusProtocolError, fmt.Errorf("unsupported opcode: %v", frame.Opcode))
+			return s.writeCloseFrame(StatusProtocolError, fmt.Errorf("unsupported opcode: %v", frame.Opcode))
+		}
+
+		if len(currentMsg.Payload) > s.maxMessageSize {
+			return s.writeCloseFrame(StatusTooLarge, fmt.Errorf("message size %d exceeds maximum of %d bytes", len(currentMsg.Payload), s.maxMessageSize))
+		}
+
+		totalBytes += len(frame.Payload)
+		if s.maxTotalBytes > 0 && totalBytes > s.maxTotalBytes {
+			return s.writeCloseFrame(StatusTooLarge, fmt.Errorf("total message size %d exceeds maximum of %d bytes", totalBytes, s.maxTotalBytes))
 		}
 
 		if frame.Fin {
 			resp, err := handler(ctx, currentMsg)
-			if err != nil {
-				return writeCloseFrame(buf, StatusServerError, err)
-			}
-			if resp == nil {
-				continue
+			currentMsg = nil
+			var closeErr *CloseError
+			switch {
+			case err == nil, errors.Is(err, ErrNormalClosure):
+			case errors.As(err, &closeErr):
+				return s.writeCloseFrame(closeErr.Code, closeErr.Err)
+			default:
+				return s.writeCloseFrame(StatusServerError, err)
 			}
-			for _, respFrame := range frameResponse(resp, s.maxFragmentSize) {
-				if err := writeFrame(buf, respFrame); err != nil {
+			if resp != nil {
+				if err := s.writeMessage(resp); err != nil {
 					return err
 				}
 			}
-			currentMsg = nil
+			if err != nil {
+				return s.writeCloseFrame(StatusNormalClosure, nil)
+			}
 		}
 	}
 }
 
-func nextFrame(buf *bufio.ReadWriter) (*Frame, error) {
+// maxControlPayloadSize is the maximum payload size of a control frame:
+// https://datatracker.ietf.org/doc/html/rfc6455#section-5.5
+const maxControlPayloadSize = 125
+
+var (
+	// errProtocol is wrapped by nextFrame's errors for frames that violate
+	// the protocol.
+	errProtocol = errors.New("protocol error")
+
+	// errFrameTooLarge is wrapped by nextFrame's errors for data frames whose
+	// payloads exceed the maximum fragment size.
+	errFrameTooLarge = errors.New("frame too large")
+)
+
+// nextFrame reads the next frame from the wire. Frames whose declared payload
+// length exceeds the limit for their opcode are rejected before any of the
+// payload is read.
+func nextFrame(buf *bufio.ReadWriter, maxFragmentSize int) (*Frame, error) {
 	bb := make([]byte, 2)
 	if _, err := io.ReadFull(buf, bb); err != nil {
 		return nil, err
@@ -257,7 +433,7 @@ func nextFrame(buf *bufio.ReadWriter) (*Frame, error) {
 	// Per https://datatracker.ietf.org/doc/html/rfc6455#section-5.2, all
 	// client frames must be masked.
 	if masked := b1 & 0b10000000; masked == 0 {
-		return nil, fmt.Errorf("received unmasked client frame")
+		return nil, fmt.Errorf("%w: received unmasked client frame", errProtocol)
 	}
 
 	var payloadLength uint64
@@ -279,6 +455,20 @@ func nextFrame(buf *bufio.ReadWriter) (*Frame, error) {
 		}
 	}
 
+	switch opcode {
+	case OpcodeContinuation, OpcodeText, OpcodeBinary:
+		if payloadLength > uint64(maxFragmentSize) {
+			return nil, fmt.Errorf("%w: frame payload size %d exceeds maximum of %d bytes", errFrameTooLarge, payloadLength, maxFragmentSize)
+		}
+	default:
+		// All control frames MUST have a payload length of 125 bytes or less.
+		// Unknown opcodes are rejected by validateFrame, so they need not be
+		// read in full.
+		if payloadLength > maxControlPayloadSize {
+			return nil, fmt.Errorf("%w: frame payload size %d exceeds %d bytes", errProtocol, payloadLength, maxControlPayloadSize)
+		}
+	}
+
 	mask := make([]byte, 4)
 	if _, err := io.ReadFull(buf, mask); err != nil {
 		return nil, err
@@ -420,7 +610,7 @@ var reservedStatusCodes = map[uint16]bool{
 	2999: true,
 }
 
-func validateFrame(frame *Frame, maxFragmentSize int) error {
+func validateFrame(frame *Frame) error {
 	// We do not support any extensions, per the spec all RSV bits must be 0:
 	// https://datatracker.ietf.org/doc/html/rfc6455#section-5.2
 	if frame.RSV1 || frame.RSV2 || frame.RSV3 {
@@ -428,17 +618,10 @@ func validateFrame(frame *Frame, maxFragmentSize int) error {
 	}
 
 	switch frame.Opcode {
-	case OpcodeContinuation, OpcodeText, OpcodeBinary:
-		if len(frame.Payload) > maxFragmentSize {
-			return fmt.Errorf("frame payload size %d exceeds maximum of %d bytes", len(frame.Payload), maxFragmentSize)
-		}
 	case OpcodeClose, OpcodePing, OpcodePong:
-		// All control frames MUST have a payload length of 125 bytes or less
-		// and MUST NOT be fragmented.
+		// All control frames MUST NOT be fragmented (their payload size is
+		// enforced by nextFrame).
 		// https://datatracker.ietf.org/doc/html/rfc6455#section-5.5
-		if len(frame.Payload) > 125 {
-			return fmt.Errorf("frame payload size %d exceeds 125 bytes", len(frame.Payload))
-		}
 		if !frame.Fin {
 			return fmt.Errorf("control frame %v must not be fragmented", frame.Opcode)
 		}
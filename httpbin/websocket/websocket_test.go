@@ -2,6 +2,7 @@ package websocket_test
 
 import (
 	"bufio"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
@@ -285,6 +286,84 @@ func TestConnectionLimits(t *testing.T) {
 		}
 	})
 
+	t.Run("maximum total bytes is enforced", func(t *testing.T) {
+		t.Parallel()
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ws := websocket.New(w, r, websocket.Limits{
+				MaxDuration:     time.Second,
+				MaxFragmentSize: 128,
+				MaxMessageSize:  256,
+				MaxTotalBytes:   10,
+			})
+			if err := ws.Handshake(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			ws.Serve(websocket.EchoHandler)
+		}))
+		defer srv.Close()
+
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		assert.NilError(t, err)
+		defer conn.Close()
+
+		reqParts := []string{
+			"GET /websocket/echo HTTP/1.1",
+			"Host: test",
+			"Connection: upgrade",
+			"Upgrade: websocket",
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==",
+			"Sec-WebSocket-Version: 13",
+		}
+		reqBytes := []byte(strings.Join(reqParts, "\r\n") + "\r\n\r\n")
+		_, err = conn.Write(reqBytes)
+		assert.NilError(t, err)
+
+		r := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(r, nil)
+		assert.NilError(t, err)
+		assert.StatusCode(t, resp, http.StatusSwitchingProtocols)
+
+		// writeTextFrame writes a single, final text frame with a zero mask,
+		// which leaves the payload unchanged
+		writeTextFrame := func(payload string) {
+			frame := append([]byte{0x81, 0x80 | byte(len(payload)), 0, 0, 0, 0}, payload...)
+			_, err := conn.Write(frame)
+			assert.NilError(t, err)
+		}
+
+		// readFrame reads a single small, unmasked server frame, returning its
+		// opcode and payload
+		readFrame := func() (byte, []byte) {
+			header := make([]byte, 2)
+			_, err := io.ReadFull(r, header)
+			assert.NilError(t, err)
+			payload := make([]byte, header[1]&0x7f)
+			_, err = io.ReadFull(r, payload)
+			assert.NilError(t, err)
+			return header[0] & 0x0f, payload
+		}
+
+		// messages totaling exactly the limit are echoed
+		for _, msg := range []string{"hello", "world"} {
+			writeTextFrame(msg)
+			opcode, payload := readFrame()
+			assert.Equal(t, opcode, byte(websocket.OpcodeText), "incorrect opcode")
+			assert.Equal(t, string(payload), msg, "incorrect echo")
+		}
+
+		// the next byte exceeds the limit and closes the connection
+		writeTextFrame("!")
+		opcode, payload := readFrame()
+		assert.Equal(t, opcode, byte(websocket.OpcodeClose), "expected close frame")
+		if len(payload) < 2 {
+			t.Fatalf("expected close frame to include status code, got %q", payload)
+		}
+		code := websocket.StatusCode(binary.BigEndian.Uint16(payload[:2]))
+		assert.Equal(t, code, websocket.StatusTooLarge, "incorrect close code")
+	})
+
 	t.Run("client closing connection", func(t *testing.T) {
 		t.Parallel()
 
@@ -372,6 +451,321 @@ func TestConnectionLimits(t *testing.T) {
 	})
 }
 
+func TestFragmentCountEchoHandler(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws := websocket.New(w, r, websocket.Limits{
+			MaxDuration:     time.Second,
+			MaxFragmentSize: 128,
+			MaxMessageSize:  256,
+		})
+		if err := ws.Handshake(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ws.Serve(websocket.FragmentCountEchoHandler)
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	assert.NilError(t, err)
+	defer conn.Close()
+
+	reqParts := []string{
+		"GET /websocket/echo HTTP/1.1",
+		"Host: test",
+		"Connection: upgrade",
+		"Upgrade: websocket",
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==",
+		"Sec-WebSocket-Version: 13",
+	}
+	reqBytes := []byte(strings.Join(reqParts, "\r\n") + "\r\n\r\n")
+	_, err = conn.Write(reqBytes)
+	assert.NilError(t, err)
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	assert.NilError(t, err)
+	assert.StatusCode(t, resp, http.StatusSwitchingProtocols)
+
+	// writeFrame writes a single small frame with a zero mask, which leaves
+	// the payload unchanged
+	writeFrame := func(fin bool, opcode websocket.Opcode, payload string) {
+		b0 := byte(opcode)
+		if fin {
+			b0 |= 0x80
+		}
+		frame := append([]byte{b0, 0x80 | byte(len(payload)), 0, 0, 0, 0}, payload...)
+		_, err := conn.Write(frame)
+		assert.NilError(t, err)
+	}
+
+	// readFrame reads a single small, unmasked server frame, returning its
+	// opcode and payload
+	readFrame := func() (byte, []byte) {
+		header := make([]byte, 2)
+		_, err := io.ReadFull(r, header)
+		assert.NilError(t, err)
+		payload := make([]byte, header[1]&0x7f)
+		_, err = io.ReadFull(r, payload)
+		assert.NilError(t, err)
+		return header[0] & 0x0f, payload
+	}
+
+	// a message split across three frames
+	writeFrame(false, websocket.OpcodeText, "hel")
+	writeFrame(false, websocket.OpcodeContinuation, "lo ")
+	writeFrame(true, websocket.OpcodeContinuation, "world")
+	opcode, payload := readFrame()
+	assert.Equal(t, opcode, byte(websocket.OpcodeText), "incorrect opcode")
+	assert.Equal(t, string(payload), "3 hello world", "incorrect echo")
+
+	// an unfragmented message
+	writeFrame(true, websocket.OpcodeText, "hi")
+	opcode, payload = readFrame()
+	assert.Equal(t, opcode, byte(websocket.OpcodeText), "incorrect opcode")
+	assert.Equal(t, string(payload), "1 hi", "incorrect echo")
+}
+
+func TestSend(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws := websocket.New(w, r, websocket.Limits{
+			MaxDuration:     time.Second,
+			MaxFragmentSize: 128,
+			MaxMessageSize:  256,
+		})
+		if err := ws.Handshake(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Send must wait for Serve to take over the connection
+		go func() {
+			_ = ws.Send(&websocket.Message{Payload: []byte("greetings")})
+		}()
+		ws.Serve(websocket.EchoHandler)
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	assert.NilError(t, err)
+	defer conn.Close()
+
+	reqParts := []string{
+		"GET /websocket/echo HTTP/1.1",
+		"Host: test",
+		"Connection: upgrade",
+		"Upgrade: websocket",
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==",
+		"Sec-WebSocket-Version: 13",
+	}
+	reqBytes := []byte(strings.Join(reqParts, "\r\n") + "\r\n\r\n")
+	_, err = conn.Write(reqBytes)
+	assert.NilError(t, err)
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	assert.NilError(t, err)
+	assert.StatusCode(t, resp, http.StatusSwitchingProtocols)
+
+	header := make([]byte, 2)
+	_, err = io.ReadFull(r, header)
+	assert.NilError(t, err)
+	payload := make([]byte, header[1]&0x7f)
+	_, err = io.ReadFull(r, payload)
+	assert.NilError(t, err)
+	assert.Equal(t, header[0]&0x0f, byte(websocket.OpcodeText), "incorrect opcode")
+	assert.Equal(t, string(payload), "greetings", "incorrect message")
+}
+
+// TestAutobahnCornerCases covers specific cases from the Autobahn testsuite,
+// so that they are exercised without the full docker-based integration test.
+func TestAutobahnCornerCases(t *testing.T) {
+	t.Parallel()
+
+	const maxMessageSize = 256
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws := websocket.New(w, r, websocket.Limits{
+			MaxDuration:     time.Second,
+			MaxFragmentSize: maxMessageSize,
+			MaxMessageSize:  maxMessageSize,
+		})
+		if err := ws.Handshake(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ws.Serve(websocket.EchoHandler)
+	}))
+	t.Cleanup(srv.Close)
+
+	// dial connects to the server and completes the handshake
+	dial := func(t *testing.T) (net.Conn, *bufio.Reader) {
+		t.Helper()
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		assert.NilError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		reqParts := []string{
+			"GET /websocket/echo HTTP/1.1",
+			"Host: test",
+			"Connection: upgrade",
+			"Upgrade: websocket",
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==",
+			"Sec-WebSocket-Version: 13",
+		}
+		_, err = conn.Write([]byte(strings.Join(reqParts, "\r\n") + "\r\n\r\n"))
+		assert.NilError(t, err)
+
+		r := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(r, nil)
+		assert.NilError(t, err)
+		assert.StatusCode(t, resp, http.StatusSwitchingProtocols)
+		return conn, r
+	}
+
+	// writeFrame writes a frame with a zero mask, which leaves the payload
+	// unchanged, declaring the given payload length
+	writeFrame := func(t *testing.T, conn net.Conn, fin bool, opcode websocket.Opcode, length uint64, payload []byte) {
+		t.Helper()
+		b0 := byte(opcode)
+		if fin {
+			b0 |= 0x80
+		}
+		frame := []byte{b0}
+		switch {
+		case length <= 125:
+			frame = append(frame, 0x80|byte(length))
+		case length <= 65535:
+			frame = append(frame, 0x80|126)
+			frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+		default:
+			frame = append(frame, 0x80|127)
+			frame = binary.BigEndian.AppendUint64(frame, length)
+		}
+		frame = append(frame, 0, 0, 0, 0)
+		frame = append(frame, payload...)
+		_, err := conn.Write(frame)
+		assert.NilError(t, err)
+	}
+
+	// readFrame reads a single unmasked server frame, returning its opcode and
+	// payload
+	readFrame := func(t *testing.T, r *bufio.Reader) (websocket.Opcode, []byte) {
+		t.Helper()
+		header := make([]byte, 2)
+		_, err := io.ReadFull(r, header)
+		assert.NilError(t, err)
+		length := uint64(header[1] & 0x7f)
+		switch length {
+		case 126:
+			var l uint16
+			assert.NilError(t, binary.Read(r, binary.BigEndian, &l))
+			length = uint64(l)
+		case 127:
+			assert.NilError(t, binary.Read(r, binary.BigEndian, &length))
+		}
+		payload := make([]byte, length)
+		_, err = io.ReadFull(r, payload)
+		assert.NilError(t, err)
+		return websocket.Opcode(header[0] & 0x0f), payload
+	}
+
+	// readClose reads a close frame, returning its status code
+	readClose := func(t *testing.T, r *bufio.Reader) websocket.StatusCode {
+		t.Helper()
+		opcode, payload := readFrame(t, r)
+		assert.Equal(t, opcode, websocket.OpcodeClose, "expected close frame")
+		if len(payload) < 2 {
+			t.Fatalf("expected close frame to include status code, got %q", payload)
+		}
+		return websocket.StatusCode(binary.BigEndian.Uint16(payload[:2]))
+	}
+
+	t.Run("fragmented ping fails (case 5.1)", func(t *testing.T) {
+		t.Parallel()
+		conn, r := dial(t)
+		writeFrame(t, conn, false, websocket.OpcodePing, 5, []byte("fragm"))
+		writeFrame(t, conn, true, websocket.OpcodeContinuation, 5, []byte("ented"))
+		assert.Equal(t, readClose(t, r), websocket.StatusProtocolError, "incorrect close code")
+	})
+
+	t.Run("fragmented close fails", func(t *testing.T) {
+		t.Parallel()
+		conn, r := dial(t)
+		writeFrame(t, conn, false, websocket.OpcodeClose, 2, []byte{0x03, 0xe8})
+		assert.Equal(t, readClose(t, r), websocket.StatusProtocolError, "incorrect close code")
+	})
+
+	t.Run("oversized control frame fails (case 2.5)", func(t *testing.T) {
+		t.Parallel()
+		conn, r := dial(t)
+		payload := []byte(strings.Repeat("x", 126))
+		writeFrame(t, conn, true, websocket.OpcodePing, uint64(len(payload)), payload)
+		assert.Equal(t, readClose(t, r), websocket.StatusProtocolError, "incorrect close code")
+	})
+
+	t.Run("ping interleaved in fragmented message (case 5.6)", func(t *testing.T) {
+		t.Parallel()
+		conn, r := dial(t)
+		writeFrame(t, conn, false, websocket.OpcodeText, 5, []byte("fragm"))
+		writeFrame(t, conn, true, websocket.OpcodePing, 4, []byte("ping"))
+		writeFrame(t, conn, true, websocket.OpcodeContinuation, 5, []byte("ented"))
+
+		opcode, payload := readFrame(t, r)
+		assert.Equal(t, opcode, websocket.OpcodePong, "expected pong frame")
+		assert.Equal(t, string(payload), "ping", "incorrect pong payload")
+
+		opcode, payload = readFrame(t, r)
+		assert.Equal(t, opcode, websocket.OpcodeText, "incorrect opcode")
+		assert.Equal(t, string(payload), "fragmented", "incorrect echo")
+	})
+
+	t.Run("message of maximum size is echoed (case 9.1)", func(t *testing.T) {
+		t.Parallel()
+		conn, r := dial(t)
+		payload := []byte(strings.Repeat("x", maxMessageSize))
+		writeFrame(t, conn, true, websocket.OpcodeText, uint64(len(payload)), payload)
+		opcode, echoed := readFrame(t, r)
+		assert.Equal(t, opcode, websocket.OpcodeText, "incorrect opcode")
+		assert.Equal(t, string(echoed), string(payload), "incorrect echo")
+	})
+
+	t.Run("frame exceeding maximum size fails", func(t *testing.T) {
+		t.Parallel()
+		conn, r := dial(t)
+		payload := []byte(strings.Repeat("x", maxMessageSize+1))
+		writeFrame(t, conn, true, websocket.OpcodeBinary, uint64(len(payload)), payload)
+		assert.Equal(t, readClose(t, r), websocket.StatusTooLarge, "incorrect close code")
+	})
+
+	t.Run("fragments exceeding maximum size fail", func(t *testing.T) {
+		t.Parallel()
+		conn, r := dial(t)
+		payload := []byte(strings.Repeat("x", maxMessageSize/2+1))
+		writeFrame(t, conn, false, websocket.OpcodeBinary, uint64(len(payload)), payload)
+		writeFrame(t, conn, true, websocket.OpcodeContinuation, uint64(len(payload)), payload)
+		assert.Equal(t, readClose(t, r), websocket.StatusTooLarge, "incorrect close code")
+	})
+
+	t.Run("huge declared length fails before payload is read", func(t *testing.T) {
+		t.Parallel()
+		conn, r := dial(t)
+		writeFrame(t, conn, true, websocket.OpcodeBinary, 1<<62, nil)
+		assert.Equal(t, readClose(t, r), websocket.StatusTooLarge, "incorrect close code")
+	})
+
+	t.Run("unmasked frame fails", func(t *testing.T) {
+		t.Parallel()
+		conn, r := dial(t)
+		_, err := conn.Write([]byte{0x81, 0x02, 'h', 'i'})
+		assert.NilError(t, err)
+		assert.Equal(t, readClose(t, r), websocket.StatusProtocolError, "incorrect close code")
+	})
+}
+
 // brokenHijackResponseWriter implements just enough to satisfy the
 // http.ResponseWriter and http.Hijacker interfaces and get through the
 // handshake before failing to actually hijack the connection.